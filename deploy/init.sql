@@ -33,6 +33,26 @@ CREATE TABLE public.announcements (
 
 ALTER TABLE public.announcements OWNER TO queue;
 
+--
+-- Name: api_tokens; Type: TABLE; Schema: public; Owner: queue
+--
+
+CREATE TABLE public.api_tokens (
+    id character(27) NOT NULL COLLATE pg_catalog."C",
+    email text NOT NULL,
+    user_name text NOT NULL,
+    first_name text NOT NULL,
+    name text NOT NULL,
+    course character(27) COLLATE pg_catalog."C",
+    token_hash text NOT NULL,
+    created_at timestamp with time zone DEFAULT now() NOT NULL,
+    expires_at timestamp with time zone,
+    last_used_at timestamp with time zone
+);
+
+
+ALTER TABLE public.api_tokens OWNER TO queue;
+
 --
 -- Name: appointment_schedules; Type: TABLE; Schema: public; Owner: queue
 --
@@ -48,6 +68,21 @@ CREATE TABLE public.appointment_schedules (
 
 ALTER TABLE public.appointment_schedules OWNER TO queue;
 
+--
+-- Name: appointment_schedule_overrides; Type: TABLE; Schema: public; Owner: queue
+--
+
+CREATE TABLE public.appointment_schedule_overrides (
+    queue character(27) NOT NULL COLLATE pg_catalog."C",
+    date date NOT NULL,
+    duration bigint NOT NULL,
+    padding bigint NOT NULL,
+    schedule text NOT NULL
+);
+
+
+ALTER TABLE public.appointment_schedule_overrides OWNER TO queue;
+
 --
 -- Name: appointment_slots; Type: TABLE; Schema: public; Owner: queue
 --
@@ -64,24 +99,110 @@ CREATE TABLE public.appointment_slots (
     location text,
     description text,
     map_x real,
-    map_y real
+    map_y real,
+    reminded_at timestamp with time zone,
+    outcome text,
+    requested_staff text,
+    appointment_type character(27) COLLATE pg_catalog."C"
 );
 
 
 ALTER TABLE public.appointment_slots OWNER TO queue;
 
+--
+-- Name: appointment_types; Type: TABLE; Schema: public; Owner: queue
+--
+
+CREATE TABLE public.appointment_types (
+    id character(27) NOT NULL COLLATE pg_catalog."C",
+    queue character(27) NOT NULL COLLATE pg_catalog."C",
+    name text NOT NULL,
+    duration integer NOT NULL,
+    capacity integer DEFAULT 0 NOT NULL
+);
+
+
+ALTER TABLE public.appointment_types OWNER TO queue;
+
+--
+-- Name: appointment_waitlist; Type: TABLE; Schema: public; Owner: queue
+--
+
+CREATE TABLE public.appointment_waitlist (
+    id character(27) NOT NULL COLLATE pg_catalog."C",
+    queue character(27) NOT NULL COLLATE pg_catalog."C",
+    student_email text NOT NULL,
+    scheduled_time timestamp with time zone NOT NULL,
+    timeslot integer NOT NULL,
+    duration integer NOT NULL,
+    name text NOT NULL,
+    location text NOT NULL,
+    description text NOT NULL,
+    map_x real NOT NULL,
+    map_y real NOT NULL,
+    appointment_type character(27) COLLATE pg_catalog."C"
+);
+
+
+ALTER TABLE public.appointment_waitlist OWNER TO queue;
+
+--
+-- Name: audit_log; Type: TABLE; Schema: public; Owner: queue
+--
+
+CREATE TABLE public.audit_log (
+    id character(27) NOT NULL COLLATE pg_catalog."C",
+    course character(27) NOT NULL COLLATE pg_catalog."C",
+    queue character(27) COLLATE pg_catalog."C",
+    actor text NOT NULL,
+    action text NOT NULL,
+    target text NOT NULL,
+    old_value json,
+    new_value json,
+    created_at timestamp with time zone DEFAULT now() NOT NULL
+);
+
+
+ALTER TABLE public.audit_log OWNER TO queue;
+
 --
 -- Name: course_admins; Type: TABLE; Schema: public; Owner: queue
 --
 
 CREATE TABLE public.course_admins (
     course character(27) NOT NULL COLLATE pg_catalog."C",
-    email text NOT NULL
+    email text NOT NULL,
+    role text DEFAULT 'instructor'::text NOT NULL
 );
 
 
 ALTER TABLE public.course_admins OWNER TO queue;
 
+--
+-- Name: course_groups; Type: TABLE; Schema: public; Owner: queue
+--
+
+CREATE TABLE public.course_groups (
+    course character(27) NOT NULL COLLATE pg_catalog."C",
+    group_name text NOT NULL,
+    role text DEFAULT 'ta'::text NOT NULL
+);
+
+
+ALTER TABLE public.course_groups OWNER TO queue;
+
+--
+-- Name: course_guests; Type: TABLE; Schema: public; Owner: queue
+--
+
+CREATE TABLE public.course_guests (
+    course character(27) NOT NULL COLLATE pg_catalog."C",
+    email text NOT NULL
+);
+
+
+ALTER TABLE public.course_guests OWNER TO queue;
+
 --
 -- Name: courses; Type: TABLE; Schema: public; Owner: queue
 --
@@ -89,7 +210,11 @@ ALTER TABLE public.course_admins OWNER TO queue;
 CREATE TABLE public.courses (
     id character(27) NOT NULL COLLATE pg_catalog."C",
     short_name text NOT NULL,
-    full_name text NOT NULL
+    full_name text NOT NULL,
+    term_start timestamp with time zone,
+    term_end timestamp with time zone,
+    archived_at timestamp with time zone,
+    term_synced boolean
 );
 
 
@@ -157,7 +282,16 @@ CREATE TABLE public.queues (
     manual_open boolean DEFAULT false NOT NULL,
     prompts json DEFAULT '[]'::json NOT NULL,
     type text NOT NULL,
-    name text NOT NULL
+    name text NOT NULL,
+    last_call integer DEFAULT 0 NOT NULL,
+    booking_horizon integer DEFAULT 7 NOT NULL,
+    appointment_reminder integer DEFAULT 15 NOT NULL,
+    no_show_limit integer DEFAULT 0 NOT NULL,
+    no_show_penalty integer DEFAULT 7 NOT NULL,
+    staff_selection boolean DEFAULT false NOT NULL,
+    archived_at timestamp with time zone,
+    sections text[] DEFAULT '{}'::text[] NOT NULL,
+    active_before_archive boolean
 );
 
 
@@ -168,8 +302,11 @@ ALTER TABLE public.queues OWNER TO queue;
 --
 
 CREATE TABLE public.roster (
-    queue character(27) NOT NULL COLLATE pg_catalog."C",
-    email text NOT NULL
+    course character(27) NOT NULL COLLATE pg_catalog."C",
+    email text NOT NULL,
+    name text DEFAULT ''::text NOT NULL,
+    student_id text DEFAULT ''::text NOT NULL,
+    section text DEFAULT ''::text NOT NULL
 );
 
 
@@ -188,6 +325,18 @@ CREATE TABLE public.schedules (
 
 ALTER TABLE public.schedules OWNER TO queue;
 
+--
+-- Name: site_admin_bootstrap; Type: TABLE; Schema: public; Owner: queue
+--
+
+CREATE TABLE public.site_admin_bootstrap (
+    email text NOT NULL,
+    bootstrapped_at timestamp with time zone DEFAULT now() NOT NULL
+);
+
+
+ALTER TABLE public.site_admin_bootstrap OWNER TO queue;
+
 --
 -- Name: site_admins; Type: TABLE; Schema: public; Owner: queue
 --
@@ -199,6 +348,41 @@ CREATE TABLE public.site_admins (
 
 ALTER TABLE public.site_admins OWNER TO queue;
 
+--
+-- Name: staff_notes; Type: TABLE; Schema: public; Owner: queue
+--
+
+CREATE TABLE public.staff_notes (
+    id character(27) NOT NULL COLLATE pg_catalog."C",
+    queue character(27) NOT NULL COLLATE pg_catalog."C",
+    email text NOT NULL,
+    entry character(27) COLLATE pg_catalog."C",
+    appointment character(27) COLLATE pg_catalog."C",
+    author text NOT NULL,
+    note text DEFAULT ''::text NOT NULL,
+    category text DEFAULT ''::text NOT NULL,
+    resolution text DEFAULT ''::text NOT NULL
+);
+
+
+ALTER TABLE public.staff_notes OWNER TO queue;
+
+--
+-- Name: user_sessions; Type: TABLE; Schema: public; Owner: queue
+--
+
+CREATE TABLE public.user_sessions (
+    id character(27) NOT NULL COLLATE pg_catalog."C",
+    email text NOT NULL,
+    session_key bytea NOT NULL,
+    ip text NOT NULL,
+    user_agent text NOT NULL,
+    created_at timestamp with time zone DEFAULT now() NOT NULL
+);
+
+
+ALTER TABLE public.user_sessions OWNER TO queue;
+
 --
 -- Name: teammates; Type: VIEW; Schema: public; Owner: -
 --
@@ -219,6 +403,22 @@ ALTER TABLE ONLY public.announcements
     ADD CONSTRAINT announcements_pkey PRIMARY KEY (id);
 
 
+--
+-- Name: api_tokens api_tokens_pkey; Type: CONSTRAINT; Schema: public; Owner: queue
+--
+
+ALTER TABLE ONLY public.api_tokens
+    ADD CONSTRAINT api_tokens_pkey PRIMARY KEY (id);
+
+
+--
+-- Name: api_tokens api_tokens_token_hash_key; Type: CONSTRAINT; Schema: public; Owner: queue
+--
+
+ALTER TABLE ONLY public.api_tokens
+    ADD CONSTRAINT api_tokens_token_hash_key UNIQUE (token_hash);
+
+
 --
 -- Name: appointment_schedules appointment_schedules_pkey; Type: CONSTRAINT; Schema: public; Owner: queue
 --
@@ -227,6 +427,14 @@ ALTER TABLE ONLY public.appointment_schedules
     ADD CONSTRAINT appointment_schedules_pkey PRIMARY KEY (queue, day);
 
 
+--
+-- Name: appointment_schedule_overrides appointment_schedule_overrides_pkey; Type: CONSTRAINT; Schema: public; Owner: queue
+--
+
+ALTER TABLE ONLY public.appointment_schedule_overrides
+    ADD CONSTRAINT appointment_schedule_overrides_pkey PRIMARY KEY (queue, date);
+
+
 --
 -- Name: appointment_slots appointment_slots_pkey; Type: CONSTRAINT; Schema: public; Owner: queue
 --
@@ -235,6 +443,38 @@ ALTER TABLE ONLY public.appointment_slots
     ADD CONSTRAINT appointment_slots_pkey PRIMARY KEY (id);
 
 
+--
+-- Name: appointment_types appointment_types_pkey; Type: CONSTRAINT; Schema: public; Owner: queue
+--
+
+ALTER TABLE ONLY public.appointment_types
+    ADD CONSTRAINT appointment_types_pkey PRIMARY KEY (id);
+
+
+--
+-- Name: appointment_waitlist appointment_waitlist_pkey; Type: CONSTRAINT; Schema: public; Owner: queue
+--
+
+ALTER TABLE ONLY public.appointment_waitlist
+    ADD CONSTRAINT appointment_waitlist_pkey PRIMARY KEY (id);
+
+
+--
+-- Name: appointment_waitlist appointment_waitlist_queue_student_email_scheduled_time_key; Type: CONSTRAINT; Schema: public; Owner: queue
+--
+
+ALTER TABLE ONLY public.appointment_waitlist
+    ADD CONSTRAINT appointment_waitlist_queue_student_email_scheduled_time_key UNIQUE (queue, student_email, scheduled_time);
+
+
+--
+-- Name: audit_log audit_log_pkey; Type: CONSTRAINT; Schema: public; Owner: queue
+--
+
+ALTER TABLE ONLY public.audit_log
+    ADD CONSTRAINT audit_log_pkey PRIMARY KEY (id);
+
+
 --
 -- Name: course_admins course_admins_course_email_key; Type: CONSTRAINT; Schema: public; Owner: queue
 --
@@ -243,6 +483,22 @@ ALTER TABLE ONLY public.course_admins
     ADD CONSTRAINT course_admins_pkey PRIMARY KEY (course, email);
 
 
+--
+-- Name: course_groups course_groups_pkey; Type: CONSTRAINT; Schema: public; Owner: queue
+--
+
+ALTER TABLE ONLY public.course_groups
+    ADD CONSTRAINT course_groups_pkey PRIMARY KEY (course, group_name);
+
+
+--
+-- Name: course_guests course_guests_pkey; Type: CONSTRAINT; Schema: public; Owner: queue
+--
+
+ALTER TABLE ONLY public.course_guests
+    ADD CONSTRAINT course_guests_pkey PRIMARY KEY (course, email);
+
+
 --
 -- Name: courses courses_pkey; Type: CONSTRAINT; Schema: public; Owner: queue
 --
@@ -276,11 +532,11 @@ ALTER TABLE ONLY public.queues
 
 
 --
--- Name: roster roster_queue_email_key; Type: CONSTRAINT; Schema: public; Owner: queue
+-- Name: roster roster_course_email_key; Type: CONSTRAINT; Schema: public; Owner: queue
 --
 
 ALTER TABLE ONLY public.roster
-    ADD CONSTRAINT roster_pkey PRIMARY KEY (queue, email);
+    ADD CONSTRAINT roster_pkey PRIMARY KEY (course, email);
 
 
 --
@@ -299,6 +555,60 @@ ALTER TABLE ONLY public.site_admins
     ADD CONSTRAINT site_admins_pkey PRIMARY KEY (email);
 
 
+--
+-- Name: staff_notes staff_notes_appointment_key; Type: CONSTRAINT; Schema: public; Owner: queue
+--
+
+ALTER TABLE ONLY public.staff_notes
+    ADD CONSTRAINT staff_notes_appointment_key UNIQUE (appointment);
+
+
+--
+-- Name: staff_notes staff_notes_entry_key; Type: CONSTRAINT; Schema: public; Owner: queue
+--
+
+ALTER TABLE ONLY public.staff_notes
+    ADD CONSTRAINT staff_notes_entry_key UNIQUE (entry);
+
+
+--
+-- Name: staff_notes staff_notes_pkey; Type: CONSTRAINT; Schema: public; Owner: queue
+--
+
+ALTER TABLE ONLY public.staff_notes
+    ADD CONSTRAINT staff_notes_pkey PRIMARY KEY (id);
+
+
+--
+-- Name: user_sessions user_sessions_pkey; Type: CONSTRAINT; Schema: public; Owner: queue
+--
+
+ALTER TABLE ONLY public.user_sessions
+    ADD CONSTRAINT user_sessions_pkey PRIMARY KEY (id);
+
+
+--
+-- Name: user_sessions user_sessions_session_key_key; Type: CONSTRAINT; Schema: public; Owner: queue
+--
+
+ALTER TABLE ONLY public.user_sessions
+    ADD CONSTRAINT user_sessions_session_key_key UNIQUE (session_key);
+
+
+--
+-- Name: api_tokens_email_idx; Type: INDEX; Schema: public; Owner: queue
+--
+
+CREATE INDEX api_tokens_email_idx ON public.api_tokens USING btree (email);
+
+
+--
+-- Name: audit_log_course_id_idx; Type: INDEX; Schema: public; Owner: queue
+--
+
+CREATE INDEX audit_log_course_id_idx ON public.audit_log USING btree (course, id);
+
+
 --
 -- Name: queue_entries_queue_idx; Type: INDEX; Schema: public; Owner: queue
 --
@@ -320,6 +630,20 @@ CREATE INDEX queue_entries_queue_removed_idx ON public.queue_entries USING btree
 CREATE INDEX queue_entries_queue_removed_removed_at_idx ON public.queue_entries USING btree (queue, removed, removed_at);
 
 
+--
+-- Name: staff_notes_queue_email_idx; Type: INDEX; Schema: public; Owner: queue
+--
+
+CREATE INDEX staff_notes_queue_email_idx ON public.staff_notes USING btree (queue, email);
+
+
+--
+-- Name: user_sessions_email_idx; Type: INDEX; Schema: public; Owner: queue
+--
+
+CREATE INDEX user_sessions_email_idx ON public.user_sessions USING btree (email);
+
+
 --
 -- Name: announcements announcements_queue_fkey; Type: FK CONSTRAINT; Schema: public; Owner: queue
 --
@@ -328,6 +652,14 @@ ALTER TABLE ONLY public.announcements
     ADD CONSTRAINT announcements_queue_fkey FOREIGN KEY (queue) REFERENCES public.queues(id) ON DELETE CASCADE;
 
 
+--
+-- Name: api_tokens api_tokens_course_fkey; Type: FK CONSTRAINT; Schema: public; Owner: queue
+--
+
+ALTER TABLE ONLY public.api_tokens
+    ADD CONSTRAINT api_tokens_course_fkey FOREIGN KEY (course) REFERENCES public.courses(id) ON DELETE CASCADE;
+
+
 --
 -- Name: appointment_schedules appointment_schedules_queue_fkey; Type: FK CONSTRAINT; Schema: public; Owner: queue
 --
@@ -336,6 +668,22 @@ ALTER TABLE ONLY public.appointment_schedules
     ADD CONSTRAINT appointment_schedules_queue_fkey FOREIGN KEY (queue) REFERENCES public.queues(id) ON DELETE CASCADE;
 
 
+--
+-- Name: appointment_schedule_overrides appointment_schedule_overrides_queue_fkey; Type: FK CONSTRAINT; Schema: public; Owner: queue
+--
+
+ALTER TABLE ONLY public.appointment_schedule_overrides
+    ADD CONSTRAINT appointment_schedule_overrides_queue_fkey FOREIGN KEY (queue) REFERENCES public.queues(id) ON DELETE CASCADE;
+
+
+--
+-- Name: appointment_slots appointment_slots_appointment_type_fkey; Type: FK CONSTRAINT; Schema: public; Owner: queue
+--
+
+ALTER TABLE ONLY public.appointment_slots
+    ADD CONSTRAINT appointment_slots_appointment_type_fkey FOREIGN KEY (appointment_type) REFERENCES public.appointment_types(id) ON DELETE SET NULL;
+
+
 --
 -- Name: appointment_slots appointment_slots_queue_fkey; Type: FK CONSTRAINT; Schema: public; Owner: queue
 --
@@ -344,6 +692,46 @@ ALTER TABLE ONLY public.appointment_slots
     ADD CONSTRAINT appointment_slots_queue_fkey FOREIGN KEY (queue) REFERENCES public.queues(id) ON DELETE CASCADE;
 
 
+--
+-- Name: appointment_types appointment_types_queue_fkey; Type: FK CONSTRAINT; Schema: public; Owner: queue
+--
+
+ALTER TABLE ONLY public.appointment_types
+    ADD CONSTRAINT appointment_types_queue_fkey FOREIGN KEY (queue) REFERENCES public.queues(id) ON DELETE CASCADE;
+
+
+--
+-- Name: appointment_waitlist appointment_waitlist_appointment_type_fkey; Type: FK CONSTRAINT; Schema: public; Owner: queue
+--
+
+ALTER TABLE ONLY public.appointment_waitlist
+    ADD CONSTRAINT appointment_waitlist_appointment_type_fkey FOREIGN KEY (appointment_type) REFERENCES public.appointment_types(id) ON DELETE CASCADE;
+
+
+--
+-- Name: appointment_waitlist appointment_waitlist_queue_fkey; Type: FK CONSTRAINT; Schema: public; Owner: queue
+--
+
+ALTER TABLE ONLY public.appointment_waitlist
+    ADD CONSTRAINT appointment_waitlist_queue_fkey FOREIGN KEY (queue) REFERENCES public.queues(id) ON DELETE CASCADE;
+
+
+--
+-- Name: audit_log audit_log_course_fkey; Type: FK CONSTRAINT; Schema: public; Owner: queue
+--
+
+ALTER TABLE ONLY public.audit_log
+    ADD CONSTRAINT audit_log_course_fkey FOREIGN KEY (course) REFERENCES public.courses(id) ON DELETE CASCADE;
+
+
+--
+-- Name: audit_log audit_log_queue_fkey; Type: FK CONSTRAINT; Schema: public; Owner: queue
+--
+
+ALTER TABLE ONLY public.audit_log
+    ADD CONSTRAINT audit_log_queue_fkey FOREIGN KEY (queue) REFERENCES public.queues(id) ON DELETE SET NULL;
+
+
 --
 -- Name: course_admins course_admins_course_fkey; Type: FK CONSTRAINT; Schema: public; Owner: queue
 --
@@ -352,6 +740,22 @@ ALTER TABLE ONLY public.course_admins
     ADD CONSTRAINT course_admins_course_fkey FOREIGN KEY (course) REFERENCES public.courses(id) ON DELETE CASCADE;
 
 
+--
+-- Name: course_groups course_groups_course_fkey; Type: FK CONSTRAINT; Schema: public; Owner: queue
+--
+
+ALTER TABLE ONLY public.course_groups
+    ADD CONSTRAINT course_groups_course_fkey FOREIGN KEY (course) REFERENCES public.courses(id) ON DELETE CASCADE;
+
+
+--
+-- Name: course_guests course_guests_course_fkey; Type: FK CONSTRAINT; Schema: public; Owner: queue
+--
+
+ALTER TABLE ONLY public.course_guests
+    ADD CONSTRAINT course_guests_course_fkey FOREIGN KEY (course) REFERENCES public.courses(id) ON DELETE CASCADE;
+
+
 --
 -- Name: groups groups_queue_fkey; Type: FK CONSTRAINT; Schema: public; Owner: queue
 --
@@ -377,11 +781,11 @@ ALTER TABLE ONLY public.queues
 
 
 --
--- Name: roster roster_queue_fkey; Type: FK CONSTRAINT; Schema: public; Owner: queue
+-- Name: roster roster_course_fkey; Type: FK CONSTRAINT; Schema: public; Owner: queue
 --
 
 ALTER TABLE ONLY public.roster
-    ADD CONSTRAINT roster_queue_fkey FOREIGN KEY (queue) REFERENCES public.queues(id) ON DELETE CASCADE;
+    ADD CONSTRAINT roster_course_fkey FOREIGN KEY (course) REFERENCES public.courses(id) ON DELETE CASCADE;
 
 
 --
@@ -392,6 +796,30 @@ ALTER TABLE ONLY public.schedules
     ADD CONSTRAINT schedules_queue_fkey FOREIGN KEY (queue) REFERENCES public.queues(id) ON DELETE CASCADE;
 
 
+--
+-- Name: staff_notes staff_notes_appointment_fkey; Type: FK CONSTRAINT; Schema: public; Owner: queue
+--
+
+ALTER TABLE ONLY public.staff_notes
+    ADD CONSTRAINT staff_notes_appointment_fkey FOREIGN KEY (appointment) REFERENCES public.appointment_slots(id) ON DELETE CASCADE;
+
+
+--
+-- Name: staff_notes staff_notes_entry_fkey; Type: FK CONSTRAINT; Schema: public; Owner: queue
+--
+
+ALTER TABLE ONLY public.staff_notes
+    ADD CONSTRAINT staff_notes_entry_fkey FOREIGN KEY (entry) REFERENCES public.queue_entries(id) ON DELETE CASCADE;
+
+
+--
+-- Name: staff_notes staff_notes_queue_fkey; Type: FK CONSTRAINT; Schema: public; Owner: queue
+--
+
+ALTER TABLE ONLY public.staff_notes
+    ADD CONSTRAINT staff_notes_queue_fkey FOREIGN KEY (queue) REFERENCES public.queues(id) ON DELETE CASCADE;
+
+
 --
 -- PostgreSQL database dump complete
 --
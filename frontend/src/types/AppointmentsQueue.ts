@@ -164,6 +164,29 @@ export class AppointmentsQueue extends Queue {
 				if (this.schedule !== undefined) {
 					this.schedule.updateAppointment(new Appointment(data));
 				}
+				break;
+			}
+			// Bulk claims and unclaims send all of their changes at once.
+			case 'APPOINTMENTS_CREATE': {
+				if (this.schedule !== undefined) {
+					data.forEach((a: any) =>
+						this.schedule?.addAppointment(new Appointment(a))
+					);
+				}
+				break;
+			}
+			case 'APPOINTMENTS_REMOVE': {
+				if (this.schedule !== undefined) {
+					data.forEach((a: any) => this.schedule?.removeAppointment(a['id']));
+				}
+				break;
+			}
+			case 'APPOINTMENTS_UPDATE': {
+				if (this.schedule !== undefined) {
+					data.forEach((a: any) =>
+						this.schedule?.updateAppointment(new Appointment(a))
+					);
+				}
 			}
 		}
 	}
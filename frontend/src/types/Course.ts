@@ -2,6 +2,13 @@ import Queue from './Queue';
 import OrderedQueue from './OrderedQueue';
 import { AppointmentsQueue } from './AppointmentsQueue';
 
+// A member of a course's staff. role is one of ta, head_ta, or
+// instructor; leaving it out keeps the role someone already has.
+export interface CourseStaff {
+	email: string;
+	role?: string;
+}
+
 export default class Course {
 	public readonly id: string;
 	public readonly shortName: string;
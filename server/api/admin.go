@@ -0,0 +1,140 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+type getSiteAdmins interface {
+	GetSiteAdmins(ctx context.Context) ([]string, error)
+}
+
+// GetSiteAdmins gets the emails of the site admins. Members of the site
+// admin groups from the configuration aren't listed, since they're only
+// known when they log in.
+func (s *Server) GetSiteAdmins(gs getSiteAdmins) E {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		admins, err := gs.GetSiteAdmins(r.Context())
+		if err != nil {
+			s.getCtxLogger(r).Errorw("failed to get site admins", "err", err)
+			return err
+		}
+
+		return s.sendResponse(http.StatusOK, admins, w, r)
+	}
+}
+
+// decodeSiteAdmins reads a list of emails from the request body.
+func decodeSiteAdmins(r *http.Request) ([]string, error) {
+	var admins []string
+	err := json.NewDecoder(r.Body).Decode(&admins)
+	if err != nil {
+		return nil, StatusError{
+			http.StatusBadRequest,
+			"I couldn't decode the body. Are you sure it's a JSON array of emails (strings)? This error might help: " + err.Error(),
+		}
+	}
+
+	for i, email := range admins {
+		admins[i] = strings.TrimSpace(email)
+		if !strings.Contains(admins[i], "@") {
+			return nil, StatusError{
+				http.StatusBadRequest,
+				"It looks like one of the admins isn't an email address.",
+			}
+		}
+	}
+
+	return admins, nil
+}
+
+type addSiteAdmins interface {
+	AddSiteAdmins(ctx context.Context, admins []string) error
+}
+
+func (s *Server) AddSiteAdmins(as addSiteAdmins) E {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		email := r.Context().Value(emailContextKey).(string)
+		l := s.getCtxLogger(r)
+
+		admins, err := decodeSiteAdmins(r)
+		if err != nil {
+			l.Warnw("failed to decode site admins from body", "err", err)
+			return err
+		}
+
+		err = as.AddSiteAdmins(r.Context(), admins)
+		if err != nil {
+			l.Errorw("failed to add site admins", "err", err)
+			return err
+		}
+
+		// Site admin changes don't belong to a course, so they aren't in
+		// the audit log; the logs are the record of who did what.
+		for _, admin := range admins {
+			l.Infow("added site admin", "actor", email, "target", admin)
+		}
+		return s.sendResponse(http.StatusNoContent, nil, w, r)
+	}
+}
+
+type removeSiteAdmins interface {
+	RemoveSiteAdmins(ctx context.Context, admins []string) error
+}
+
+// RemoveSiteAdmins takes away site admin from the given emails. Site
+// admins can't remove themselves, so there's always someone left who can
+// add admins back.
+func (s *Server) RemoveSiteAdmins(rs removeSiteAdmins) E {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		email := r.Context().Value(emailContextKey).(string)
+		l := s.getCtxLogger(r)
+
+		admins, err := decodeSiteAdmins(r)
+		if err != nil {
+			l.Warnw("failed to decode site admins from body", "err", err)
+			return err
+		}
+
+		for _, admin := range admins {
+			if strings.EqualFold(admin, email) {
+				l.Warnw("site admin attempted to remove themselves")
+				return StatusError{
+					http.StatusBadRequest,
+					"You can't remove yourself as a site admin; ask another site admin to do it.",
+				}
+			}
+		}
+
+		err = rs.RemoveSiteAdmins(r.Context(), admins)
+		if err != nil {
+			l.Errorw("failed to remove site admins", "err", err)
+			return err
+		}
+
+		for _, admin := range admins {
+			l.Infow("removed site admin", "actor", email, "target", admin)
+		}
+		return s.sendResponse(http.StatusNoContent, nil, w, r)
+	}
+}
+
+type getCourseOverviews interface {
+	GetCourseOverviews(ctx context.Context) ([]*CourseOverview, error)
+}
+
+// GetCourseOverviews lists every course on the site, archived or not,
+// along with its queues and staff.
+func (s *Server) GetCourseOverviews(gc getCourseOverviews) E {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		courses, err := gc.GetCourseOverviews(r.Context())
+		if err != nil {
+			s.getCtxLogger(r).Errorw("failed to get course overviews", "err", err)
+			return err
+		}
+
+		return s.sendResponse(http.StatusOK, courses, w, r)
+	}
+}
@@ -5,19 +5,27 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"sort"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/segmentio/ksuid"
+	"go.uber.org/zap"
 )
 
 const (
 	appointmentDayContextKey      = "appointment_day"
+	appointmentDateContextKey     = "appointment_date"
 	appointmentTimeslotContextKey = "appointment_timeslot"
 	appointmentContextKey         = "appointment"
+	appointmentTypeContextKey     = "appointment_type"
 )
 
+// The format of dates in appointment URLs.
+const appointmentDateFormat = "2006-01-02"
+
 func (s *Server) AppointmentDayMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		day, err := strconv.Atoi(chi.URLParam(r, "day"))
@@ -34,7 +42,34 @@ func (s *Server) AppointmentDayMiddleware(next http.Handler) http.Handler {
 			return
 		}
 
+		// Days of the week refer to their next occurrence, so handlers
+		// only need to deal with dates.
+		date, _ := WeekdayBounds(day)
+
 		ctx := context.WithValue(r.Context(), appointmentDayContextKey, day)
+		ctx = context.WithValue(ctx, appointmentDateContextKey, date)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+func (s *Server) AppointmentDateMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		date, err := time.ParseInLocation(appointmentDateFormat, chi.URLParam(r, "date"), time.Local)
+		if err != nil {
+			s.getCtxLogger(r).Warnw("failed to parse date",
+				"date", chi.URLParam(r, "date"),
+				"err", err,
+			)
+			s.errorMessage(
+				http.StatusNotFound,
+				"Are you sure that's a date?",
+				w, r,
+			)
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), appointmentDayContextKey, int(date.Weekday()))
+		ctx = context.WithValue(ctx, appointmentDateContextKey, date)
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
@@ -118,11 +153,11 @@ func (s *Server) GetAppointments(ga getAppointments) E {
 	return func(w http.ResponseWriter, r *http.Request) error {
 		q := r.Context().Value(queueContextKey).(*Queue)
 		admin := r.Context().Value(courseAdminContextKey).(bool)
-		day := r.Context().Value(appointmentDayContextKey).(int)
+		date := r.Context().Value(appointmentDateContextKey).(time.Time)
 
 		var appointments []*AppointmentSlot
 		var err error
-		start, end := WeekdayBounds(day)
+		start, end := DateBounds(date)
 		if admin {
 			appointments, err = ga.GetAppointments(r.Context(), q.ID, start, end)
 		} else {
@@ -148,15 +183,19 @@ func (s *Server) GetAppointmentsForCurrentUser(ga getAppointmentsForUser) E {
 	return func(w http.ResponseWriter, r *http.Request) error {
 		q := r.Context().Value(queueContextKey).(*Queue)
 		email := r.Context().Value(emailContextKey).(string)
-		day := r.Context().Value(appointmentDayContextKey).(int)
+		date := r.Context().Value(appointmentDateContextKey).(time.Time)
 
-		start, end := WeekdayBounds(day)
+		start, end := DateBounds(date)
 		appointments, err := ga.GetAppointmentsForUser(r.Context(), q.ID, start, end, email)
 		if err != nil {
-			s.getCtxLogger(r).Errorw("failed to get appointments for user", "day", day)
+			s.getCtxLogger(r).Errorw("failed to get appointments for user", "date", date, "err", err)
 			return err
 		}
 
+		for i, a := range appointments {
+			appointments[i] = a.NoStaffEmail()
+		}
+
 		return s.sendResponse(http.StatusOK, appointments, w, r)
 	}
 }
@@ -202,21 +241,31 @@ func (s *Server) GetAppointmentScheduleForDay(gs getAppointmentScheduleForDay) E
 }
 
 type claimTimeslot interface {
-	ClaimTimeslot(ctx context.Context, queue ksuid.KSUID, day, timeslot int, email string) (*AppointmentSlot, error)
+	ClaimTimeslot(ctx context.Context, queue ksuid.KSUID, date time.Time, timeslot int, email string) (*AppointmentSlot, error)
 }
 
 func (s *Server) ClaimTimeslot(cs claimTimeslot) E {
 	return func(w http.ResponseWriter, r *http.Request) error {
 		q := r.Context().Value(queueContextKey).(*Queue)
 		email := r.Context().Value(emailContextKey).(string)
-		day := r.Context().Value(appointmentDayContextKey).(int)
+		date := r.Context().Value(appointmentDateContextKey).(time.Time)
 		timeslot := r.Context().Value(appointmentTimeslotContextKey).(int)
 		l := s.getCtxLogger(r).With(
-			"day", day,
+			"date", date,
 			"timeslot", timeslot,
 		)
 
-		appointment, err := cs.ClaimTimeslot(r.Context(), q.ID, day, timeslot, email)
+		// The booking horizon only limits students; staff can claim
+		// timeslots as far ahead as they like.
+		if today, _ := DateBounds(time.Now()); date.Before(today) {
+			l.Warnw("attempted to claim timeslot in the past")
+			return StatusError{
+				http.StatusBadRequest,
+				"Appointments can't be claimed in the past.",
+			}
+		}
+
+		appointment, err := cs.ClaimTimeslot(r.Context(), q.ID, date, timeslot, email)
 		if err != nil {
 			l.Errorw("failed to claim timeslot", "err", err)
 			return StatusError{
@@ -233,279 +282,925 @@ func (s *Server) ClaimTimeslot(cs claimTimeslot) E {
 	}
 }
 
-type unclaimAppointment interface {
-	UnclaimAppointment(ctx context.Context, appointment ksuid.KSUID) (deleted bool, err error)
+// A range of timeslots, from From up to but not including To.
+type timeslotRange struct {
+	From int `json:"from"`
+	To   int `json:"to"`
 }
 
-func (s *Server) UnclaimAppointment(us unclaimAppointment) E {
+// decodeTimeslotRange reads a range of timeslots from the request body and
+// checks that it lies within schedule.
+func decodeTimeslotRange(r *http.Request, schedule *AppointmentSchedule) (*timeslotRange, error) {
+	var tr timeslotRange
+	err := json.NewDecoder(r.Body).Decode(&tr)
+	if err != nil {
+		return nil, StatusError{
+			http.StatusBadRequest,
+			"We couldn't read the range of timeslots in the request body.",
+		}
+	}
+
+	if tr.From < 0 || tr.From >= tr.To || tr.To > len(schedule.Schedule) {
+		return nil, StatusError{
+			http.StatusBadRequest,
+			fmt.Sprintf("The range of timeslots has to start before it ends and fit within the %d timeslots of the day.", len(schedule.Schedule)),
+		}
+	}
+
+	return &tr, nil
+}
+
+// TimeslotError is why a timeslot in a bulk operation couldn't be handled.
+type TimeslotError struct {
+	Timeslot int    `json:"timeslot"`
+	Message  string `json:"message"`
+}
+
+type claimTimeslots interface {
+	getAppointmentScheduleForDate
+	ClaimTimeslots(ctx context.Context, queue ksuid.KSUID, date time.Time, timeslots []int, email string) (claimed []*AppointmentSlot, failed map[int]error, err error)
+}
+
+func (s *Server) ClaimTimeslots(cs claimTimeslots) E {
 	return func(w http.ResponseWriter, r *http.Request) error {
 		q := r.Context().Value(queueContextKey).(*Queue)
-		appointment := r.Context().Value(appointmentContextKey).(*AppointmentSlot)
+		email := r.Context().Value(emailContextKey).(string)
+		date := r.Context().Value(appointmentDateContextKey).(time.Time)
+		l := s.getCtxLogger(r).With(
+			"date", date,
+		)
 
-		deleted, err := us.UnclaimAppointment(r.Context(), appointment.ID)
+		// The booking horizon only limits students; staff can claim
+		// timeslots as far ahead as they like.
+		if today, _ := DateBounds(time.Now()); date.Before(today) {
+			l.Warnw("attempted to claim timeslots in the past")
+			return StatusError{
+				http.StatusBadRequest,
+				"Appointments can't be claimed in the past.",
+			}
+		}
+
+		schedule, err := cs.GetAppointmentScheduleForDate(r.Context(), q.ID, date)
 		if err != nil {
-			s.getCtxLogger(r).Errorw("failed to remove appointment claim", "err", err)
+			l.Errorw("failed to get appointment schedule", "err", err)
 			return err
 		}
 
-		s.getCtxLogger(r).Infow("removed appointment claim")
+		tr, err := decodeTimeslotRange(r, schedule)
+		if err != nil {
+			l.Warnw("got invalid timeslot range", "err", err)
+			return err
+		}
 
-		if deleted {
-			s.ps.Pub(WS("APPOINTMENT_REMOVE", appointment), QueueTopicAdmin(q.ID))
-		} else {
-			appointment.StaffEmail = nil
-			s.ps.Pub(WS("APPOINTMENT_UPDATE", appointment), QueueTopicAdmin(q.ID))
+		timeslots := make([]int, 0, tr.To-tr.From)
+		for t := tr.From; t < tr.To; t++ {
+			timeslots = append(timeslots, t)
 		}
 
-		return s.sendResponse(http.StatusNoContent, nil, w, r)
+		claimed, failed, err := cs.ClaimTimeslots(r.Context(), q.ID, date, timeslots, email)
+		if err != nil {
+			l.Errorw("failed to claim timeslots", "err", err)
+			return err
+		}
+
+		failures := make([]TimeslotError, 0, len(failed))
+		for _, t := range timeslots {
+			if err, ok := failed[t]; ok {
+				failures = append(failures, TimeslotError{t, err.Error()})
+			}
+		}
+
+		l.Infow("claimed timeslots",
+			"from", tr.From,
+			"to", tr.To,
+			"claimed", len(claimed),
+			"failed", len(failures),
+		)
+
+		// Only announce the claims once they've all gone through, and all
+		// at once, so staff clients get one event for the whole range.
+		if len(claimed) > 0 {
+			s.ps.Pub(WS("APPOINTMENTS_CREATE", claimed), QueueTopicAdmin(q.ID))
+		}
+
+		return s.sendResponse(http.StatusOK, struct {
+			Claimed []*AppointmentSlot `json:"claimed"`
+			Failed  []TimeslotError    `json:"failed"`
+		}{claimed, failures}, w, r)
 	}
 }
 
-type updateAppointmentSchedule interface {
+type unclaimTimeslots interface {
+	getAppointmentScheduleForDate
 	getAppointmentsInTimeFrame
-	getAppointmentScheduleForDay
-	getAppointmentsByTimeslot
-	UpdateAppointmentSchedule(ctx context.Context, queue ksuid.KSUID, day int, schedule *AppointmentSchedule) error
+	unclaimAppointment
 }
 
-func (s *Server) UpdateAppointmentSchedule(us updateAppointmentSchedule) E {
+// UnclaimTimeslots gives up all of the current user's claims in a range
+// of timeslots.
+func (s *Server) UnclaimTimeslots(us unclaimTimeslots) E {
 	return func(w http.ResponseWriter, r *http.Request) error {
 		q := r.Context().Value(queueContextKey).(*Queue)
-		day := r.Context().Value(appointmentDayContextKey).(int)
+		email := r.Context().Value(emailContextKey).(string)
+		date := r.Context().Value(appointmentDateContextKey).(time.Time)
 		l := s.getCtxLogger(r).With(
-			"day", day,
+			"date", date,
 		)
 
-		currentSchedule, err := us.GetAppointmentScheduleForDay(r.Context(), q.ID, day)
+		schedule, err := us.GetAppointmentScheduleForDate(r.Context(), q.ID, date)
 		if err != nil {
-			l.Errorw("failed to get existing appointment schedule", "err", err)
+			l.Errorw("failed to get appointment schedule", "err", err)
 			return err
 		}
 
-		var schedule AppointmentSchedule
-		err = json.NewDecoder(r.Body).Decode(&schedule)
+		tr, err := decodeTimeslotRange(r, schedule)
 		if err != nil {
-			l.Warnw("failed to decode schedule from body", "err", err)
-			return StatusError{
-				http.StatusBadRequest,
-				"We couldn't read the schedule in the request body.",
-			}
+			l.Warnw("got invalid timeslot range", "err", err)
+			return err
 		}
 
-		from, to := WeekdayBounds(day)
+		from, to := DateBounds(date)
 		appointments, err := us.GetAppointments(r.Context(), q.ID, from, to)
 		if err != nil {
 			l.Errorw("failed to get appointments", "err", err)
 			return err
 		}
 
-		if len(appointments) > 0 && currentSchedule.Duration != schedule.Duration {
-			l.Warnw("appointment schedule duration update attempted with existing appointments")
-			return StatusError{
-				http.StatusConflict,
-				"You can't change the appointment duration with active or past appointments on this day.",
+		removed := make([]*AppointmentSlot, 0)
+		updated := make([]*AppointmentSlot, 0)
+		for _, a := range appointments {
+			if a.Timeslot < tr.From || a.Timeslot >= tr.To || a.StaffEmail == nil || *a.StaffEmail != email {
+				continue
 			}
-		}
 
-		for i, n := range schedule.Schedule {
-			currentTimeslotUsage, err := us.GetAppointmentsByTimeslot(r.Context(), q.ID, from, to, i)
+			deleted, err := us.UnclaimAppointment(r.Context(), a.ID)
 			if err != nil {
-				l.Errorw("failed to check appointments for timeslot", "err", err, "timeslot", i)
+				l.Errorw("failed to remove appointment claim", "appointment_id", a.ID, "err", err)
 				return err
 			}
 
-			newTimeslotAvailability := int(n - '0')
-			if newTimeslotAvailability < len(currentTimeslotUsage) {
-				l.Warnw("tried to change appointment schedule to one without room",
-					"conflicting_timeslot", i,
-					"current_appointments", len(currentTimeslotUsage),
-					"new_slots", newTimeslotAvailability,
-				)
-				return StatusError{
-					http.StatusConflict,
-					fmt.Sprintf("Setting that appointment schedule would remove an existing appointment. There are %d appointments at timeslot %d, but the new schedule only has %d slots at that time.",
-						len(currentTimeslotUsage), i, newTimeslotAvailability),
-				}
+			if deleted {
+				removed = append(removed, a)
+			} else {
+				a.StaffEmail = nil
+				updated = append(updated, a)
 			}
 		}
 
-		err = us.UpdateAppointmentSchedule(r.Context(), q.ID, day, &schedule)
-		if err != nil {
-			l.Errorw("failed to update appointment schedule", "err", err)
-			return err
-		}
-
-		l.Infow("updated appointment schedule")
+		l.Infow("removed appointment claims",
+			"from", tr.From,
+			"to", tr.To,
+			"unclaimed", len(removed)+len(updated),
+		)
 
-		s.ps.Pub(WS("REFRESH", nil), QueueTopicGeneric(q.ID))
+		// Like ClaimTimeslots, each kind of change goes out as one batch.
+		if len(removed) > 0 {
+			s.ps.Pub(WS("APPOINTMENTS_REMOVE", removed), QueueTopicAdmin(q.ID))
+		}
+		if len(updated) > 0 {
+			s.ps.Pub(WS("APPOINTMENTS_UPDATE", updated), QueueTopicAdmin(q.ID))
+		}
 
 		return s.sendResponse(http.StatusNoContent, nil, w, r)
 	}
 }
 
-type getAppointmentsByTimeslot interface {
-	GetAppointmentsByTimeslot(ctx context.Context, queue ksuid.KSUID, from, to time.Time, timeslot int) ([]*AppointmentSlot, error)
-}
-
-type signupForAppointment interface {
-	getQueueConfiguration
-	getAppointmentScheduleForDay
-	getAppointmentsForUser
-	getAppointmentsByTimeslot
-	UserInQueueRoster(ctx context.Context, queue ksuid.KSUID, email string) (bool, error)
-	TeammateHasAppointment(ctx context.Context, queue ksuid.KSUID, from, to time.Time, email string) (bool, error)
-	SignupForAppointment(ctx context.Context, queue ksuid.KSUID, appointment *AppointmentSlot) (*AppointmentSlot, error)
+type unclaimAppointment interface {
+	UnclaimAppointment(ctx context.Context, appointment ksuid.KSUID) (deleted bool, err error)
 }
 
-func (s *Server) SignupForAppointment(sa signupForAppointment) E {
+func (s *Server) UnclaimAppointment(us unclaimAppointment) E {
 	return func(w http.ResponseWriter, r *http.Request) error {
 		q := r.Context().Value(queueContextKey).(*Queue)
-		day := r.Context().Value(appointmentDayContextKey).(int)
-		timeslot := r.Context().Value(appointmentTimeslotContextKey).(int)
-		email := r.Context().Value(emailContextKey).(string)
-		name := r.Context().Value(nameContextKey).(string)
-		admin := r.Context().Value(courseAdminContextKey).(bool)
-		l := s.getCtxLogger(r).With(
-			"day", day,
-			"timeslot", timeslot,
-		)
+		appointment := r.Context().Value(appointmentContextKey).(*AppointmentSlot)
 
-		config, err := sa.GetQueueConfiguration(r.Context(), q.ID)
+		deleted, err := us.UnclaimAppointment(r.Context(), appointment.ID)
 		if err != nil {
-			l.Errorw("failed to get queue configuration", "err", err)
+			s.getCtxLogger(r).Errorw("failed to remove appointment claim", "err", err)
 			return err
 		}
 
-		if config.PreventUnregistered {
-			inRoster, err := sa.UserInQueueRoster(r.Context(), q.ID, email)
-			if err != nil {
-				l.Errorw("failed to get queue roster", "err", err)
-				return err
-			}
+		s.getCtxLogger(r).Infow("removed appointment claim")
 
-			if !inRoster {
-				l.Warnw("student not in queue roster attempted to sign up for appointment")
-				return StatusError{
-					http.StatusForbidden,
-					"It doesn't look like you're in the roster for this queue. Contact your course staff if you think this is a mistake!",
-				}
-			}
+		if deleted {
+			s.ps.Pub(WS("APPOINTMENT_REMOVE", appointment), QueueTopicAdmin(q.ID))
+		} else {
+			appointment.StaffEmail = nil
+			s.ps.Pub(WS("APPOINTMENT_UPDATE", appointment), QueueTopicAdmin(q.ID))
 		}
 
-		schedule, err := sa.GetAppointmentScheduleForDay(r.Context(), q.ID, day)
-		if err != nil {
-			l.Errorw("failed to get appointment schedule", "err", err)
-			return err
-		}
+		return s.sendResponse(http.StatusNoContent, nil, w, r)
+	}
+}
 
-		if config.PreventGroups {
-			// Check if a group member has a future or ongoing appointment
-			teammateHasAppointment, err := sa.TeammateHasAppointment(r.Context(), q.ID, time.Now().Add(-time.Minute*time.Duration(schedule.Duration)), BigTime(), email)
-			if err != nil {
-				l.Errorw("failed to get teammate appointments", "err", err)
-				return err
-			}
+type setAppointmentOutcome interface {
+	SetAppointmentOutcome(ctx context.Context, appointment ksuid.KSUID, outcome *AppointmentOutcome) error
+}
 
-			if teammateHasAppointment {
-				l.Warnw("student attempted to sign up for appointment with teammate on queue")
-				return StatusError{
-					http.StatusConflict,
-					"It looks like one of your group members already has an appointment!",
-				}
+func (s *Server) SetAppointmentOutcome(so setAppointmentOutcome) E {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		q := r.Context().Value(queueContextKey).(*Queue)
+		appointment := r.Context().Value(appointmentContextKey).(*AppointmentSlot)
+		email := r.Context().Value(emailContextKey).(string)
+		l := s.getCtxLogger(r)
+
+		if appointment.StaffEmail == nil || *appointment.StaffEmail != email {
+			l.Warnw("attempted to set outcome of appointment claimed by someone else")
+			return StatusError{
+				http.StatusForbidden,
+				"Only the staff member who claimed this appointment can say how it went.",
 			}
 		}
 
-		var appointment AppointmentSlot
-		err = json.NewDecoder(r.Body).Decode(&appointment)
-		if err != nil {
-			l.Warnw("failed to decode appointment", "err", err)
+		if appointment.StudentEmail == nil {
+			l.Warnw("attempted to set outcome of appointment without a student")
 			return StatusError{
 				http.StatusBadRequest,
-				"We couldn't read your appointment in the request body.",
+				"Nobody signed up for this appointment, so there's nothing to record.",
 			}
 		}
-		appointment.Name = &name
 
-		if appointment.Description == nil || appointment.Name == nil || appointment.Location == nil ||
-			*appointment.Description == "" || *appointment.Name == "" || *appointment.Location == "" {
-			l.Warnw("got incomplete appointment", "appointment", appointment)
+		if time.Now().Before(appointment.ScheduledTime) {
+			l.Warnw("attempted to set outcome of appointment in the future")
 			return StatusError{
 				http.StatusBadRequest,
-				"It looks like you left out some fields in the appointment.",
+				"This appointment hasn't started yet!",
 			}
 		}
 
-		if timeslot > len(schedule.Schedule) {
-			l.Warnw("attempted to sign up for non-existent timeslot", "num_slots", len(schedule.Schedule))
+		var body struct {
+			Outcome *AppointmentOutcome `json:"outcome"`
+		}
+		err := json.NewDecoder(r.Body).Decode(&body)
+		if err != nil {
+			l.Warnw("failed to decode outcome", "err", err)
 			return StatusError{
-				http.StatusNotFound,
-				"That timeslot doesn't exist!",
+				http.StatusBadRequest,
+				"We couldn't read the outcome in the request body.",
 			}
 		}
 
-		start, end := WeekdayBounds(day)
+		if body.Outcome != nil {
+			switch *body.Outcome {
+			case OutcomeAttended, OutcomeNoShow, OutcomeLateCancel:
+			default:
+				l.Warnw("got unknown appointment outcome", "outcome", *body.Outcome)
+				return StatusError{
+					http.StatusBadRequest,
+					fmt.Sprintf("We don't know the outcome %q.", *body.Outcome),
+				}
+			}
+		}
 
-		// First: check if there are any slots open at this timeslot
-		timeslotAppointments, err := sa.GetAppointmentsByTimeslot(r.Context(), q.ID, start, end, timeslot)
+		err = so.SetAppointmentOutcome(r.Context(), appointment.ID, body.Outcome)
 		if err != nil {
-			l.Errorw("failed to get appointments for timeslot", "err", err)
+			l.Errorw("failed to set appointment outcome", "err", err)
 			return err
 		}
 
-		open := int(schedule.Schedule[timeslot] - '0')
-		for _, a := range timeslotAppointments {
-			if a.StudentEmail != nil {
-				open--
-			}
-		}
+		l.Infow("set appointment outcome", "outcome", body.Outcome)
 
-		if open < 1 {
-			l.Warnw("no appointment slots available at timeslot")
-			return StatusError{
-				http.StatusConflict,
-				"There are no slots open at that time!",
-			}
-		}
+		appointment.Outcome = body.Outcome
+		s.ps.Pub(WS("APPOINTMENT_UPDATE", appointment), QueueTopicAdmin(q.ID))
+		s.ps.Pub(WS("APPOINTMENT_UPDATE", appointment.NoStaffEmail()), QueueTopicEmail(q.ID, *appointment.StudentEmail))
 
-		// Check if the user has an appointment starting in the future
-		// (or in the previous duration minutes, meaning they have an ongoing appointment)
-		startFutureCheck := time.Now().Add(-time.Duration(schedule.Duration) * time.Minute)
-		appointments, err := sa.GetAppointmentsForUser(r.Context(), q.ID, startFutureCheck, BigTime(), email)
+		return s.sendResponse(http.StatusNoContent, nil, w, r)
+	}
+}
+
+type updateAppointmentSchedule interface {
+	getQueueConfiguration
+	getAppointmentsInTimeFrame
+	getAppointmentScheduleForDay
+	getAppointmentScheduleForDate
+	promoteWaitlist
+	UpdateAppointmentSchedule(ctx context.Context, queue ksuid.KSUID, day int, schedule *AppointmentSchedule) error
+}
+
+func (s *Server) UpdateAppointmentSchedule(us updateAppointmentSchedule) E {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		q := r.Context().Value(queueContextKey).(*Queue)
+		day := r.Context().Value(appointmentDayContextKey).(int)
+		l := s.getCtxLogger(r).With(
+			"day", day,
+		)
+
+		currentSchedule, err := us.GetAppointmentScheduleForDay(r.Context(), q.ID, day)
 		if err != nil {
-			l.Errorw("failed to get future appointments for user", "err", err)
+			l.Errorw("failed to get existing appointment schedule", "err", err)
 			return err
 		}
 
-		if len(appointments) > 0 {
-			l.Warn("user attempted to sign up for appointment with one in future")
+		var schedule AppointmentSchedule
+		err = json.NewDecoder(r.Body).Decode(&schedule)
+		if err != nil {
+			l.Warnw("failed to decode schedule from body", "err", err)
 			return StatusError{
-				http.StatusConflict,
-				"You already have an appointment in the future!",
+				http.StatusBadRequest,
+				"We couldn't read the schedule in the request body.",
 			}
 		}
 
-		// Force some values that were previously validated by middleware
-		appointment.Queue = q.ID
-		appointment.Timeslot = timeslot
-		appointment.ScheduledTime = TimeslotToTime(day, timeslot, schedule.Duration)
-		appointment.Duration = schedule.Duration
-		appointment.StudentEmail = &email
-
-		var zero float32
-		if appointment.MapX == nil {
-			appointment.MapX = &zero
-		}
-		if appointment.MapY == nil {
-			appointment.MapY = &zero
+		err = validateAppointmentScheduleFormat(&schedule)
+		if err != nil {
+			l.Warnw("got malformed appointment schedule", "schedule", schedule)
+			return err
 		}
 
-		newAppointment, err := sa.SignupForAppointment(r.Context(), q.ID, &appointment)
+		err = s.validateWeeklyAppointmentSchedule(r.Context(), l, us, q.ID, day, currentSchedule, &schedule)
 		if err != nil {
-			l.Errorw("failed to sign up for appointment", "err", err)
 			return err
 		}
 
-		l.Infow("new appointment sign up",
+		err = us.UpdateAppointmentSchedule(r.Context(), q.ID, day, &schedule)
+		if err != nil {
+			l.Errorw("failed to update appointment schedule", "err", err)
+			return err
+		}
+
+		l.Infow("updated appointment schedule")
+		if err := s.audit(r, AuditUpdateAppointmentSchedule, time.Weekday(day).String(), currentSchedule, schedule); err != nil {
+			return err
+		}
+
+		// Added capacity goes to the waitlist first.
+		err = s.promoteWaitlist(r.Context(), l, us, q.ID, time.Now(), BigTime())
+		if err != nil {
+			return err
+		}
+
+		s.ps.Pub(WS("REFRESH", nil), QueueTopicGeneric(q.ID))
+
+		return s.sendResponse(http.StatusNoContent, nil, w, r)
+	}
+}
+
+// validateAppointmentScheduleFormat checks that a schedule has a digit
+// for each timeslot in the day.
+func validateAppointmentScheduleFormat(schedule *AppointmentSchedule) error {
+	if schedule.Duration <= 0 || (24*60)%schedule.Duration != 0 {
+		return StatusError{
+			http.StatusBadRequest,
+			"The appointment duration has to be a number of minutes that divides evenly into a day.",
+		}
+	}
+
+	if schedule.Padding < 0 || schedule.Padding >= schedule.Duration {
+		return StatusError{
+			http.StatusBadRequest,
+			"The padding has to be a number of minutes shorter than the appointment duration.",
+		}
+	}
+
+	if len(schedule.Schedule) != 24*60/schedule.Duration || strings.Trim(schedule.Schedule, "0123456789") != "" {
+		return StatusError{
+			http.StatusBadRequest,
+			fmt.Sprintf("The schedule needs a digit for each of the %d timeslots in the day.", 24*60/schedule.Duration),
+		}
+	}
+
+	return nil
+}
+
+// validateWeeklyAppointmentSchedule checks that replacing the weekly
+// appointment schedule for day won't strand any existing appointments on
+// the upcoming dates that follow it.
+func (s *Server) validateWeeklyAppointmentSchedule(ctx context.Context, l *zap.SugaredLogger, us updateAppointmentSchedule, queue ksuid.KSUID, day int, currentSchedule, schedule *AppointmentSchedule) error {
+	config, err := us.GetQueueConfiguration(ctx, queue)
+	if err != nil {
+		l.Errorw("failed to get queue configuration", "err", err)
+		return err
+	}
+
+	// Appointments can't be made past the booking horizon, but always
+	// check the next occurrence of the day, like we used to.
+	horizon := config.BookingHorizon
+	if horizon < 7 {
+		horizon = 7
+	}
+
+	first, _ := WeekdayBounds(day)
+	today, _ := DateBounds(time.Now())
+	for date := first; date.Before(today.AddDate(0, 0, horizon)); date = date.AddDate(0, 0, 7) {
+		effective, err := us.GetAppointmentScheduleForDate(ctx, queue, date)
+		if err != nil {
+			l.Errorw("failed to get appointment schedule for date", "date", date, "err", err)
+			return err
+		}
+
+		// Dates with their own schedule aren't affected.
+		if effective.Date != nil {
+			continue
+		}
+
+		err = s.validateAppointmentSchedule(ctx, l, us, queue, date, currentSchedule, schedule)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// validateAppointmentSchedule checks that replacing the appointment
+// schedule on date with schedule won't strand any existing appointments.
+// Problems with the schedule itself are returned as a StatusError.
+func (s *Server) validateAppointmentSchedule(ctx context.Context, l *zap.SugaredLogger, ga getAppointmentsInTimeFrame, queue ksuid.KSUID, date time.Time, currentSchedule, schedule *AppointmentSchedule) error {
+	from, to := DateBounds(date)
+	appointments, err := ga.GetAppointments(ctx, queue, from, to)
+	if err != nil {
+		l.Errorw("failed to get appointments", "err", err)
+		return err
+	}
+
+	if len(appointments) > 0 && currentSchedule.Duration != schedule.Duration {
+		l.Warnw("appointment schedule duration update attempted with existing appointments", "date", date)
+		return StatusError{
+			http.StatusConflict,
+			fmt.Sprintf("You can't change the appointment duration with active or past appointments on %s.", date.Format(appointmentDateFormat)),
+		}
+	}
+
+	timeslotUsage := TimeslotUsage(schedule, appointments, false)
+
+	for i, n := range schedule.Schedule {
+		newTimeslotAvailability := int(n - '0')
+		if newTimeslotAvailability < timeslotUsage[i] {
+			l.Warnw("tried to change appointment schedule to one without room",
+				"date", date,
+				"conflicting_timeslot", i,
+				"current_appointments", timeslotUsage[i],
+				"new_slots", newTimeslotAvailability,
+			)
+			return StatusError{
+				http.StatusConflict,
+				fmt.Sprintf("Setting that appointment schedule would remove an existing appointment. There are %d appointments at timeslot %d on %s, but the new schedule only has %d slots at that time.",
+					timeslotUsage[i], i, date.Format(appointmentDateFormat), newTimeslotAvailability),
+			}
+		}
+	}
+
+	return nil
+}
+
+type getAppointmentScheduleForDate interface {
+	GetAppointmentScheduleForDate(ctx context.Context, queue ksuid.KSUID, date time.Time) (*AppointmentSchedule, error)
+}
+
+func (s *Server) GetAppointmentScheduleForDate(gs getAppointmentScheduleForDate) E {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		q := r.Context().Value(queueContextKey).(*Queue)
+		date := r.Context().Value(appointmentDateContextKey).(time.Time)
+
+		schedule, err := gs.GetAppointmentScheduleForDate(r.Context(), q.ID, date)
+		if err != nil {
+			s.getCtxLogger(r).Errorw("failed to get appointment schedule",
+				"date", date,
+				"err", err,
+			)
+			return err
+		}
+
+		return s.sendResponse(http.StatusOK, schedule, w, r)
+	}
+}
+
+type appointmentScheduleOverride interface {
+	getAppointmentsInTimeFrame
+	getAppointmentScheduleForDay
+	getAppointmentScheduleForDate
+	promoteWaitlist
+	SetAppointmentScheduleOverride(ctx context.Context, queue ksuid.KSUID, date time.Time, schedule *AppointmentSchedule) error
+	RemoveAppointmentScheduleOverride(ctx context.Context, queue ksuid.KSUID, date time.Time) error
+}
+
+func (s *Server) UpdateAppointmentScheduleForDate(so appointmentScheduleOverride) E {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		q := r.Context().Value(queueContextKey).(*Queue)
+		date := r.Context().Value(appointmentDateContextKey).(time.Time)
+		l := s.getCtxLogger(r).With(
+			"date", date,
+		)
+
+		if today, _ := DateBounds(time.Now()); date.Before(today) {
+			l.Warnw("attempted to override appointment schedule in the past")
+			return StatusError{
+				http.StatusBadRequest,
+				"That day is already over, so there's no point in changing its schedule.",
+			}
+		}
+
+		currentSchedule, err := so.GetAppointmentScheduleForDate(r.Context(), q.ID, date)
+		if err != nil {
+			l.Errorw("failed to get existing appointment schedule", "err", err)
+			return err
+		}
+
+		var schedule AppointmentSchedule
+		err = json.NewDecoder(r.Body).Decode(&schedule)
+		if err != nil {
+			l.Warnw("failed to decode schedule from body", "err", err)
+			return StatusError{
+				http.StatusBadRequest,
+				"We couldn't read the schedule in the request body.",
+			}
+		}
+
+		err = validateAppointmentScheduleFormat(&schedule)
+		if err != nil {
+			l.Warnw("got malformed appointment schedule", "schedule", schedule)
+			return err
+		}
+
+		err = s.validateAppointmentSchedule(r.Context(), l, so, q.ID, date, currentSchedule, &schedule)
+		if err != nil {
+			return err
+		}
+
+		err = so.SetAppointmentScheduleOverride(r.Context(), q.ID, date, &schedule)
+		if err != nil {
+			l.Errorw("failed to set appointment schedule override", "err", err)
+			return err
+		}
+
+		l.Infow("set appointment schedule override")
+		if err := s.audit(r, AuditSetAppointmentOverride, date.Format("2006-01-02"), currentSchedule, schedule); err != nil {
+			return err
+		}
+
+		from, to := DateBounds(date)
+		err = s.promoteWaitlist(r.Context(), l, so, q.ID, from, to)
+		if err != nil {
+			return err
+		}
+
+		s.ps.Pub(WS("REFRESH", nil), QueueTopicGeneric(q.ID))
+
+		return s.sendResponse(http.StatusNoContent, nil, w, r)
+	}
+}
+
+func (s *Server) RemoveAppointmentScheduleForDate(so appointmentScheduleOverride) E {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		q := r.Context().Value(queueContextKey).(*Queue)
+		date := r.Context().Value(appointmentDateContextKey).(time.Time)
+		l := s.getCtxLogger(r).With(
+			"date", date,
+		)
+
+		currentSchedule, err := so.GetAppointmentScheduleForDate(r.Context(), q.ID, date)
+		if err != nil {
+			l.Errorw("failed to get existing appointment schedule", "err", err)
+			return err
+		}
+
+		// Nothing to remove; the date already follows the weekly schedule.
+		if currentSchedule.Date == nil {
+			return s.sendResponse(http.StatusNoContent, nil, w, r)
+		}
+
+		weeklySchedule, err := so.GetAppointmentScheduleForDay(r.Context(), q.ID, int(date.Weekday()))
+		if err != nil {
+			l.Errorw("failed to get weekly appointment schedule", "err", err)
+			return err
+		}
+
+		err = s.validateAppointmentSchedule(r.Context(), l, so, q.ID, date, currentSchedule, weeklySchedule)
+		if err != nil {
+			return err
+		}
+
+		err = so.RemoveAppointmentScheduleOverride(r.Context(), q.ID, date)
+		if err != nil {
+			l.Errorw("failed to remove appointment schedule override", "err", err)
+			return err
+		}
+
+		l.Infow("removed appointment schedule override")
+		if err := s.audit(r, AuditRemoveAppointmentOverride, date.Format("2006-01-02"), currentSchedule, weeklySchedule); err != nil {
+			return err
+		}
+
+		from, to := DateBounds(date)
+		err = s.promoteWaitlist(r.Context(), l, so, q.ID, from, to)
+		if err != nil {
+			return err
+		}
+
+		s.ps.Pub(WS("REFRESH", nil), QueueTopicGeneric(q.ID))
+
+		return s.sendResponse(http.StatusNoContent, nil, w, r)
+	}
+}
+
+type getAppointmentsByTimeslot interface {
+	GetAppointmentsByTimeslot(ctx context.Context, queue ksuid.KSUID, from, to time.Time, timeslot int) ([]*AppointmentSlot, error)
+}
+
+type checkAppointmentEligibility interface {
+	GetNoShows(ctx context.Context, queue ksuid.KSUID, email string) ([]time.Time, error)
+	UserInQueueRoster(ctx context.Context, queue ksuid.KSUID, email string) (bool, error)
+	UserInQueueSections(ctx context.Context, queue ksuid.KSUID, email string) (bool, error)
+	TeammateHasAppointment(ctx context.Context, queue ksuid.KSUID, from, to time.Time, email string) (bool, error)
+}
+
+// checkAppointmentEligibility makes sure that the user is allowed to book
+// an appointment on the queue at all, returning a StatusError saying why
+// not if they aren't. It's used for sign ups, joining waitlists, and
+// booking from waitlists, so that none of them can get around the others.
+// Staff booking for themselves aren't held to sections or no-shows.
+func (s *Server) checkAppointmentEligibility(ctx context.Context, l *zap.SugaredLogger, ce checkAppointmentEligibility, queue ksuid.KSUID, config *QueueConfiguration, schedule *AppointmentSchedule, email string, admin bool) error {
+	if config.PreventUnregistered {
+		inRoster, err := ce.UserInQueueRoster(ctx, queue, email)
+		if err != nil {
+			l.Errorw("failed to get queue roster", "err", err)
+			return err
+		}
+
+		if !inRoster {
+			l.Warnw("student not in queue roster attempted to book appointment")
+			return StatusError{
+				http.StatusForbidden,
+				"It doesn't look like you're on the roster for this course. Contact your course staff if you think this is a mistake!",
+			}
+		}
+	}
+
+	if !admin && len(config.Sections) > 0 {
+		inSections, err := ce.UserInQueueSections(ctx, queue, email)
+		if err != nil {
+			l.Errorw("failed to get roster section", "err", err)
+			return err
+		}
+
+		if !inSections {
+			l.Warnw("student outside queue sections attempted to book appointment", "sections", config.Sections)
+			return StatusError{
+				http.StatusForbidden,
+				"This queue is only for students in section " + strings.Join(config.Sections, ", ") + ". Contact your course staff if you think this is a mistake!",
+			}
+		}
+	}
+
+	if !admin && config.NoShowLimit > 0 {
+		noShows, err := ce.GetNoShows(ctx, queue, email)
+		if err != nil {
+			l.Errorw("failed to get no-shows", "err", err)
+			return err
+		}
+
+		if len(noShows) >= config.NoShowLimit {
+			until := noShows[0].AddDate(0, 0, config.NoShowPenalty)
+			if time.Now().Before(until) {
+				l.Warnw("student with too many no-shows attempted to book appointment",
+					"no_shows", len(noShows),
+					"until", until,
+				)
+				return StatusError{
+					http.StatusForbidden,
+					fmt.Sprintf("You've missed %d appointments on this queue, so you can't book another until %s.",
+						len(noShows), until.In(time.Local).Format("Monday, January 2 at 3:04 PM")),
+				}
+			}
+		}
+	}
+
+	if config.PreventGroups {
+		// Check if a group member has a future or ongoing appointment
+		teammateHasAppointment, err := ce.TeammateHasAppointment(ctx, queue, time.Now().Add(-time.Minute*time.Duration(schedule.Duration)), BigTime(), email)
+		if err != nil {
+			l.Errorw("failed to get teammate appointments", "err", err)
+			return err
+		}
+
+		if teammateHasAppointment {
+			l.Warnw("student attempted to book appointment with teammate on queue")
+			return StatusError{
+				http.StatusConflict,
+				"It looks like one of your group members already has an appointment!",
+			}
+		}
+	}
+
+	return nil
+}
+
+// appointmentDuration gets how long an appointment of the given type
+// lasts on the queue: a single timeslot for plain appointments, and as
+// long as the type says otherwise.
+func (s *Server) appointmentDuration(ctx context.Context, l *zap.SugaredLogger, gt getAppointmentType, queue ksuid.KSUID, schedule *AppointmentSchedule, appointmentType *ksuid.KSUID) (int, *AppointmentType, error) {
+	if appointmentType == nil {
+		return schedule.Duration, nil, nil
+	}
+
+	t, err := gt.GetAppointmentType(ctx, *appointmentType)
+	if err != nil || t.Queue != queue {
+		l.Warnw("attempted to book non-existent appointment type",
+			"appointment_type", *appointmentType,
+			"err", err,
+		)
+		return 0, nil, StatusError{
+			http.StatusNotFound,
+			"That appointment type doesn't exist!",
+		}
+	}
+	return t.Duration, t, nil
+}
+
+type signupForAppointment interface {
+	getCourse
+	getQueueConfiguration
+	getAppointmentScheduleForDate
+	getAppointmentsForUser
+	getAppointmentsInTimeFrame
+	getAppointmentType
+	checkAppointmentEligibility
+	SignupForAppointment(ctx context.Context, queue ksuid.KSUID, appointment *AppointmentSlot) (*AppointmentSlot, error)
+}
+
+func (s *Server) SignupForAppointment(sa signupForAppointment) E {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		q := r.Context().Value(queueContextKey).(*Queue)
+		date := r.Context().Value(appointmentDateContextKey).(time.Time)
+		timeslot := r.Context().Value(appointmentTimeslotContextKey).(int)
+		email := r.Context().Value(emailContextKey).(string)
+		name := r.Context().Value(nameContextKey).(string)
+		admin := r.Context().Value(courseAdminContextKey).(bool)
+		l := s.getCtxLogger(r).With(
+			"date", date,
+			"timeslot", timeslot,
+		)
+
+		course, err := sa.GetCourse(r.Context(), q.Course)
+		if err != nil {
+			l.Errorw("failed to get course", "err", err)
+			return err
+		}
+
+		if !admin && !course.InTerm(time.Now()) {
+			l.Warnw("student attempted to sign up for appointment outside of course term")
+			return StatusError{
+				http.StatusForbidden,
+				"This course isn't in session right now, so appointments are closed.",
+			}
+		}
+
+		config, err := sa.GetQueueConfiguration(r.Context(), q.ID)
+		if err != nil {
+			l.Errorw("failed to get queue configuration", "err", err)
+			return err
+		}
+
+		if !WithinBookingHorizon(date, config.BookingHorizon) {
+			l.Warnw("attempted to sign up for appointment outside of booking horizon")
+			return StatusError{
+				http.StatusBadRequest,
+				fmt.Sprintf("Appointments can only be made for the next %d days.", config.BookingHorizon),
+			}
+		}
+
+		schedule, err := sa.GetAppointmentScheduleForDate(r.Context(), q.ID, date)
+		if err != nil {
+			l.Errorw("failed to get appointment schedule", "err", err)
+			return err
+		}
+
+		err = s.checkAppointmentEligibility(r.Context(), l, sa, q.ID, config, schedule, email, admin)
+		if err != nil {
+			return err
+		}
+
+		var appointment AppointmentSlot
+		err = json.NewDecoder(r.Body).Decode(&appointment)
+		if err != nil {
+			l.Warnw("failed to decode appointment", "err", err)
+			return StatusError{
+				http.StatusBadRequest,
+				"We couldn't read your appointment in the request body.",
+			}
+		}
+		appointment.Name = &name
+
+		if appointment.Description == nil || appointment.Name == nil || appointment.Location == nil ||
+			*appointment.Description == "" || *appointment.Name == "" || *appointment.Location == "" {
+			l.Warnw("got incomplete appointment", "appointment", appointment)
+			return StatusError{
+				http.StatusBadRequest,
+				"It looks like you left out some fields in the appointment.",
+			}
+		}
+
+		if timeslot >= len(schedule.Schedule) {
+			l.Warnw("attempted to sign up for non-existent timeslot", "num_slots", len(schedule.Schedule))
+			return StatusError{
+				http.StatusNotFound,
+				"That timeslot doesn't exist!",
+			}
+		}
+
+		duration, appointmentType, err := s.appointmentDuration(r.Context(), l, sa, q.ID, schedule, appointment.AppointmentType)
+		if err != nil {
+			return err
+		}
+
+		if timeslot+TimeslotSpan(duration, schedule.Duration) > len(schedule.Schedule) {
+			l.Warnw("attempted to sign up for appointment running past end of day", "duration", duration)
+			return StatusError{
+				http.StatusBadRequest,
+				"That appointment would run past the end of the day.",
+			}
+		}
+
+		start, end := DateBounds(date)
+
+		// First: check if there's room for the appointment for as long as
+		// it runs
+		dayAppointments, err := sa.GetAppointments(r.Context(), q.ID, start, end)
+		if err != nil {
+			l.Errorw("failed to get appointments for day", "err", err)
+			return err
+		}
+
+		if !appointmentFits(schedule, dayAppointments, timeslot, duration, appointmentType) {
+			l.Warnw("no appointment slots available at timeslot")
+			return StatusError{
+				http.StatusConflict,
+				"There are no slots open at that time!",
+			}
+		}
+
+		if appointment.RequestedStaff != nil && *appointment.RequestedStaff == "" {
+			appointment.RequestedStaff = nil
+		}
+
+		if appointment.RequestedStaff != nil {
+			if !config.StaffSelection {
+				l.Warnw("attempted to request staff member on queue without staff selection")
+				return StatusError{
+					http.StatusBadRequest,
+					"This queue doesn't let you choose who you meet with.",
+				}
+			}
+
+			// Students ask for staff by the ID from GetStaffAvailability,
+			// which only matches staff with a claimed slot that day.
+			var requested *string
+			for _, a := range dayAppointments {
+				if a.StaffEmail != nil && StaffID(q.ID, *a.StaffEmail) == *appointment.RequestedStaff {
+					requested = a.StaffEmail
+					break
+				}
+			}
+
+			if requested == nil || !staffAvailable(schedule, dayAppointments, *requested, timeslot, duration) {
+				l.Warnw("requested staff member not available at timeslot", "requested_staff", *appointment.RequestedStaff)
+				return StatusError{
+					http.StatusConflict,
+					"That staff member doesn't have an open slot at that time.",
+				}
+			}
+			appointment.RequestedStaff = requested
+		}
+
+		// Check if the user has an appointment starting in the future
+		// (or in the previous duration minutes, meaning they have an ongoing appointment)
+		startFutureCheck := time.Now().Add(-time.Duration(schedule.Duration) * time.Minute)
+		appointments, err := sa.GetAppointmentsForUser(r.Context(), q.ID, startFutureCheck, BigTime(), email)
+		if err != nil {
+			l.Errorw("failed to get future appointments for user", "err", err)
+			return err
+		}
+
+		if len(appointments) > 0 {
+			l.Warn("user attempted to sign up for appointment with one in future")
+			return StatusError{
+				http.StatusConflict,
+				"You already have an appointment in the future!",
+			}
+		}
+
+		// Force some values that were previously validated by middleware
+		appointment.Queue = q.ID
+		appointment.Timeslot = timeslot
+		appointment.ScheduledTime = DateTimeslotToTime(date, timeslot, schedule.Duration)
+		appointment.Duration = duration
+		appointment.StudentEmail = &email
+
+		var zero float32
+		if appointment.MapX == nil {
+			appointment.MapX = &zero
+		}
+		if appointment.MapY == nil {
+			appointment.MapY = &zero
+		}
+
+		newAppointment, err := sa.SignupForAppointment(r.Context(), q.ID, &appointment)
+		if err != nil {
+			l.Errorw("failed to sign up for appointment", "err", err)
+			return err
+		}
+
+		l.Infow("new appointment sign up",
 			"appointment_id", newAppointment.ID,
 			"scheduled_time", appointment.ScheduledTime,
 		)
@@ -514,6 +1209,7 @@ func (s *Server) SignupForAppointment(sa signupForAppointment) E {
 		s.ps.Pub(WS("APPOINTMENT_CREATE", newAppointment.Anonymized()), QueueTopicNonPrivileged(q.ID))
 		if !admin {
 			s.ps.Pub(WS("APPOINTMENT_UPDATE", newAppointment.NoStaffEmail()), QueueTopicEmail(q.ID, email))
+			return s.sendResponse(http.StatusCreated, newAppointment.NoStaffEmail(), w, r)
 		}
 
 		return s.sendResponse(http.StatusCreated, newAppointment, w, r)
@@ -521,231 +1217,711 @@ func (s *Server) SignupForAppointment(sa signupForAppointment) E {
 }
 
 type removeAppointmentSignup interface {
+	promoteWaitlist
 	RemoveAppointmentSignup(ctx context.Context, appointment ksuid.KSUID) (deleted bool, newAppointment *AppointmentSlot, err error)
 }
 
-type updateAppointment interface {
-	getAppointmentsByTimeslot
-	getAppointmentScheduleForDay
-	signupForAppointment
-	removeAppointmentSignup
-	UpdateAppointment(ctx context.Context, appointment ksuid.KSUID, newAppointment *AppointmentSlot) error
+type updateAppointment interface {
+	rescheduleAppointment
+	UpdateAppointment(ctx context.Context, appointment ksuid.KSUID, newAppointment *AppointmentSlot) error
+}
+
+func (s *Server) UpdateAppointment(ua updateAppointment) E {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		q := r.Context().Value(queueContextKey).(*Queue)
+		a := r.Context().Value(appointmentContextKey).(*AppointmentSlot)
+		email := r.Context().Value(emailContextKey).(string)
+		name := r.Context().Value(nameContextKey).(string)
+		admin := r.Context().Value(courseAdminContextKey).(bool)
+		l := s.getCtxLogger(r)
+
+		if a.StudentEmail == nil {
+			l.Warnw("attempted to update deleted appointment")
+			return StatusError{
+				http.StatusNotFound,
+				"This appointment doesn't exist. Perhaps it was already deleted?",
+			}
+		}
+
+		if *a.StudentEmail != email {
+			l.Warnw("user attempted to update appointment with other email",
+				"expected_email", *a.StudentEmail,
+			)
+			return StatusError{
+				http.StatusForbidden,
+				"You can't update someone else's appointment!",
+			}
+		}
+
+		var newAppointment AppointmentSlot
+		err := json.NewDecoder(r.Body).Decode(&newAppointment)
+		if err != nil {
+			l.Warnw("failed to decode appointment", "err", err)
+			return StatusError{
+				http.StatusBadRequest,
+				"We couldn't read your appointment in the request body.",
+			}
+		}
+		newAppointment.Name = &name
+
+		if newAppointment.Description == nil || newAppointment.Name == nil || newAppointment.Location == nil ||
+			*newAppointment.Description == "" || *newAppointment.Name == "" || *newAppointment.Location == "" {
+			l.Warnw("got incomplete appointment", "appointment", newAppointment)
+			return StatusError{
+				http.StatusBadRequest,
+				"It looks like you left out some fields in the appointment.",
+			}
+		}
+
+		newAppointment.ID = a.ID
+		newAppointment.Queue = a.Queue
+		newAppointment.Duration = a.Duration
+		newAppointment.ScheduledTime = a.ScheduledTime
+		newAppointment.StudentEmail = &email
+		newAppointment.StaffEmail = a.StaffEmail
+		newAppointment.RequestedStaff = a.RequestedStaff
+		newAppointment.AppointmentType = a.AppointmentType
+
+		var zero float32
+		if newAppointment.MapX == nil {
+			newAppointment.MapX = &zero
+		}
+		if newAppointment.MapY == nil {
+			newAppointment.MapY = &zero
+		}
+
+		// We're not changing any times; simple.
+		if newAppointment.Timeslot == a.Timeslot {
+			err = ua.UpdateAppointment(r.Context(), a.ID, &newAppointment)
+			if err != nil {
+				l.Errorw("failed to update appointment", "err", err)
+				return err
+			}
+			l.Infow("updated appointment")
+
+			s.ps.Pub(WS("APPOINTMENT_UPDATE", &newAppointment), QueueTopicAdmin(q.ID))
+			if !admin {
+				s.ps.Pub(WS("APPOINTMENT_UPDATE", newAppointment.NoStaffEmail()), QueueTopicEmail(q.ID, email))
+			}
+
+			return s.sendResponse(http.StatusNoContent, nil, w, r)
+		}
+
+		// We're changing the appointment time, on the same day.
+		date, _ := DateBounds(a.ScheduledTime)
+		createdAppointment, err := s.rescheduleAppointment(r.Context(), l, ua, q, a, &newAppointment, date, admin)
+		if err != nil {
+			return err
+		}
+
+		return s.sendResponse(http.StatusCreated, createdAppointment, w, r)
+	}
+}
+
+type rescheduleAppointment interface {
+	getQueueConfiguration
+	getAppointmentScheduleForDate
+	getAppointmentsInTimeFrame
+	getAppointmentsForUser
+	getAppointmentType
+	promoteWaitlist
+	RescheduleAppointment(ctx context.Context, appointment ksuid.KSUID, target *AppointmentSlot) (newAppointment *AppointmentSlot, deleted bool, oldSlot *AppointmentSlot, err error)
+}
+
+// rescheduleAppointment moves appointment a to target's timeslot on date,
+// keeping the rest of target's fields. The old booking is given up and
+// the new one made in the same step, so the student is never without an
+// appointment or holding two.
+func (s *Server) rescheduleAppointment(ctx context.Context, l *zap.SugaredLogger, ra rescheduleAppointment, q *Queue, a, target *AppointmentSlot, date time.Time, admin bool) (*AppointmentSlot, error) {
+	l = l.With(
+		"date", date,
+		"timeslot", target.Timeslot,
+	)
+
+	if time.Now().After(a.ScheduledTime) {
+		l.Warnw("user attempted to reschedule appointment in the past")
+		return nil, StatusError{
+			http.StatusBadRequest,
+			"You can't reschedule an appointment that already happened! Let's try not to cause a paradox here.",
+		}
+	}
+
+	config, err := ra.GetQueueConfiguration(ctx, q.ID)
+	if err != nil {
+		l.Errorw("failed to get queue configuration", "err", err)
+		return nil, err
+	}
+
+	if !WithinBookingHorizon(date, config.BookingHorizon) {
+		l.Warnw("attempted to reschedule appointment outside of booking horizon")
+		return nil, StatusError{
+			http.StatusBadRequest,
+			fmt.Sprintf("Appointments can only be made for the next %d days.", config.BookingHorizon),
+		}
+	}
+
+	schedule, err := ra.GetAppointmentScheduleForDate(ctx, q.ID, date)
+	if err != nil {
+		l.Errorw("failed to get appointment schedule", "err", err)
+		return nil, err
+	}
+
+	if target.Timeslot < 0 || target.Timeslot >= len(schedule.Schedule) {
+		l.Warnw("attempted to reschedule appointment to non-existent timeslot", "num_slots", len(schedule.Schedule))
+		return nil, StatusError{
+			http.StatusNotFound,
+			"That timeslot doesn't exist!",
+		}
+	}
+
+	newTime := DateTimeslotToTime(date, target.Timeslot, schedule.Duration)
+	if time.Now().After(newTime) {
+		l.Warnw("user attempted to reschedule appointment to past", "new_time", newTime)
+		return nil, StatusError{
+			http.StatusBadRequest,
+			"You can't change your appointment to the past! Let us know if you have a time machine.",
+		}
+	}
+
+	// The student can only hold this one appointment in the future.
+	appointments, err := ra.GetAppointmentsForUser(ctx, q.ID, time.Now().Add(-time.Duration(schedule.Duration)*time.Minute), BigTime(), *a.StudentEmail)
+	if err != nil {
+		l.Errorw("failed to get future appointments for user", "err", err)
+		return nil, err
+	}
+
+	for _, other := range appointments {
+		if other.ID != a.ID {
+			l.Warnw("user attempted to reschedule appointment with another in future", "other_appointment_id", other.ID)
+			return nil, StatusError{
+				http.StatusConflict,
+				"You already have another appointment in the future!",
+			}
+		}
+	}
+
+	start, end := DateBounds(date)
+	dayAppointments, err := ra.GetAppointments(ctx, q.ID, start, end)
+	if err != nil {
+		l.Errorw("failed to get appointments for day", "err", err)
+		return nil, err
+	}
+
+	// The appointment being moved doesn't stand in its own way.
+	others := make([]*AppointmentSlot, 0, len(dayAppointments))
+	for _, other := range dayAppointments {
+		if other.ID != a.ID {
+			others = append(others, other)
+		}
+	}
+
+	var appointmentType *AppointmentType
+	if a.AppointmentType != nil {
+		appointmentType, err = ra.GetAppointmentType(ctx, *a.AppointmentType)
+		if err != nil {
+			l.Errorw("failed to get appointment type", "err", err)
+			return nil, err
+		}
+	}
+
+	if !appointmentFits(schedule, others, target.Timeslot, a.Duration, appointmentType) {
+		l.Warnw("no appointment slots available at timeslot")
+		return nil, StatusError{
+			http.StatusConflict,
+			"There are no slots open at that time!",
+		}
+	}
+
+	if a.RequestedStaff != nil && !staffAvailable(schedule, others, *a.RequestedStaff, target.Timeslot, a.Duration) {
+		l.Warnw("requested staff member not available at timeslot", "requested_staff", *a.RequestedStaff)
+		return nil, StatusError{
+			http.StatusConflict,
+			"Your requested staff member doesn't have an open slot at that time.",
+		}
+	}
+
+	target.Queue = q.ID
+	target.ScheduledTime = newTime
+	target.Duration = a.Duration
+	target.StudentEmail = a.StudentEmail
+	target.StaffEmail = nil
+	target.RequestedStaff = a.RequestedStaff
+	target.AppointmentType = a.AppointmentType
+	target.RemindedAt = nil
+	target.Outcome = nil
+
+	createdAppointment, deleted, oldSlot, err := ra.RescheduleAppointment(ctx, a.ID, target)
+	if err != nil {
+		l.Errorw("failed to reschedule appointment", "err", err)
+		return nil, err
+	}
+
+	l.Infow("rescheduled appointment",
+		"new_appointment_id", createdAppointment.ID,
+		"scheduled_time", createdAppointment.ScheduledTime,
+	)
+
+	if deleted {
+		s.ps.Pub(WS("APPOINTMENT_REMOVE", a.Anonymized()), QueueTopicGeneric(q.ID))
+	} else {
+		s.ps.Pub(WS("APPOINTMENT_UPDATE", oldSlot), QueueTopicAdmin(q.ID))
+		s.ps.Pub(WS("APPOINTMENT_REMOVE", a.Anonymized()), QueueTopicNonPrivileged(q.ID))
+	}
+
+	s.ps.Pub(WS("APPOINTMENT_CREATE", createdAppointment), QueueTopicAdmin(q.ID))
+	s.ps.Pub(WS("APPOINTMENT_CREATE", createdAppointment.Anonymized()), QueueTopicNonPrivileged(q.ID))
+	if !admin {
+		s.ps.Pub(WS("APPOINTMENT_UPDATE", createdAppointment.NoStaffEmail()), QueueTopicEmail(q.ID, *a.StudentEmail))
+	}
+
+	// The old timeslot has room again.
+	from, to := DateBounds(a.ScheduledTime)
+	err = s.promoteWaitlist(ctx, l, ra, q.ID, from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	return createdAppointment, nil
+}
+
+func (s *Server) RescheduleAppointment(ra rescheduleAppointment) E {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		q := r.Context().Value(queueContextKey).(*Queue)
+		a := r.Context().Value(appointmentContextKey).(*AppointmentSlot)
+		email := r.Context().Value(emailContextKey).(string)
+		admin := r.Context().Value(courseAdminContextKey).(bool)
+		l := s.getCtxLogger(r)
+
+		if a.StudentEmail == nil {
+			l.Warnw("attempted to reschedule deleted appointment")
+			return StatusError{
+				http.StatusNotFound,
+				"This appointment doesn't exist. Perhaps it was already deleted?",
+			}
+		}
+
+		if *a.StudentEmail != email {
+			l.Warnw("user attempted to reschedule appointment with other email",
+				"expected_email", *a.StudentEmail,
+			)
+			return StatusError{
+				http.StatusForbidden,
+				"You can't reschedule someone else's appointment!",
+			}
+		}
+
+		var body struct {
+			Date     string `json:"date"`
+			Timeslot int    `json:"timeslot"`
+		}
+		err := json.NewDecoder(r.Body).Decode(&body)
+		if err != nil {
+			l.Warnw("failed to decode reschedule request", "err", err)
+			return StatusError{
+				http.StatusBadRequest,
+				"We couldn't read the new time in the request body.",
+			}
+		}
+
+		date, err := time.ParseInLocation(appointmentDateFormat, body.Date, time.Local)
+		if err != nil {
+			l.Warnw("failed to parse reschedule date", "date", body.Date, "err", err)
+			return StatusError{
+				http.StatusBadRequest,
+				"Are you sure that's a date?",
+			}
+		}
+
+		target := *a
+		target.Timeslot = body.Timeslot
+		createdAppointment, err := s.rescheduleAppointment(r.Context(), l, ra, q, a, &target, date, admin)
+		if err != nil {
+			return err
+		}
+
+		return s.sendResponse(http.StatusCreated, createdAppointment, w, r)
+	}
 }
 
-func (s *Server) UpdateAppointment(ua updateAppointment) E {
+func (s *Server) RemoveAppointmentSignup(rs removeAppointmentSignup) E {
 	return func(w http.ResponseWriter, r *http.Request) error {
 		q := r.Context().Value(queueContextKey).(*Queue)
 		a := r.Context().Value(appointmentContextKey).(*AppointmentSlot)
 		email := r.Context().Value(emailContextKey).(string)
-		name := r.Context().Value(nameContextKey).(string)
-		admin := r.Context().Value(courseAdminContextKey).(bool)
 		l := s.getCtxLogger(r)
 
 		if a.StudentEmail == nil {
-			l.Warnw("attempted to update deleted appointment")
-			return StatusError{
-				http.StatusNotFound,
-				"This appointment doesn't exist. Perhaps it was already deleted?",
-			}
+			l.Warnw("attempted to remove signup for already deleted appointment")
+			// Return 200 for idempotency---if someone tries to delete an appointment
+			// twice, the second request still had the intended effect
+			w.WriteHeader(http.StatusOK)
+			return nil
 		}
 
 		if *a.StudentEmail != email {
-			l.Warnw("user attempted to update appointment with other email",
+			l.Warnw("user attempted to delete appointment with other email",
 				"expected_email", *a.StudentEmail,
 			)
 			return StatusError{
 				http.StatusForbidden,
-				"You can't update someone else's appointment!",
+				"You can't delete someone else's appointment!",
 			}
 		}
 
-		var newAppointment AppointmentSlot
-		err := json.NewDecoder(r.Body).Decode(&newAppointment)
-		if err != nil {
-			l.Warnw("failed to decode appointment", "err", err)
+		// If an appointment happened, it happened. How did people do this in Spring D:
+		if time.Now().After(a.ScheduledTime) {
+			l.Warnw("user attempted to delete appointment in the past")
 			return StatusError{
 				http.StatusBadRequest,
-				"We couldn't read your appointment in the request body.",
+				"You can't delete an appointment that already happened! Let's try not to cause a paradox here.",
 			}
 		}
-		newAppointment.Name = &name
 
-		if newAppointment.Description == nil || newAppointment.Name == nil || newAppointment.Location == nil ||
-			*newAppointment.Description == "" || *newAppointment.Name == "" || *newAppointment.Location == "" {
-			l.Warnw("got incomplete appointment", "appointment", newAppointment)
-			return StatusError{
-				http.StatusBadRequest,
-				"It looks like you left out some fields in the appointment.",
-			}
+		deleted, newSlot, err := rs.RemoveAppointmentSignup(r.Context(), a.ID)
+		if err != nil {
+			l.Errorw("failed to remove signup for appointment", "err", err)
+			return err
 		}
 
-		newAppointment.ID = a.ID
-		newAppointment.Queue = a.Queue
-		newAppointment.Duration = a.Duration
-		newAppointment.ScheduledTime = a.ScheduledTime
-		newAppointment.StudentEmail = &email
-		newAppointment.StaffEmail = a.StaffEmail
+		l.Infow("removed signup for appointment")
 
-		var zero float32
-		if newAppointment.MapX == nil {
-			newAppointment.MapX = &zero
+		if deleted {
+			s.ps.Pub(WS("APPOINTMENT_REMOVE", a.Anonymized()), QueueTopicGeneric(q.ID))
+		} else {
+			s.ps.Pub(WS("APPOINTMENT_UPDATE", newSlot), QueueTopicAdmin(q.ID))
+			s.ps.Pub(WS("APPOINTMENT_REMOVE", a.Anonymized()), QueueTopicNonPrivileged(q.ID))
 		}
-		if newAppointment.MapY == nil {
-			newAppointment.MapY = &zero
+
+		// The freed up slot goes to the first student waiting for it.
+		from, to := DateBounds(a.ScheduledTime)
+		err = s.promoteWaitlist(r.Context(), l, rs, q.ID, from, to)
+		if err != nil {
+			return err
 		}
 
-		// We're not changing any times; simple.
-		if newAppointment.Timeslot == a.Timeslot {
-			err = ua.UpdateAppointment(r.Context(), a.ID, &newAppointment)
-			if err != nil {
-				l.Errorw("failed to update appointment", "err", err)
-				return err
+		return s.sendResponse(http.StatusNoContent, nil, w, r)
+	}
+}
+
+// appointmentFits reports whether an appointment lasting duration minutes
+// can start at timeslot without overbooking any timeslot it runs into,
+// or running more than its type's capacity of that type at once.
+func appointmentFits(schedule *AppointmentSchedule, appointments []*AppointmentSlot, timeslot, duration int, appointmentType *AppointmentType) bool {
+	span := TimeslotSpan(duration, schedule.Duration)
+	if timeslot < 0 || timeslot+span > len(schedule.Schedule) {
+		return false
+	}
+
+	usage := TimeslotUsage(schedule, appointments, true)
+
+	var typeUsage []int
+	if appointmentType != nil && appointmentType.Capacity > 0 {
+		var sameType []*AppointmentSlot
+		for _, a := range appointments {
+			if a.AppointmentType != nil && *a.AppointmentType == appointmentType.ID {
+				sameType = append(sameType, a)
 			}
-			l.Infow("updated appointment")
+		}
+		typeUsage = TimeslotUsage(schedule, sameType, true)
+	}
 
-			s.ps.Pub(WS("APPOINTMENT_UPDATE", &newAppointment), QueueTopicAdmin(q.ID))
-			if !admin {
-				s.ps.Pub(WS("APPOINTMENT_UPDATE", newAppointment.NoStaffEmail()), QueueTopicEmail(q.ID, email))
+	for i := timeslot; i < timeslot+span; i++ {
+		if usage[i] >= int(schedule.Schedule[i]-'0') {
+			return false
+		}
+
+		if typeUsage != nil && typeUsage[i] >= appointmentType.Capacity {
+			return false
+		}
+	}
+
+	return true
+}
+
+// staffAvailable reports whether staff has claimed an open appointment at
+// timeslot and is free for the duration minutes after it starts.
+func staffAvailable(schedule *AppointmentSchedule, appointments []*AppointmentSlot, staff string, timeslot, duration int) bool {
+	for _, a := range appointments {
+		if a.Timeslot == timeslot && a.StudentEmail == nil && a.StaffEmail != nil && *a.StaffEmail == staff &&
+			!StaffBusy(schedule, appointments, staff, a.ID, timeslot, TimeslotSpan(duration, schedule.Duration), true) {
+			return true
+		}
+	}
+	return false
+}
+
+// StaffAvailability is the timeslots on a day at which a staff member has
+// claimed an appointment that nobody has signed up for yet. Students only
+// get the staff member's StaffID, which they can pass back as the
+// requested staff member when signing up.
+type StaffAvailability struct {
+	Staff      string `json:"staff"`
+	StaffEmail string `json:"staff_email,omitempty"`
+	Timeslots  []int  `json:"timeslots"`
+}
+
+type getStaffAvailability interface {
+	getQueueConfiguration
+	getAppointmentsInTimeFrame
+	getAppointmentScheduleForDate
+}
+
+func (s *Server) GetStaffAvailability(ga getStaffAvailability) E {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		q := r.Context().Value(queueContextKey).(*Queue)
+		date := r.Context().Value(appointmentDateContextKey).(time.Time)
+		admin := r.Context().Value(courseAdminContextKey).(bool)
+		l := s.getCtxLogger(r).With(
+			"date", date,
+		)
+
+		config, err := ga.GetQueueConfiguration(r.Context(), q.ID)
+		if err != nil {
+			l.Errorw("failed to get queue configuration", "err", err)
+			return err
+		}
+
+		if !admin && !config.StaffSelection {
+			return StatusError{
+				http.StatusForbidden,
+				"This queue doesn't let you choose who you meet with.",
 			}
+		}
 
-			return s.sendResponse(http.StatusNoContent, nil, w, r)
+		from, to := DateBounds(date)
+		appointments, err := ga.GetAppointments(r.Context(), q.ID, from, to)
+		if err != nil {
+			l.Errorw("failed to get appointments", "err", err)
+			return err
 		}
 
-		// We're changing the appointment time. Not so simple.
-		day := int(time.Now().Local().Weekday())
-		schedule, err := ua.GetAppointmentScheduleForDay(r.Context(), a.Queue, day)
+		availability := make([]*StaffAvailability, 0)
+		byStaff := make(map[string]*StaffAvailability)
+		schedule, err := ga.GetAppointmentScheduleForDate(r.Context(), q.ID, date)
 		if err != nil {
 			l.Errorw("failed to get appointment schedule", "err", err)
 			return err
 		}
 
-		start, end := WeekdayBounds(day)
-		newTime := TimeslotToTime(day, newAppointment.Timeslot, schedule.Duration)
-		newAppointment.ScheduledTime = newTime
+		for _, a := range appointments {
+			if a.StaffEmail == nil || a.StudentEmail != nil ||
+				StaffBusy(schedule, appointments, *a.StaffEmail, a.ID, a.Timeslot, 1, true) {
+				continue
+			}
 
-		// If the new time is in the past, stop.
-		if time.Now().After(newTime) {
-			l.Warnw("user attempted to change appointment to past", "new_time", newTime)
-			return StatusError{
-				http.StatusBadRequest,
-				"You can't change your appointment to the past! Let us know if you have a time machine.",
+			staff, ok := byStaff[*a.StaffEmail]
+			if !ok {
+				staff = &StaffAvailability{Staff: StaffID(q.ID, *a.StaffEmail), Timeslots: make([]int, 0)}
+				if admin {
+					staff.StaffEmail = *a.StaffEmail
+				}
+				byStaff[*a.StaffEmail] = staff
+				availability = append(availability, staff)
 			}
+			staff.Timeslots = append(staff.Timeslots, a.Timeslot)
 		}
 
-		if newAppointment.Timeslot > len(schedule.Schedule) {
-			l.Warnw("attempted to change appointment to non-existent timeslot",
-				"timeslot", newAppointment.Timeslot,
-				"num_slots", len(schedule.Schedule),
-			)
-			return StatusError{
-				http.StatusNotFound,
-				"That timeslot doesn't exist!",
+		for _, staff := range availability {
+			sort.Ints(staff.Timeslots)
+		}
+		sort.Slice(availability, func(i, j int) bool {
+			return availability[i].Staff < availability[j].Staff
+		})
+
+		return s.sendResponse(http.StatusOK, availability, w, r)
+	}
+}
+
+type getAppointmentType interface {
+	GetAppointmentType(ctx context.Context, appointmentType ksuid.KSUID) (*AppointmentType, error)
+}
+
+func (s *Server) AppointmentTypeMiddleware(gt getAppointmentType) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			q := r.Context().Value(queueContextKey).(*Queue)
+			id := chi.URLParam(r, "type_id")
+
+			typeID, err := ksuid.Parse(id)
+			if err != nil {
+				s.getCtxLogger(r).Warnw("failed to parse appointment type ID",
+					"type_id", id,
+					"err", err,
+				)
+				s.errorMessage(
+					http.StatusNotFound,
+					"I couldn't find that appointment type anywhere.",
+					w, r,
+				)
+				return
+			}
+
+			appointmentType, err := gt.GetAppointmentType(r.Context(), typeID)
+			if err != nil || appointmentType.Queue != q.ID {
+				s.getCtxLogger(r).Warnw("failed to get appointment type",
+					"type_id", id,
+					"err", err,
+				)
+				s.errorMessage(
+					http.StatusNotFound,
+					"I couldn't find that appointment type anywhere. Was it just deleted?",
+					w, r,
+				)
+				return
 			}
+
+			ctx := context.WithValue(r.Context(), appointmentTypeContextKey, appointmentType)
+			ctx = context.WithValue(ctx, loggerContextKey, s.getCtxLogger(r).With("type_id", appointmentType.ID))
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// validateAppointmentType checks the fields of an appointment type sent by
+// a client.
+func validateAppointmentType(t *AppointmentType) error {
+	if strings.TrimSpace(t.Name) == "" {
+		return StatusError{
+			http.StatusBadRequest,
+			"Appointment types need a name.",
+		}
+	}
+
+	if t.Duration <= 0 || t.Duration > 24*60 {
+		return StatusError{
+			http.StatusBadRequest,
+			"The appointment duration has to be a number of minutes no longer than a day.",
+		}
+	}
+
+	if t.Capacity < 0 {
+		return StatusError{
+			http.StatusBadRequest,
+			"The capacity of an appointment type can't be negative.",
 		}
+	}
+
+	return nil
+}
+
+type getAppointmentTypes interface {
+	GetAppointmentTypes(ctx context.Context, queue ksuid.KSUID) ([]*AppointmentType, error)
+}
+
+func (s *Server) GetAppointmentTypes(gt getAppointmentTypes) E {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		q := r.Context().Value(queueContextKey).(*Queue)
 
-		timeslotAppointments, err := ua.GetAppointmentsByTimeslot(r.Context(), a.Queue, start, end, newAppointment.Timeslot)
+		types, err := gt.GetAppointmentTypes(r.Context(), q.ID)
 		if err != nil {
-			l.Errorw("failed to get appointments for timeslot", "timeslot", newAppointment.Timeslot, "err", err)
+			s.getCtxLogger(r).Errorw("failed to get appointment types", "err", err)
 			return err
 		}
 
-		open := int(schedule.Schedule[newAppointment.Timeslot] - '0')
-		for _, a := range timeslotAppointments {
-			if a.StudentEmail != nil {
-				open--
-			}
-		}
+		return s.sendResponse(http.StatusOK, types, w, r)
+	}
+}
+
+type addAppointmentType interface {
+	AddAppointmentType(ctx context.Context, queue ksuid.KSUID, appointmentType *AppointmentType) (*AppointmentType, error)
+}
 
-		if open < 1 {
-			l.Warnw("no appointment slots available at timeslot", "timeslot", newAppointment.Timeslot)
+func (s *Server) AddAppointmentType(at addAppointmentType) E {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		q := r.Context().Value(queueContextKey).(*Queue)
+		l := s.getCtxLogger(r)
+
+		var appointmentType AppointmentType
+		err := json.NewDecoder(r.Body).Decode(&appointmentType)
+		if err != nil {
+			l.Warnw("failed to decode appointment type from body", "err", err)
 			return StatusError{
-				http.StatusConflict,
-				"There are no slots open at that time!",
+				http.StatusBadRequest,
+				"We couldn't read the appointment type in the request body.",
 			}
 		}
 
-		// Add first so student doesn't lose appointment if the add fails
-		createdAppointment, err := ua.SignupForAppointment(r.Context(), a.Queue, &newAppointment)
+		err = validateAppointmentType(&appointmentType)
 		if err != nil {
-			l.Errorw("failed to create new appointment for update", "err", err)
+			l.Warnw("got invalid appointment type", "appointment_type", appointmentType)
 			return err
 		}
-		l.Infow("created appointment for update", "new_appointment_id", createdAppointment.ID)
 
-		// If adding the new appointment succeeded, ditch the old one.
-		deleted, newSlot, err := ua.RemoveAppointmentSignup(r.Context(), a.ID)
+		newType, err := at.AddAppointmentType(r.Context(), q.ID, &appointmentType)
 		if err != nil {
-			l.Errorw("failed to remove appointment for update", "err", err)
+			l.Errorw("failed to add appointment type", "err", err)
 			return err
 		}
-		l.Infow("removed appointment for update")
 
-		if deleted {
-			s.ps.Pub(WS("APPOINTMENT_REMOVE", a.Anonymized()), QueueTopicGeneric(q.ID))
-		} else {
-			s.ps.Pub(WS("APPOINTMENT_UPDATE", newSlot), QueueTopicAdmin(q.ID))
-			s.ps.Pub(WS("APPOINTMENT_REMOVE", a.Anonymized()), QueueTopicNonPrivileged(q.ID))
-		}
+		l.Infow("added appointment type", "type_id", newType.ID)
 
-		s.ps.Pub(WS("APPOINTMENT_CREATE", createdAppointment), QueueTopicAdmin(q.ID))
-		s.ps.Pub(WS("APPOINTMENT_CREATE", createdAppointment.Anonymized()), QueueTopicNonPrivileged(q.ID))
-		if !admin {
-			s.ps.Pub(WS("APPOINTMENT_UPDATE", createdAppointment.NoStaffEmail()), QueueTopicEmail(q.ID, email))
-		}
+		s.ps.Pub(WS("REFRESH", nil), QueueTopicGeneric(q.ID))
 
-		return s.sendResponse(http.StatusCreated, createdAppointment, w, r)
+		return s.sendResponse(http.StatusCreated, newType, w, r)
 	}
 }
 
-func (s *Server) RemoveAppointmentSignup(rs removeAppointmentSignup) E {
+type updateAppointmentType interface {
+	UpdateAppointmentType(ctx context.Context, appointmentType ksuid.KSUID, values *AppointmentType) error
+}
+
+func (s *Server) UpdateAppointmentType(ut updateAppointmentType) E {
 	return func(w http.ResponseWriter, r *http.Request) error {
 		q := r.Context().Value(queueContextKey).(*Queue)
-		a := r.Context().Value(appointmentContextKey).(*AppointmentSlot)
-		email := r.Context().Value(emailContextKey).(string)
+		current := r.Context().Value(appointmentTypeContextKey).(*AppointmentType)
 		l := s.getCtxLogger(r)
 
-		if a.StudentEmail == nil {
-			l.Warnw("attempted to remove signup for already deleted appointment")
-			// Return 200 for idempotency---if someone tries to delete an appointment
-			// twice, the second request still had the intended effect
-			w.WriteHeader(http.StatusOK)
-			return nil
-		}
-
-		if *a.StudentEmail != email {
-			l.Warnw("user attempted to delete appointment with other email",
-				"expected_email", *a.StudentEmail,
-			)
+		var appointmentType AppointmentType
+		err := json.NewDecoder(r.Body).Decode(&appointmentType)
+		if err != nil {
+			l.Warnw("failed to decode appointment type from body", "err", err)
 			return StatusError{
-				http.StatusForbidden,
-				"You can't delete someone else's appointment!",
+				http.StatusBadRequest,
+				"We couldn't read the appointment type in the request body.",
 			}
 		}
 
-		// If an appointment happened, it happened. How did people do this in Spring D:
-		if time.Now().After(a.ScheduledTime) {
-			l.Warnw("user attempted to delete appointment in the past")
-			return StatusError{
-				http.StatusBadRequest,
-				"You can't delete an appointment that already happened! Let's try not to cause a paradox here.",
-			}
+		err = validateAppointmentType(&appointmentType)
+		if err != nil {
+			l.Warnw("got invalid appointment type", "appointment_type", appointmentType)
+			return err
 		}
 
-		deleted, newSlot, err := rs.RemoveAppointmentSignup(r.Context(), a.ID)
+		// Appointments already booked keep the duration they were booked
+		// with; only new ones pick up the change.
+		err = ut.UpdateAppointmentType(r.Context(), current.ID, &appointmentType)
 		if err != nil {
-			l.Errorw("failed to remove signup for appointment", "err", err)
+			l.Errorw("failed to update appointment type", "err", err)
 			return err
 		}
 
-		l.Infow("removed signup for appointment")
+		l.Infow("updated appointment type")
 
-		if deleted {
-			s.ps.Pub(WS("APPOINTMENT_REMOVE", a.Anonymized()), QueueTopicGeneric(q.ID))
-		} else {
-			s.ps.Pub(WS("APPOINTMENT_UPDATE", newSlot), QueueTopicAdmin(q.ID))
-			s.ps.Pub(WS("APPOINTMENT_REMOVE", a.Anonymized()), QueueTopicNonPrivileged(q.ID))
+		s.ps.Pub(WS("REFRESH", nil), QueueTopicGeneric(q.ID))
+
+		return s.sendResponse(http.StatusNoContent, nil, w, r)
+	}
+}
+
+type removeAppointmentType interface {
+	RemoveAppointmentType(ctx context.Context, appointmentType ksuid.KSUID) error
+}
+
+func (s *Server) RemoveAppointmentType(rt removeAppointmentType) E {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		q := r.Context().Value(queueContextKey).(*Queue)
+		appointmentType := r.Context().Value(appointmentTypeContextKey).(*AppointmentType)
+
+		err := rt.RemoveAppointmentType(r.Context(), appointmentType.ID)
+		if err != nil {
+			s.getCtxLogger(r).Errorw("failed to remove appointment type", "err", err)
+			return err
 		}
 
+		s.getCtxLogger(r).Infow("removed appointment type")
+
+		s.ps.Pub(WS("REFRESH", nil), QueueTopicGeneric(q.ID))
+
 		return s.sendResponse(http.StatusNoContent, nil, w, r)
 	}
 }
@@ -0,0 +1,126 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/segmentio/ksuid"
+)
+
+const auditContextKey = "audit"
+
+type addAuditRecord interface {
+	AddAuditRecord(ctx context.Context, record *AuditRecord) error
+}
+
+// audit records an administrative action taken during the request in the
+// course's audit log. The record is written in the request's transaction,
+// so actions that get rolled back never show up; handlers should audit
+// before responding or publishing anything, and give up if it fails.
+// before and after are stored as JSON, and either can be nil.
+func (s *Server) audit(r *http.Request, action AuditAction, target string, before, after interface{}) error {
+	ar, ok := r.Context().Value(auditContextKey).(addAuditRecord)
+	if !ok {
+		return nil
+	}
+
+	record := &AuditRecord{
+		ID:     ksuid.New(),
+		Action: action,
+		Target: target,
+		Before: s.auditValue(r, before),
+		After:  s.auditValue(r, after),
+	}
+	record.Actor, _ = r.Context().Value(emailContextKey).(string)
+
+	if q, ok := r.Context().Value(queueContextKey).(*Queue); ok {
+		record.Course = q.Course
+		record.Queue = &q.ID
+	} else {
+		record.Course = r.Context().Value(courseContextKey).(*Course).ID
+	}
+
+	err := ar.AddAuditRecord(r.Context(), record)
+	if err != nil {
+		s.getCtxLogger(r).Errorw("failed to write audit record", "action", action, "err", err)
+	}
+	return err
+}
+
+func (s *Server) auditValue(r *http.Request, v interface{}) json.RawMessage {
+	if v == nil {
+		return nil
+	}
+
+	b, err := json.Marshal(v)
+	if err != nil {
+		s.getCtxLogger(r).Errorw("failed to marshal audit value", "err", err)
+		return nil
+	}
+	return b
+}
+
+const (
+	defaultAuditPageSize = 50
+	maxAuditPageSize     = 500
+)
+
+type getAuditLog interface {
+	GetAuditLog(ctx context.Context, course ksuid.KSUID, before ksuid.KSUID, limit int) ([]*AuditRecord, error)
+}
+
+// GetAuditLog returns a page of the course's audit log, newest first.
+// The next page starts before the last record's ID, which goes in the
+// `before` query parameter.
+func (s *Server) GetAuditLog(gl getAuditLog) E {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		c := r.Context().Value(courseContextKey).(*Course)
+		l := s.getCtxLogger(r)
+
+		before := ksuid.Max
+		if b := r.URL.Query().Get("before"); b != "" {
+			id, err := ksuid.Parse(b)
+			if err != nil {
+				l.Warnw("failed to parse audit log cursor", "before", b)
+				return StatusError{
+					http.StatusBadRequest,
+					"The `before` query parameter should be the ID of an audit record.",
+				}
+			}
+			before = id
+		}
+
+		limit := defaultAuditPageSize
+		if n := r.URL.Query().Get("limit"); n != "" {
+			parsed, err := strconv.Atoi(n)
+			if err != nil || parsed < 1 || parsed > maxAuditPageSize {
+				l.Warnw("got invalid audit log page size", "limit", n)
+				return StatusError{
+					http.StatusBadRequest,
+					"The `limit` query parameter should be a number between 1 and " + strconv.Itoa(maxAuditPageSize) + ".",
+				}
+			}
+			limit = parsed
+		}
+
+		records, err := gl.GetAuditLog(r.Context(), c.ID, before, limit)
+		if err != nil {
+			l.Errorw("failed to get audit log", "err", err)
+			return err
+		}
+
+		return s.sendResponse(http.StatusOK, records, w, r)
+	}
+}
+
+// entryIDs is used to audit changes to the order of a queue without
+// storing every entry's full contents.
+func entryIDs(entries []*QueueEntry) []ksuid.KSUID {
+	ids := make([]ksuid.KSUID, len(entries))
+	for i, e := range entries {
+		ids[i] = e.ID
+	}
+	return ids
+}
@@ -7,6 +7,7 @@ import (
 	"strings"
 
 	"github.com/CarsonHoffman/office-hours-queue/server/config"
+	"github.com/coreos/go-oidc/v3/oidc"
 	"github.com/dchest/uniuri"
 	"golang.org/x/oauth2"
 )
@@ -17,6 +18,7 @@ const (
 	firstNameContextKey = "first_name"
 	sessionContextKey   = "session"
 	GroupsContextKey    = "groups"
+	guestContextKey     = "guest"
 	stateLength         = 64
 )
 
@@ -41,14 +43,33 @@ func (s *Server) ValidLoginMiddleware(next http.Handler) http.Handler {
 			return
 		}
 
-		validDomain := config.AppConfig.ValidDomain
-		if !strings.HasSuffix(email, "@"+validDomain) {
-			s.getCtxLogger(r).Warnw("found valid session with email outside valid domain",
-				"valid_domain", validDomain,
+		// CheckCourseAdmin has already made sure that tokens limited to a
+		// course are being used for that course, so all that's left is
+		// keeping them out of everything else.
+		_, inCourse := r.Context().Value(courseContextKey).(*Course)
+		_, inQueue := r.Context().Value(queueContextKey).(*Queue)
+		if _, ok := tokenCourse(r); ok && !inCourse && !inQueue {
+			s.getCtxLogger(r).Warnw("attempted to use course API token outside of course")
+			s.errorMessage(
+				http.StatusForbidden,
+				"This API token can only be used for the course it was made for.",
+				w, r,
+			)
+			return
+		}
+
+		// Accounts outside the valid domains can still get into courses
+		// that have them on staff or on the guest list.
+		admin, _ := r.Context().Value(courseAdminContextKey).(bool)
+		guest, _ := r.Context().Value(guestContextKey).(bool)
+		if !config.AppConfig.ValidEmail(email) && !admin && !guest {
+			validDomains := config.AppConfig.ValidDomains
+			s.getCtxLogger(r).Warnw("found valid session with email outside valid domains",
+				"valid_domains", validDomains,
 			)
 			s.errorMessage(
 				http.StatusUnauthorized,
-				"Oh dear, it looks like you don't have an @"+validDomain+" account.",
+				"Oh dear, it looks like you don't have an @"+strings.Join(validDomains, " or @")+" account.",
 				w, r,
 			)
 			return
@@ -58,6 +79,47 @@ func (s *Server) ValidLoginMiddleware(next http.Handler) http.Handler {
 	})
 }
 
+type getGuestCourses interface {
+	GetGuestCourses(ctx context.Context, email string) ([]string, error)
+}
+
+type checkGuest interface {
+	getAdminCourses
+	getGuestCourses
+}
+
+// CheckGuest lets accounts outside the valid domains through
+// ValidLoginMiddleware on routes outside of any course, as long as
+// they're on the staff or guest list of at least one course.
+func (s *Server) CheckGuest(gc checkGuest) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			email, ok := r.Context().Value(emailContextKey).(string)
+			if !ok || config.AppConfig.ValidEmail(email) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			adminCourses, err := gc.GetAdminCourses(r.Context(), email)
+			if err != nil {
+				s.getCtxLogger(r).Errorw("failed to get admin courses", "err", err)
+				s.internalServerError(w, r)
+				return
+			}
+
+			guestCourses, err := gc.GetGuestCourses(r.Context(), email)
+			if err != nil {
+				s.getCtxLogger(r).Errorw("failed to get guest courses", "err", err)
+				s.internalServerError(w, r)
+				return
+			}
+
+			ctx := context.WithValue(r.Context(), guestContextKey, len(adminCourses) > 0 || len(guestCourses) > 0)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
 func (s *Server) FowardAuth() E {
 	return func(w http.ResponseWriter, r *http.Request) error {
 		s.getCtxLogger(r).Infow("forward auth passed",
@@ -67,32 +129,120 @@ func (s *Server) FowardAuth() E {
 	}
 }
 
+// OIDCProvider is an identity provider set up and ready to log users in
+type OIDCProvider struct {
+	Name        string
+	DisplayName string
+	Provider    *oidc.Provider
+	OAuth2      oauth2.Config
+	UsePKCE     bool
+	Claims      config.OIDCClaims
+
+	// AllowedDomains is the lowercase email domains that can log in with
+	// the provider, or empty for any, which is only the case when it's
+	// the sole provider. Unless TrustGroups is set, the provider's groups
+	// are ignored.
+	AllowedDomains []string
+	TrustGroups    bool
+}
+
+// allowsEmail checks if the email is in one of the provider's allowed
+// domains, if it has any.
+func (p *OIDCProvider) allowsEmail(email string) bool {
+	if len(p.AllowedDomains) == 0 {
+		return true
+	}
+
+	at := strings.LastIndex(email, "@")
+	if at < 0 {
+		return false
+	}
+	domain := strings.ToLower(email[at+1:])
+	for _, allowed := range p.AllowedDomains {
+		if domain == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+// getOIDCProvider gets the provider with the given name, or the first
+// configured provider if the name is empty.
+func (s *Server) getOIDCProvider(name string) (*OIDCProvider, bool) {
+	if name == "" {
+		return s.oidcProviders[0], true
+	}
+
+	for _, p := range s.oidcProviders {
+		if p.Name == name {
+			return p, true
+		}
+	}
+	return nil, false
+}
+
+// GetOAuth2Providers lists the providers that users can log in with, so
+// that the login page can link to each of them.
+func (s *Server) GetOAuth2Providers() E {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		type provider struct {
+			Name        string `json:"name"`
+			DisplayName string `json:"display_name"`
+		}
+
+		providers := make([]provider, len(s.oidcProviders))
+		for i, p := range s.oidcProviders {
+			providers[i] = provider{p.Name, p.DisplayName}
+		}
+
+		return s.sendResponse(http.StatusOK, providers, w, r)
+	}
+}
+
 func (s *Server) OAuth2LoginLink() E {
 	return func(w http.ResponseWriter, r *http.Request) error {
+		l := s.getCtxLogger(r)
+
 		session, err := s.sessions.New(r, "session")
 		if err != nil {
-			s.getCtxLogger(r).Errorw("got invalid session on login",
+			l.Errorw("got invalid session on login",
 				"err", err,
 			)
 			http.SetCookie(w, emptySessionCookie)
-			http.Redirect(w, r, config.AppConfig.BaseURL+"api/oauth2login", http.StatusTemporaryRedirect)
+			loginURL := config.AppConfig.BaseURL + "api/oauth2login"
+			if r.URL.RawQuery != "" {
+				loginURL += "?" + r.URL.RawQuery
+			}
+			http.Redirect(w, r, loginURL, http.StatusTemporaryRedirect)
 			return nil
 		}
 
+		provider, ok := s.getOIDCProvider(r.URL.Query().Get("provider"))
+		if !ok {
+			l.Warnw("attempted to log in with non-existent provider",
+				"provider", r.URL.Query().Get("provider"),
+			)
+			return StatusError{
+				http.StatusNotFound,
+				"I don't know that login provider.",
+			}
+		}
+
 		state := uniuri.NewLen(stateLength)
 		session.Values["state"] = state
+		session.Values["provider"] = provider.Name
 
 		var url string
-		if config.AppConfig.OAuth2UsePKCE {
+		if provider.UsePKCE {
 			codeVerifier := oauth2.GenerateVerifier()
 			session.Values["code_verifier"] = codeVerifier
 
-			url = s.oauthConfig.AuthCodeURL(state,
+			url = provider.OAuth2.AuthCodeURL(state,
 				oauth2.AccessTypeOnline,
 				oauth2.S256ChallengeOption(codeVerifier),
 			)
 		} else {
-			url = s.oauthConfig.AuthCodeURL(state, oauth2.AccessTypeOnline)
+			url = provider.OAuth2.AuthCodeURL(state, oauth2.AccessTypeOnline)
 		}
 
 		s.sessions.Save(r, w, session)
@@ -101,7 +251,34 @@ func (s *Server) OAuth2LoginLink() E {
 	}
 }
 
-func (s *Server) OAuth2Callback() E {
+// claimString gets a string claim from user info, or an empty string if
+// it's missing or isn't a string.
+func claimString(info map[string]interface{}, claim string) string {
+	value, _ := info[claim].(string)
+	return value
+}
+
+// claimStrings gets a claim from user info that's a list of strings.
+// Some providers send a single string when there's only one value, so
+// that's accepted too.
+func claimStrings(info map[string]interface{}, claim string) []string {
+	switch value := info[claim].(type) {
+	case string:
+		return []string{value}
+	case []interface{}:
+		values := make([]string, 0, len(value))
+		for _, v := range value {
+			if str, ok := v.(string); ok {
+				values = append(values, str)
+			}
+		}
+		return values
+	default:
+		return nil
+	}
+}
+
+func (s *Server) OAuth2Callback(as addUserSession) E {
 	return func(w http.ResponseWriter, r *http.Request) error {
 		l := s.getCtxLogger(r)
 		code := r.FormValue("code")
@@ -129,10 +306,23 @@ func (s *Server) OAuth2Callback() E {
 			}
 		}
 
+		// Logins started before there were several providers won't have
+		// one saved, and were with the first provider.
+		providerName, _ := session.Values["provider"].(string)
+		provider, ok := s.getOIDCProvider(providerName)
+		if !ok {
+			l.Warnw("got login with provider that's no longer configured", "provider", providerName)
+			return StatusError{
+				http.StatusBadRequest,
+				"The provider you were logging in with isn't available anymore. Try logging in again.",
+			}
+		}
+		l = l.With("provider", provider.Name)
+
 		var token *oauth2.Token
 		var tokenErr error
 
-		if config.AppConfig.OAuth2UsePKCE {
+		if provider.UsePKCE {
 			codeVerifier, ok := session.Values["code_verifier"].(string)
 			if !ok {
 				l.Errorw("failed to get OAuth2 code verifier from session")
@@ -142,13 +332,13 @@ func (s *Server) OAuth2Callback() E {
 				}
 			}
 
-			token, tokenErr = s.oauthConfig.Exchange(
+			token, tokenErr = provider.OAuth2.Exchange(
 				r.Context(),
 				code,
 				oauth2.VerifierOption(codeVerifier),
 			)
 		} else {
-			token, tokenErr = s.oauthConfig.Exchange(r.Context(), code)
+			token, tokenErr = provider.OAuth2.Exchange(r.Context(), code)
 		}
 
 		if tokenErr != nil {
@@ -156,48 +346,105 @@ func (s *Server) OAuth2Callback() E {
 			return tokenErr
 		}
 
-		client := s.oauthConfig.Client(r.Context(), token)
-		rawInfo, err := client.Get(s.oidcProvider.UserInfoEndpoint())
+		client := provider.OAuth2.Client(r.Context(), token)
+		rawInfo, err := client.Get(provider.Provider.UserInfoEndpoint())
 		if err != nil {
 			l.Errorw("failed to get user info", "err", err)
 			return err
 		}
+		defer rawInfo.Body.Close()
 
-		var info struct {
-			Email     string   `json:"email"`
-			Name      string   `json:"name"`
-			GivenName string   `json:"given_name"`
-			Groups    []string `json:"groups"`
-		}
+		var info map[string]interface{}
 		if err := json.NewDecoder(rawInfo.Body).Decode(&info); err != nil {
 			l.Errorw("failed to decode user info", "err", err)
 			return err
 		}
 
-		session.Values["email"] = info.Email
-		session.Values["name"] = info.Name
-		session.Values["first_name"] = info.GivenName
-		session.Values["groups"] = info.Groups
+		email := claimString(info, provider.Claims.Email)
+		if email == "" {
+			l.Errorw("got user info without email", "claim", provider.Claims.Email)
+			return StatusError{
+				http.StatusUnauthorized,
+				"Your login provider didn't tell me your email address.",
+			}
+		}
+
+		// Providers without email_verified are trusted to only give out
+		// verified addresses. Some send it as a string.
+		if verified, ok := info[provider.Claims.EmailVerified]; ok && verified != true && verified != "true" {
+			l.Warnw("got login with unverified email", "email", email)
+			return StatusError{
+				http.StatusUnauthorized,
+				"Your login provider hasn't verified your email address yet.",
+			}
+		}
+
+		if !provider.allowsEmail(email) {
+			l.Warnw("got login with email outside provider's allowed domains",
+				"email", email,
+				"allowed_domains", provider.AllowedDomains,
+			)
+			return StatusError{
+				http.StatusUnauthorized,
+				"Oh dear, it looks like you don't have an @" + strings.Join(provider.AllowedDomains, " or @") + " account.",
+			}
+		}
+
+		name := claimString(info, provider.Claims.Name)
+		givenName := claimString(info, provider.Claims.GivenName)
+		groups := make([]string, 0)
+		if provider.TrustGroups {
+			groups = claimStrings(info, provider.Claims.Groups)
+		}
+
+		session.Values["email"] = email
+		session.Values["name"] = name
+		session.Values["first_name"] = givenName
+		session.Values["groups"] = groups
 
 		// Clean up OAuth session values
 		delete(session.Values, "code_verifier")
 		delete(session.Values, "state")
 
-		s.sessions.Save(r, w, session)
+		err = s.sessions.Save(r, w, session)
+		if err != nil {
+			l.Errorw("failed to save session", "err", err)
+			return err
+		}
+
+		err = as.AddUserSession(r.Context(), email, session.ID, r.RemoteAddr, r.UserAgent())
+		if err != nil {
+			l.Errorw("failed to add user session", "err", err)
+			return err
+		}
 
 		l.Infow("processed login",
-			"email", info.Email,
-			"name", info.Name,
-			"groups", info.Groups,
+			"email", email,
+			"name", name,
+			"groups", groups,
 		)
 		http.Redirect(w, r, config.AppConfig.BaseURL, http.StatusTemporaryRedirect)
 		return nil
 	}
 }
 
+// Logout deletes the session from the session store as well as the
+// browser, so the cookie can't be used again. Its entry in the user's
+// list of sessions is cleaned up along with expired sessions.
 func (s *Server) Logout() E {
 	return func(w http.ResponseWriter, r *http.Request) error {
-		s.getCtxLogger(r).Info("logged out")
+		l := s.getCtxLogger(r)
+
+		session, err := s.sessions.Get(r, "session")
+		if err == nil && !session.IsNew {
+			session.Options.MaxAge = -1
+			err = s.sessions.Save(r, w, session)
+		}
+		if err != nil {
+			l.Errorw("failed to delete session on logout", "err", err)
+		}
+
+		l.Info("logged out")
 
 		http.SetCookie(w, emptySessionCookie)
 		http.Redirect(w, r, config.AppConfig.BaseURL, http.StatusTemporaryRedirect)
@@ -212,6 +459,7 @@ type getAdminCourses interface {
 type getUserInfo interface {
 	siteAdmin
 	getAdminCourses
+	getGuestCourses
 }
 
 func (s *Server) GetCurrentUserInfo(gi getUserInfo) E {
@@ -234,21 +482,32 @@ func (s *Server) GetCurrentUserInfo(gi getUserInfo) E {
 			return err
 		}
 
+		guestCourses, err := gi.GetGuestCourses(r.Context(), email)
+		if err != nil {
+			s.getCtxLogger(r).Errorw("failed to get guest courses",
+				"err", err,
+			)
+			return err
+		}
+
 		// If any read or assertion fails, the string will be empty and
 		// will get caught by omitempty in the JSON encoding. It looks bad,
 		// but is actually not horrible!
 		name, _ := r.Context().Value(nameContextKey).(string)
 		firstName, _ := r.Context().Value(firstNameContextKey).(string)
 		groups, _ := r.Context().Value(GroupsContextKey).([]string)
+		impersonator, _ := r.Context().Value(impersonatorContextKey).(string)
 
 		resp := struct {
 			Email        string   `json:"email"`
 			SiteAdmin    bool     `json:"site_admin"`
 			AdminCourses []string `json:"admin_courses"`
+			GuestCourses []string `json:"guest_courses"`
 			Name         string   `json:"name"`
 			FirstName    string   `json:"first_name"`
 			Groups       []string `json:"groups"`
-		}{email, admin, courses, name, firstName, groups}
+			Impersonator string   `json:"impersonator,omitempty"`
+		}{email, admin, courses, guestCourses, name, firstName, groups, impersonator}
 
 		return s.sendResponse(http.StatusOK, resp, w, r)
 	}
@@ -2,11 +2,15 @@ package api
 
 import (
 	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"net/http"
 	"time"
 
+	"github.com/CarsonHoffman/office-hours-queue/server/config"
 	"github.com/segmentio/ksuid"
 )
 
@@ -112,6 +116,31 @@ func CurrentHalfHour() int {
 	return (time.Now().Hour()*60 + time.Now().Minute()) / 30
 }
 
+// ScheduleClosingTime finds when a queue following the given day's
+// schedule next closes, assuming it's open at now. A queue open through
+// the last half hour closes at midnight, since the next day's schedule is
+// its own. ok is false if the queue is closed at now.
+func ScheduleClosingTime(schedule string, now time.Time) (closesAt time.Time, ok bool) {
+	halfHour := (now.Hour()*60 + now.Minute()) / 30
+	if halfHour >= len(schedule) || schedule[halfHour] == 'c' {
+		return time.Time{}, false
+	}
+
+	for i := halfHour + 1; i < len(schedule); i++ {
+		if schedule[i] == 'c' {
+			return time.Date(now.Year(), now.Month(), now.Day(), i/2, (i%2)*30, 0, 0, now.Location()), true
+		}
+	}
+
+	return time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, now.Location()), true
+}
+
+// InLastCall reports whether a queue closing at closesAt has entered its
+// last call window of lastCall minutes at now.
+func InLastCall(closesAt time.Time, lastCall int, now time.Time) bool {
+	return lastCall > 0 && closesAt.Sub(now) <= time.Duration(lastCall)*time.Minute
+}
+
 // WeekdayBounds gets the bounds of the specified
 // day of the week in the local time zone. start is the first instant
 // of the day, and end is the last nanosecond of the day.
@@ -126,11 +155,15 @@ func WeekdayBounds(day int) (start time.Time, end time.Time) {
 		difference += 7
 	}
 
-	// Get the absolute day value in the month
-	day = time.Now().Local().Day() + difference
+	return DateBounds(time.Now().Local().AddDate(0, 0, difference))
+}
 
-	start = time.Date(time.Now().Local().Year(), time.Now().Month(), day, 0, 0, 0, 0, time.Local)
-	end = time.Date(time.Now().Local().Year(), time.Now().Month(), day+1, 0, 0, 0, -1, time.Local)
+// DateBounds gets the bounds of the day containing date in the local
+// time zone, in the same form as WeekdayBounds.
+func DateBounds(date time.Time) (start time.Time, end time.Time) {
+	date = date.Local()
+	start = time.Date(date.Year(), date.Month(), date.Day(), 0, 0, 0, 0, time.Local)
+	end = time.Date(date.Year(), date.Month(), date.Day()+1, 0, 0, 0, -1, time.Local)
 	return
 }
 
@@ -139,7 +172,74 @@ func WeekdayBounds(day int) (start time.Time, end time.Time) {
 // rather than just the index of the timeslot in the day in terms of minutes)
 func TimeslotToTime(day, timeslot, duration int) time.Time {
 	start, _ := WeekdayBounds(day)
-	return time.Date(start.Year(), start.Month(), start.Day(), (timeslot*duration)/60, (timeslot*duration)%60, 0, 0, start.Local().Location())
+	return DateTimeslotToTime(start, timeslot, duration)
+}
+
+// DateTimeslotToTime is TimeslotToTime for a specific date rather than
+// the next occurrence of a weekday.
+func DateTimeslotToTime(date time.Time, timeslot, duration int) time.Time {
+	start, _ := DateBounds(date)
+	return time.Date(start.Year(), start.Month(), start.Day(), (timeslot*duration)/60, (timeslot*duration)%60, 0, 0, time.Local)
+}
+
+// TimeslotSpan is the number of consecutive timeslots of slotDuration
+// minutes that an appointment lasting duration minutes runs into.
+func TimeslotSpan(duration, slotDuration int) int {
+	if duration <= slotDuration {
+		return 1
+	}
+	return (duration + slotDuration - 1) / slotDuration
+}
+
+// TimeslotUsage counts the appointments running during each timeslot of
+// schedule, counting longer appointments against every timeslot they run
+// into. If booked is set, only appointments with a student are counted.
+func TimeslotUsage(schedule *AppointmentSchedule, appointments []*AppointmentSlot, booked bool) []int {
+	usage := make([]int, len(schedule.Schedule))
+	for _, a := range appointments {
+		if booked && a.StudentEmail == nil {
+			continue
+		}
+
+		span := TimeslotSpan(a.Duration, schedule.Duration)
+		for i := a.Timeslot; i < a.Timeslot+span && i < len(usage); i++ {
+			usage[i]++
+		}
+	}
+	return usage
+}
+
+// StaffBusy reports whether staff has an appointment other than except
+// running during any of the span timeslots starting at timeslot. If
+// booked is set, only appointments with a student are considered.
+func StaffBusy(schedule *AppointmentSchedule, appointments []*AppointmentSlot, staff string, except ksuid.KSUID, timeslot, span int, booked bool) bool {
+	for _, a := range appointments {
+		if a.ID == except || a.StaffEmail == nil || *a.StaffEmail != staff || (booked && a.StudentEmail == nil) {
+			continue
+		}
+
+		if a.Timeslot < timeslot+span && timeslot < a.Timeslot+TimeslotSpan(a.Duration, schedule.Duration) {
+			return true
+		}
+	}
+	return false
+}
+
+// StaffID is an opaque identifier for a staff member on a queue, so that
+// students can choose who they meet with without learning staff emails.
+// It's stable as long as the sessions key doesn't change.
+func StaffID(queue ksuid.KSUID, email string) string {
+	mac := hmac.New(sha256.New, config.AppConfig.SessionsKey)
+	mac.Write(queue.Bytes())
+	mac.Write([]byte(email))
+	return hex.EncodeToString(mac.Sum(nil)[:12])
+}
+
+// WithinBookingHorizon reports whether appointments can be made on date
+// given a booking horizon of horizon days, today being the first of them.
+func WithinBookingHorizon(date time.Time, horizon int) bool {
+	today, _ := DateBounds(time.Now())
+	return !date.Before(today) && date.Before(today.AddDate(0, 0, horizon))
 }
 
 // BigTime returns (roughly) the maximum time representable by PostgreSQL.
@@ -7,9 +7,11 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"strings"
+	"time"
 
+	"github.com/CarsonHoffman/office-hours-queue/server/config"
 	"github.com/go-chi/chi/v5"
-	"github.com/lib/pq"
 	"github.com/segmentio/ksuid"
 )
 
@@ -66,12 +68,20 @@ func (s *Server) CourseIDMiddleware(gc getCourse) func(http.Handler) http.Handle
 	}
 }
 
-const courseAdminContextKey = "course_admin"
+const (
+	courseAdminContextKey = "course_admin"
+	courseRoleContextKey  = "course_role"
+)
 
 type courseAdmin interface {
-	CourseAdmin(ctx context.Context, course ksuid.KSUID, email string) (bool, error)
+	CourseRole(ctx context.Context, course ksuid.KSUID, email string) (CourseRole, error)
+	CourseGuest(ctx context.Context, course ksuid.KSUID, email string) (bool, error)
 }
 
+// CheckCourseAdmin looks up the user's role in the course. Anyone with a
+// role counts as a course admin; what they can do beyond that is up to
+// EnsureCoursePermission. It also checks whether users outside the valid
+// domains are on the course's guest list.
 func (s *Server) CheckCourseAdmin(ca courseAdmin) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -84,14 +94,27 @@ func (s *Server) CheckCourseAdmin(ca courseAdmin) func(http.Handler) http.Handle
 				courseID = q.Course
 			}
 
+			if scope, ok := tokenCourse(r); ok && scope != courseID {
+				s.getCtxLogger(r).Warnw("attempted to use API token outside of its course",
+					"token_course", scope,
+				)
+				s.errorMessage(
+					http.StatusForbidden,
+					"This API token can only be used for the course it was made for.",
+					w, r,
+				)
+				return
+			}
+
 			email, ok := r.Context().Value(emailContextKey).(string)
 			if !ok {
 				ctx := context.WithValue(r.Context(), courseAdminContextKey, false)
+				ctx = context.WithValue(ctx, courseRoleContextKey, RoleNone)
 				next.ServeHTTP(w, r.WithContext(ctx))
 				return
 			}
 
-			admin, err := ca.CourseAdmin(r.Context(), courseID, email)
+			role, err := ca.CourseRole(r.Context(), courseID, email)
 			if err != nil {
 				s.getCtxLogger(r).Errorw("failed to check course admin status",
 					"err", err,
@@ -100,7 +123,23 @@ func (s *Server) CheckCourseAdmin(ca courseAdmin) func(http.Handler) http.Handle
 				return
 			}
 
-			ctx := context.WithValue(r.Context(), courseAdminContextKey, admin)
+			// Only accounts outside the valid domains need to be on the
+			// guest list.
+			guest := false
+			if role == RoleNone && !config.AppConfig.ValidEmail(email) {
+				guest, err = ca.CourseGuest(r.Context(), courseID, email)
+				if err != nil {
+					s.getCtxLogger(r).Errorw("failed to check course guest status",
+						"err", err,
+					)
+					s.internalServerError(w, r)
+					return
+				}
+			}
+
+			ctx := context.WithValue(r.Context(), courseAdminContextKey, role != RoleNone)
+			ctx = context.WithValue(ctx, courseRoleContextKey, role)
+			ctx = context.WithValue(ctx, guestContextKey, guest)
 			next.ServeHTTP(w, r.WithContext(ctx))
 		})
 	}
@@ -124,13 +163,102 @@ func (s *Server) EnsureCourseAdmin(next http.Handler) http.Handler {
 	})
 }
 
+// EnsureCoursePermission only lets through course staff whose role grants
+// p. It expects CheckCourseAdmin to have run already.
+func (s *Server) EnsureCoursePermission(p Permission) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			role := r.Context().Value(courseRoleContextKey).(CourseRole)
+			if !role.Can(p) {
+				s.getCtxLogger(r).Warnw("course staff attempting to access resource without permission",
+					"role", role,
+					"permission", p,
+				)
+				s.errorMessage(
+					http.StatusForbidden,
+					"You shouldn't be here. :)",
+					w, r,
+				)
+				return
+			}
+
+			s.getCtxLogger(r).Infow("entering course admin context", "role", role)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// ArchivedMiddleware makes archived courses and queues read-only, and
+// hides them from everyone but course staff. It expects CheckCourseAdmin
+// to have run already.
+func (s *Server) ArchivedMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var archivedAt *time.Time
+		if course, ok := r.Context().Value(courseContextKey).(*Course); ok {
+			archivedAt = course.ArchivedAt
+		} else {
+			archivedAt = r.Context().Value(queueContextKey).(*Queue).ArchivedAt
+		}
+
+		if archivedAt == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		admin := r.Context().Value(courseAdminContextKey).(bool)
+		if !admin {
+			s.getCtxLogger(r).Warnw("non-admin attempting to access archived resource")
+			s.errorMessage(
+				http.StatusNotFound,
+				"I've looked everywhere, but I can't find that.",
+				w, r,
+			)
+			return
+		}
+
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			s.getCtxLogger(r).Warnw("attempted to change archived resource", "archived_at", archivedAt)
+			s.errorMessage(
+				http.StatusForbidden,
+				"This has been archived, so it can't be changed. Restore it first!",
+				w, r,
+			)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
 type getCourses interface {
-	GetCourses(context.Context) ([]*Course, error)
+	getAdminCourses
+	GetCourses(ctx context.Context, term TermFilter) ([]*Course, error)
+	GetArchivedCourses(ctx context.Context, term TermFilter) ([]*Course, error)
+	GetRosterSections(ctx context.Context, email string) (map[ksuid.KSUID]string, error)
 }
 
+// GetCourses lists courses and their active queues. With `archived=true`,
+// it lists the archived courses the user is on the staff of instead, with
+// all of their queues, so staff can get back to their history.
+
 func (s *Server) GetCourses(gc getCourses) E {
 	return func(w http.ResponseWriter, r *http.Request) error {
-		courses, err := gc.GetCourses(r.Context())
+		term := TermFilter(r.URL.Query().Get("term"))
+		switch term {
+		case TermAll, TermCurrent, TermPast, TermUpcoming:
+		default:
+			s.getCtxLogger(r).Warnw("unknown term filter", "term", term)
+			return StatusError{
+				http.StatusBadRequest,
+				"The `term` query parameter should be one of current, past, or upcoming.",
+			}
+		}
+
+		if r.URL.Query().Get("archived") == "true" {
+			return s.getArchivedCourses(gc, term, w, r)
+		}
+
+		courses, err := gc.GetCourses(r.Context(), term)
 		if err != nil {
 			s.getCtxLogger(r).Errorw("failed to fetch courses from DB",
 				"err", err,
@@ -138,10 +266,90 @@ func (s *Server) GetCourses(gc getCourses) E {
 			return err
 		}
 
+		if email, ok := r.Context().Value(emailContextKey).(string); ok {
+			err = s.markApplicableQueues(r, gc, email, courses)
+			if err != nil {
+				return err
+			}
+		}
+
 		return s.sendResponse(http.StatusOK, courses, w, r)
 	}
 }
 
+func (s *Server) getArchivedCourses(gc getCourses, term TermFilter, w http.ResponseWriter, r *http.Request) error {
+	l := s.getCtxLogger(r)
+	email, ok := r.Context().Value(emailContextKey).(string)
+	if !ok {
+		l.Warnw("attempted to list archived courses without logging in")
+		return StatusError{
+			http.StatusUnauthorized,
+			"You need to log in to see archived courses.",
+		}
+	}
+
+	adminCourses, err := gc.GetAdminCourses(r.Context(), email)
+	if err != nil {
+		l.Errorw("failed to get admin courses", "err", err)
+		return err
+	}
+
+	admin := make(map[string]bool, len(adminCourses))
+	for _, c := range adminCourses {
+		admin[c] = true
+	}
+
+	archived, err := gc.GetArchivedCourses(r.Context(), term)
+	if err != nil {
+		l.Errorw("failed to get archived courses", "err", err)
+		return err
+	}
+
+	courses := make([]*Course, 0)
+	for _, c := range archived {
+		if admin[c.ID.String()] {
+			courses = append(courses, c)
+		}
+	}
+
+	return s.sendResponse(http.StatusOK, courses, w, r)
+}
+
+// markApplicableQueues tells a logged-in user which queues they can join:
+// those without section restrictions, those for their roster section, and
+// all of the queues in courses they're on the staff of.
+func (s *Server) markApplicableQueues(r *http.Request, gc getCourses, email string, courses []*Course) error {
+	adminCourses, err := gc.GetAdminCourses(r.Context(), email)
+	if err != nil {
+		s.getCtxLogger(r).Errorw("failed to get admin courses", "err", err)
+		return err
+	}
+
+	admin := make(map[string]bool, len(adminCourses))
+	for _, c := range adminCourses {
+		admin[c] = true
+	}
+
+	sections, err := gc.GetRosterSections(r.Context(), email)
+	if err != nil {
+		s.getCtxLogger(r).Errorw("failed to get roster sections", "err", err)
+		return err
+	}
+
+	for _, c := range courses {
+		section, onRoster := sections[c.ID]
+		for _, q := range c.Queues {
+			applies := len(q.Sections) == 0 || admin[c.ID.String()]
+			for _, queueSection := range q.Sections {
+				applies = applies || (onRoster && queueSection == section)
+			}
+			q.Applies = &applies
+		}
+	}
+
+	return nil
+}
+
 func (s *Server) GetCourse(gc getCourse) E {
 	return func(w http.ResponseWriter, r *http.Request) error {
 		return s.sendResponse(http.StatusOK, r.Context().Value(courseContextKey), w, r)
@@ -151,13 +359,29 @@ func (s *Server) GetCourse(gc getCourse) E {
 type getQueues interface {
 	getCourse
 	GetQueues(ctx context.Context, course ksuid.KSUID) ([]*Queue, error)
+	GetQueuesWithArchived(ctx context.Context, course ksuid.KSUID) ([]*Queue, error)
 }
 
+// GetQueues lists the course's active queues. Course staff can ask for
+// every queue, including inactive and archived ones, with
+// `archived=true`.
 func (s *Server) GetQueues(gq getQueues) E {
 	return func(w http.ResponseWriter, r *http.Request) error {
 		c := r.Context().Value(courseContextKey).(*Course)
 
-		queues, err := gq.GetQueues(r.Context(), c.ID)
+		get := gq.GetQueues
+		if r.URL.Query().Get("archived") == "true" {
+			if admin, _ := r.Context().Value(courseAdminContextKey).(bool); !admin {
+				s.getCtxLogger(r).Warnw("non-admin attempted to list archived queues")
+				return StatusError{
+					http.StatusForbidden,
+					"Only course staff can see archived queues.",
+				}
+			}
+			get = gq.GetQueuesWithArchived
+		}
+
+		queues, err := get(r.Context(), c.ID)
 		if err != nil {
 			s.getCtxLogger(r).Errorw("failed to get queues from course", "err", err)
 			return err
@@ -167,8 +391,20 @@ func (s *Server) GetQueues(gq getQueues) E {
 	}
 }
 
+// validateCourseTerm makes sure that a course's term, if it has both
+// ends, doesn't end before it starts.
+func validateCourseTerm(c *Course) error {
+	if c.TermStart != nil && c.TermEnd != nil && !c.TermEnd.After(*c.TermStart) {
+		return StatusError{
+			http.StatusBadRequest,
+			"The course's term has to end after it starts!",
+		}
+	}
+	return nil
+}
+
 type addCourse interface {
-	AddCourse(ctx context.Context, shortName, fullName string) (*Course, error)
+	AddCourse(ctx context.Context, course *Course) (*Course, error)
 }
 
 func (s *Server) AddCourse(ac addCourse) E {
@@ -195,7 +431,14 @@ func (s *Server) AddCourse(ac addCourse) E {
 			}
 		}
 
-		newCourse, err := ac.AddCourse(r.Context(), course.ShortName, course.FullName)
+		if err := validateCourseTerm(&course); err != nil {
+			s.getCtxLogger(r).Warnw("received course with invalid term",
+				"course", course,
+			)
+			return err
+		}
+
+		newCourse, err := ac.AddCourse(r.Context(), &course)
 		if err != nil {
 			s.getCtxLogger(r).Errorw("failed to create course",
 				"err", err,
@@ -209,7 +452,7 @@ func (s *Server) AddCourse(ac addCourse) E {
 }
 
 type updateCourse interface {
-	UpdateCourse(ctx context.Context, course ksuid.KSUID, shortName, fullName string) error
+	UpdateCourse(ctx context.Context, course ksuid.KSUID, values *Course) error
 }
 
 func (s *Server) UpdateCourse(uc updateCourse) E {
@@ -238,7 +481,14 @@ func (s *Server) UpdateCourse(uc updateCourse) E {
 			}
 		}
 
-		err = uc.UpdateCourse(r.Context(), course.ID, bodyCourse.ShortName, bodyCourse.FullName)
+		if err := validateCourseTerm(&bodyCourse); err != nil {
+			s.getCtxLogger(r).Warnw("received course with invalid term",
+				"course", bodyCourse,
+			)
+			return err
+		}
+
+		err = uc.UpdateCourse(r.Context(), course.ID, &bodyCourse)
 		if err != nil {
 			s.getCtxLogger(r).Errorw("failed to update course",
 				"err", err,
@@ -251,10 +501,100 @@ func (s *Server) UpdateCourse(uc updateCourse) E {
 	}
 }
 
+type archiveCourse interface {
+	getQueues
+	ArchiveCourse(ctx context.Context, course ksuid.KSUID) error
+}
+
+// ArchiveCourse hides the course and takes down its queues, but unlike
+// deleting it keeps all of their history around for course staff.
+func (s *Server) ArchiveCourse(ac archiveCourse) E {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		course := r.Context().Value(courseContextKey).(*Course)
+		l := s.getCtxLogger(r)
+
+		if course.ArchivedAt != nil {
+			l.Warnw("attempted to archive archived course")
+			return StatusError{
+				http.StatusConflict,
+				"This course has already been archived.",
+			}
+		}
+
+		queues, err := ac.GetQueues(r.Context(), course.ID)
+		if err != nil {
+			l.Errorw("failed to get queues from course", "err", err)
+			return err
+		}
+
+		err = ac.ArchiveCourse(r.Context(), course.ID)
+		if err != nil {
+			l.Errorw("failed to archive course", "err", err)
+			return err
+		}
+
+		if err := s.audit(r, AuditArchiveCourse, course.ID.String(), nil, nil); err != nil {
+			return err
+		}
+
+		for _, q := range queues {
+			s.ps.Pub(WS("REFRESH", nil), QueueTopicGeneric(q.ID))
+		}
+
+		l.Infow("archived course")
+		return s.sendResponse(http.StatusNoContent, nil, w, r)
+	}
+}
+
+type restoreCourse interface {
+	getQueues
+	RestoreCourse(ctx context.Context, course ksuid.KSUID, inTerm bool) error
+}
+
+func (s *Server) RestoreCourse(rc restoreCourse) E {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		course := r.Context().Value(courseContextKey).(*Course)
+		l := s.getCtxLogger(r)
+
+		if course.ArchivedAt == nil {
+			l.Warnw("attempted to restore course that isn't archived")
+			return StatusError{
+				http.StatusConflict,
+				"This course isn't archived.",
+			}
+		}
+
+		err := rc.RestoreCourse(r.Context(), course.ID, course.InTerm(time.Now()))
+		if err != nil {
+			l.Errorw("failed to restore course", "err", err)
+			return err
+		}
+
+		queues, err := rc.GetQueues(r.Context(), course.ID)
+		if err != nil {
+			l.Errorw("failed to get queues from course", "err", err)
+			return err
+		}
+
+		if err := s.audit(r, AuditRestoreCourse, course.ID.String(), nil, nil); err != nil {
+			return err
+		}
+
+		for _, q := range queues {
+			s.ps.Pub(WS("REFRESH", nil), QueueTopicGeneric(q.ID))
+		}
+
+		l.Infow("restored course")
+		return s.sendResponse(http.StatusNoContent, nil, w, r)
+	}
+}
+
 type deleteCourse interface {
 	DeleteCourse(ctx context.Context, course ksuid.KSUID) error
 }
 
+// DeleteCourse permanently removes the course along with all of its
+// queues and their history.
 func (s *Server) DeleteCourse(dc deleteCourse) E {
 	return func(w http.ResponseWriter, r *http.Request) error {
 		course := r.Context().Value(courseContextKey).(*Course)
@@ -315,6 +655,10 @@ func (s *Server) AddQueue(aq addQueue) E {
 			}
 		}
 
+		// Queues start out active unless the course's term says otherwise;
+		// the term job takes care of them from there.
+		queue.Active = c.InTerm(time.Now())
+
 		newQueue, err := aq.AddQueue(r.Context(), c.ID, &queue)
 		if err != nil {
 			l.Errorw("failed to create queue", "err", err)
@@ -348,8 +692,211 @@ func (s *Server) AddQueue(aq addQueue) E {
 	}
 }
 
+type cloneCourse interface {
+	addCourse
+	addQueue
+	addCourseAdmins
+	updateCourseGroups
+	getQueueConfiguration
+	updateQueueConfiguration
+	getQueueSchedule
+	getAppointmentSchedule
+	getAppointmentTypes
+	addAppointmentType
+	GetAllQueues(ctx context.Context, course ksuid.KSUID) ([]*Queue, error)
+}
+
+// CloneCourse creates a new course from an existing one. Everything the
+// options cover is carried over unless turned off in the request body;
+// queues that don't get their old schedules start out with the defaults,
+// just like a new queue would. Archived queues aren't cloned.
+func (s *Server) CloneCourse(cc cloneCourse) E {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		c := r.Context().Value(courseContextKey).(*Course)
+		l := s.getCtxLogger(r)
+
+		options := CourseCloneOptions{
+			Admins:               true,
+			Queues:               true,
+			Configuration:        true,
+			Schedules:            true,
+			AppointmentSchedules: true,
+			AppointmentTypes:     true,
+		}
+		err := json.NewDecoder(r.Body).Decode(&options)
+		if err != nil {
+			l.Warnw("failed to decode clone options from body", "err", err)
+			return StatusError{
+				http.StatusBadRequest,
+				"We couldn't read the new course from the request body.",
+			}
+		}
+
+		course := options.Course
+		if course.ShortName == "" || course.FullName == "" {
+			l.Warnw("received incomplete course", "course", course)
+			return StatusError{
+				http.StatusBadRequest,
+				"It looks like you missed some fields in the course!",
+			}
+		}
+
+		if err := validateCourseTerm(&course); err != nil {
+			l.Warnw("received course with invalid term", "course", course)
+			return err
+		}
+
+		newCourse, err := cc.AddCourse(r.Context(), &course)
+		if err != nil {
+			l.Errorw("failed to create course", "err", err)
+			return err
+		}
+		l = l.With("new_course_id", newCourse.ID)
+
+		if options.Admins {
+			admins, err := cc.GetCourseAdmins(r.Context(), c.ID)
+			if err != nil {
+				l.Errorw("failed to get course admins", "err", err)
+				return err
+			}
+
+			err = cc.AddCourseAdmins(r.Context(), newCourse.ID, admins, false)
+			if err != nil {
+				l.Errorw("failed to copy course admins", "err", err)
+				return err
+			}
+
+			groups, err := cc.GetCourseGroups(r.Context(), c.ID)
+			if err != nil {
+				l.Errorw("failed to get course groups", "err", err)
+				return err
+			}
+
+			err = cc.UpdateCourseGroups(r.Context(), newCourse.ID, groups)
+			if err != nil {
+				l.Errorw("failed to copy course groups", "err", err)
+				return err
+			}
+		}
+
+		if !options.Queues {
+			l.Infow("cloned course", "options", options)
+			return s.sendResponse(http.StatusCreated, newCourse, w, r)
+		}
+
+		queues, err := cc.GetAllQueues(r.Context(), c.ID)
+		if err != nil {
+			l.Errorw("failed to get queues from course", "err", err)
+			return err
+		}
+
+		// GetAllQueues leaves out archived queues, which were retired on
+		// purpose, so they stay behind.
+		for _, q := range queues {
+			err = s.cloneQueue(r.Context(), cc, newCourse, q, &options)
+			if err != nil {
+				l.Errorw("failed to clone queue", "queue_id", q.ID, "err", err)
+				return err
+			}
+		}
+
+		l.Infow("cloned course", "options", options, "queues", len(queues))
+		return s.sendResponse(http.StatusCreated, newCourse, w, r)
+	}
+}
+
+// cloneQueue copies q into course, along with whichever of its settings
+// the options ask for.
+func (s *Server) cloneQueue(ctx context.Context, cc cloneCourse, course *Course, q *Queue, options *CourseCloneOptions) error {
+	newQueue, err := cc.AddQueue(ctx, course.ID, &Queue{
+		Type:     q.Type,
+		Name:     q.Name,
+		Location: q.Location,
+		Map:      q.Map,
+		Active:   course.InTerm(time.Now()),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create queue: %w", err)
+	}
+
+	if options.Configuration {
+		config, err := cc.GetQueueConfiguration(ctx, q.ID)
+		if err != nil {
+			return fmt.Errorf("failed to get queue configuration: %w", err)
+		}
+
+		err = cc.UpdateQueueConfiguration(ctx, newQueue.ID, config)
+		if err != nil {
+			return fmt.Errorf("failed to copy queue configuration: %w", err)
+		}
+	}
+
+	schedules := make([]string, 7)
+	for day := range schedules {
+		schedules[day] = defaultQueueSchedule
+	}
+	if options.Schedules {
+		old, err := cc.GetQueueSchedule(ctx, q.ID)
+		if err != nil {
+			return fmt.Errorf("failed to get queue schedule: %w", err)
+		}
+		copy(schedules, old)
+	}
+
+	for day, schedule := range schedules {
+		err = cc.AddQueueSchedule(ctx, newQueue.ID, day, schedule)
+		if err != nil {
+			return fmt.Errorf("failed to add queue schedule for day %d: %w", day, err)
+		}
+	}
+
+	if q.Type != Appointments {
+		return nil
+	}
+
+	appointmentSchedules := make([]*AppointmentSchedule, 7)
+	for day := range appointmentSchedules {
+		appointmentSchedules[day] = defaultAppointmentSchedule
+	}
+	if options.AppointmentSchedules {
+		old, err := cc.GetAppointmentSchedule(ctx, q.ID)
+		if err != nil {
+			return fmt.Errorf("failed to get appointment schedule: %w", err)
+		}
+
+		for _, schedule := range old {
+			if int(schedule.Day) < len(appointmentSchedules) {
+				appointmentSchedules[schedule.Day] = schedule
+			}
+		}
+	}
+
+	for day, schedule := range appointmentSchedules {
+		err = cc.AddAppointmentSchedule(ctx, newQueue.ID, day, schedule)
+		if err != nil {
+			return fmt.Errorf("failed to add appointment schedule for day %d: %w", day, err)
+		}
+	}
+
+	if options.AppointmentTypes {
+		types, err := cc.GetAppointmentTypes(ctx, q.ID)
+		if err != nil {
+			return fmt.Errorf("failed to get appointment types: %w", err)
+		}
+
+		for _, t := range types {
+			_, err = cc.AddAppointmentType(ctx, newQueue.ID, t)
+			if err != nil {
+				return fmt.Errorf("failed to copy appointment type %s: %w", t.ID, err)
+			}
+		}
+	}
+
+	return nil
+}
+
 type getCourseAdmins interface {
-	GetCourseAdmins(ctx context.Context, course ksuid.KSUID) ([]string, error)
+	GetCourseAdmins(ctx context.Context, course ksuid.KSUID) ([]*CourseStaff, error)
 }
 
 func (s *Server) GetCourseAdmins(ga getCourseAdmins) E {
@@ -366,56 +913,153 @@ func (s *Server) GetCourseAdmins(ga getCourseAdmins) E {
 	}
 }
 
+// decodeCourseAdmins reads course staff from the request body, which can
+// mix bare emails (whose roles keepCourseAdminRoles fills in) and objects
+// with an email and role.
+func decodeCourseAdmins(r *http.Request) ([]*CourseStaff, error) {
+	var admins []*CourseStaff
+	err := json.NewDecoder(r.Body).Decode(&admins)
+	if err != nil {
+		return nil, StatusError{
+			http.StatusBadRequest,
+			"I couldn't decode the body. Are you sure it's a JSON array of emails (strings) or objects with an email and role? This error might help: " + err.Error(),
+		}
+	}
+
+	for _, a := range admins {
+		if a == nil || a.Email == "" {
+			return nil, StatusError{
+				http.StatusBadRequest,
+				"It looks like one of the admins is missing an email.",
+			}
+		}
+
+		if a.Role != RoleNone && !a.Role.Valid() {
+			return nil, StatusError{
+				http.StatusBadRequest,
+				fmt.Sprintf(`I haven't seen the role "%s" before.`, a.Role),
+			}
+		}
+	}
+
+	return admins, nil
+}
+
+// keepCourseAdminRoles fills in the roles of admins given without one:
+// staff keep the role they already have, and anyone new is a TA. That way
+// sending back a plain list of emails doesn't demote anyone.
+func keepCourseAdminRoles(admins []*CourseStaff, existing []*CourseStaff) {
+	current := make(map[string]CourseRole, len(existing))
+	for _, a := range existing {
+		current[a.Email] = a.Role
+	}
+
+	for _, a := range admins {
+		if a.Role != RoleNone {
+			continue
+		}
+
+		if role, ok := current[a.Email]; ok {
+			a.Role = role
+		} else {
+			a.Role = RoleTA
+		}
+	}
+}
+
+// checkManageCourseAdmins makes sure that the current user can manage the
+// roles of everyone being changed, both the role they have now (if any)
+// and the role they're getting.
+func checkManageCourseAdmins(r *http.Request, existing []*CourseStaff, changed []*CourseStaff) error {
+	role := r.Context().Value(courseRoleContextKey).(CourseRole)
+
+	current := make(map[string]CourseRole, len(existing))
+	for _, a := range existing {
+		current[a.Email] = a.Role
+	}
+
+	for _, a := range changed {
+		if old, ok := current[a.Email]; (ok && !role.CanManage(old)) || (a.Role != RoleNone && !role.CanManage(a.Role)) {
+			return StatusError{
+				http.StatusForbidden,
+				fmt.Sprintf("You can't change the role of %s.", a.Email),
+			}
+		}
+	}
+
+	return nil
+}
+
 type addCourseAdmins interface {
-	AddCourseAdmins(ctx context.Context, course ksuid.KSUID, admins []string, overwrite bool) error
+	getCourseAdmins
+	AddCourseAdmins(ctx context.Context, course ksuid.KSUID, admins []*CourseStaff, overwrite bool) error
 }
 
+// AddCourseAdmins adds staff to the course, or changes the roles of those
+// already on it.
 func (s *Server) AddCourseAdmins(aa addCourseAdmins) E {
 	return func(w http.ResponseWriter, r *http.Request) error {
 		c := r.Context().Value(courseContextKey).(*Course)
 		l := s.getCtxLogger(r)
 
-		var admins []string
-		err := json.NewDecoder(r.Body).Decode(&admins)
+		admins, err := decodeCourseAdmins(r)
 		if err != nil {
 			l.Warnw("failed to decode admins from body", "err", err)
-			return StatusError{
-				http.StatusBadRequest,
-				"I couldn't decode the body. Are you sure it's a JSON array of emails (strings)? This error might help: " + err.Error(),
-			}
+			return err
+		}
+
+		existing, err := aa.GetCourseAdmins(r.Context(), c.ID)
+		if err != nil {
+			l.Errorw("failed to get course admins", "err", err)
+			return err
+		}
+
+		keepCourseAdminRoles(admins, existing)
+		if err := checkManageCourseAdmins(r, existing, admins); err != nil {
+			l.Warnw("attempted to change role without permission", "admins", admins)
+			return err
 		}
 
 		err = aa.AddCourseAdmins(r.Context(), c.ID, admins, false)
-		var pqerr *pq.Error
-		if errors.As(err, &pqerr) && pqerr.Code == "23505" {
-			l.Warnw("site admin attempted to add already existing course admin", "err", err)
-			return StatusError{
-				http.StatusBadRequest,
-				"It looks like one of the admins you attempted to insert is already an admin. No admins were inserted. Unfortunately I don't know more.",
-			}
-		} else if err != nil {
+		if err != nil {
 			l.Errorw("failed to update course admins", "err", err)
 			return err
 		}
 
+		if err := s.audit(r, AuditAddAdmins, c.ID.String(), existing, admins); err != nil {
+			return err
+		}
 		l.Infow("added admins", "admins", admins)
 		return s.sendResponse(http.StatusNoContent, nil, w, r)
 	}
 }
 
+// UpdateCourseAdmins replaces the course's staff. Since that can take
+// away anyone's role, only those who can manage every role (and so
+// everyone being removed) may do it.
 func (s *Server) UpdateCourseAdmins(aa addCourseAdmins) E {
 	return func(w http.ResponseWriter, r *http.Request) error {
 		c := r.Context().Value(courseContextKey).(*Course)
 		l := s.getCtxLogger(r)
 
-		var admins []string
-		err := json.NewDecoder(r.Body).Decode(&admins)
+		admins, err := decodeCourseAdmins(r)
 		if err != nil {
 			l.Warnw("failed to decode admins from body", "err", err)
-			return StatusError{
-				http.StatusBadRequest,
-				"I couldn't decode the body. Are you sure it's a JSON array of emails (strings)? This error might help: " + err.Error(),
-			}
+			return err
+		}
+
+		existing, err := aa.GetCourseAdmins(r.Context(), c.ID)
+		if err != nil {
+			l.Errorw("failed to get course admins", "err", err)
+			return err
+		}
+
+		// Everyone currently on staff either keeps a role or loses it,
+		// so all of them need to be manageable.
+		keepCourseAdminRoles(admins, existing)
+		if err := checkManageCourseAdmins(r, existing, append(admins, existing...)); err != nil {
+			l.Warnw("attempted to overwrite roles without permission", "admins", admins)
+			return err
 		}
 
 		err = aa.AddCourseAdmins(r.Context(), c.ID, admins, true)
@@ -424,12 +1068,16 @@ func (s *Server) UpdateCourseAdmins(aa addCourseAdmins) E {
 			return err
 		}
 
+		if err := s.audit(r, AuditOverwriteAdmins, c.ID.String(), existing, admins); err != nil {
+			return err
+		}
 		l.Infow("overwrote admins", "admins", admins)
 		return s.sendResponse(http.StatusNoContent, nil, w, r)
 	}
 }
 
 type removeCourseAdmins interface {
+	getCourseAdmins
 	RemoveCourseAdmins(ctx context.Context, course ksuid.KSUID, admins []string) error
 }
 
@@ -448,13 +1096,225 @@ func (s *Server) RemoveCourseAdmins(ra removeCourseAdmins) E {
 			}
 		}
 
+		existing, err := ra.GetCourseAdmins(r.Context(), c.ID)
+		if err != nil {
+			l.Errorw("failed to get course admins", "err", err)
+			return err
+		}
+
+		removed := make([]*CourseStaff, len(admins))
+		for i, email := range admins {
+			removed[i] = &CourseStaff{Email: email}
+		}
+
+		if err := checkManageCourseAdmins(r, existing, removed); err != nil {
+			l.Warnw("attempted to remove admins without permission", "admins", admins)
+			return err
+		}
+
 		err = ra.RemoveCourseAdmins(r.Context(), c.ID, admins)
 		if err != nil {
 			l.Errorw("failed to remove admins", "err", err)
 			return err
 		}
 
+		if err := s.audit(r, AuditRemoveAdmins, c.ID.String(), existing, admins); err != nil {
+			return err
+		}
 		l.Infow("removed admins", "admins", admins)
 		return s.sendResponse(http.StatusNoContent, nil, w, r)
 	}
 }
+
+type getCourseGroups interface {
+	GetCourseGroups(ctx context.Context, course ksuid.KSUID) ([]*CourseGroup, error)
+}
+
+func (s *Server) GetCourseGroups(gg getCourseGroups) E {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		c := r.Context().Value(courseContextKey).(*Course)
+
+		groups, err := gg.GetCourseGroups(r.Context(), c.ID)
+		if err != nil {
+			s.getCtxLogger(r).Errorw("failed to get course groups", "err", err)
+			return err
+		}
+
+		return s.sendResponse(http.StatusOK, groups, w, r)
+	}
+}
+
+type updateCourseGroups interface {
+	getCourseGroups
+	UpdateCourseGroups(ctx context.Context, course ksuid.KSUID, groups []*CourseGroup) error
+}
+
+// UpdateCourseGroups replaces the OIDC groups whose members are on the
+// course's staff. As with UpdateCourseAdmins, the current user has to be
+// able to manage the roles of both the old groups and the new ones.
+func (s *Server) UpdateCourseGroups(ug updateCourseGroups) E {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		c := r.Context().Value(courseContextKey).(*Course)
+		role := r.Context().Value(courseRoleContextKey).(CourseRole)
+		l := s.getCtxLogger(r)
+
+		var groups []*CourseGroup
+		err := json.NewDecoder(r.Body).Decode(&groups)
+		if err != nil {
+			l.Warnw("failed to decode groups from body", "err", err)
+			return StatusError{
+				http.StatusBadRequest,
+				"I couldn't decode the body. Are you sure it's a JSON array of group names (strings) or objects with a group and role? This error might help: " + err.Error(),
+			}
+		}
+
+		for _, g := range groups {
+			if g == nil || g.Group == "" {
+				l.Warnw("got group without name")
+				return StatusError{
+					http.StatusBadRequest,
+					"It looks like one of the groups is missing a name.",
+				}
+			}
+
+			if !g.Role.Valid() {
+				l.Warnw("got group with unknown role", "role", g.Role)
+				return StatusError{
+					http.StatusBadRequest,
+					fmt.Sprintf(`I haven't seen the role "%s" before.`, g.Role),
+				}
+			}
+		}
+
+		existing, err := ug.GetCourseGroups(r.Context(), c.ID)
+		if err != nil {
+			l.Errorw("failed to get course groups", "err", err)
+			return err
+		}
+
+		for _, g := range append(groups, existing...) {
+			if !role.CanManage(g.Role) {
+				l.Warnw("attempted to overwrite groups without permission", "group", g.Group, "role", g.Role)
+				return StatusError{
+					http.StatusForbidden,
+					fmt.Sprintf("You can't change the role of the group %s.", g.Group),
+				}
+			}
+		}
+
+		err = ug.UpdateCourseGroups(r.Context(), c.ID, groups)
+		if err != nil {
+			l.Errorw("failed to update course groups", "err", err)
+			return err
+		}
+
+		if err := s.audit(r, AuditUpdateAdminGroups, c.ID.String(), existing, groups); err != nil {
+			return err
+		}
+		l.Infow("overwrote course groups", "groups", groups)
+		return s.sendResponse(http.StatusNoContent, nil, w, r)
+	}
+}
+
+type getCourseGuests interface {
+	GetCourseGuests(ctx context.Context, course ksuid.KSUID) ([]string, error)
+}
+
+func (s *Server) GetCourseGuests(gg getCourseGuests) E {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		c := r.Context().Value(courseContextKey).(*Course)
+
+		guests, err := gg.GetCourseGuests(r.Context(), c.ID)
+		if err != nil {
+			s.getCtxLogger(r).Errorw("failed to get course guests", "err", err)
+			return err
+		}
+
+		return s.sendResponse(http.StatusOK, guests, w, r)
+	}
+}
+
+// decodeCourseGuests reads a list of guest emails from the request body.
+func decodeCourseGuests(r *http.Request) ([]string, error) {
+	var guests []string
+	err := json.NewDecoder(r.Body).Decode(&guests)
+	if err != nil {
+		return nil, StatusError{
+			http.StatusBadRequest,
+			"I couldn't decode the body. Are you sure it's a JSON array of emails (strings)? This error might help: " + err.Error(),
+		}
+	}
+
+	for i, email := range guests {
+		email = strings.ToLower(strings.TrimSpace(email))
+		if !strings.Contains(email, "@") {
+			return nil, StatusError{
+				http.StatusBadRequest,
+				fmt.Sprintf("%q isn't an email address.", email),
+			}
+		}
+		guests[i] = email
+	}
+
+	return guests, nil
+}
+
+type addCourseGuests interface {
+	AddCourseGuests(ctx context.Context, course ksuid.KSUID, guests []string) error
+}
+
+// AddCourseGuests lets people from outside the valid domains into the
+// course.
+func (s *Server) AddCourseGuests(ag addCourseGuests) E {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		c := r.Context().Value(courseContextKey).(*Course)
+		l := s.getCtxLogger(r)
+
+		guests, err := decodeCourseGuests(r)
+		if err != nil {
+			l.Warnw("failed to decode guests from body", "err", err)
+			return err
+		}
+
+		err = ag.AddCourseGuests(r.Context(), c.ID, guests)
+		if err != nil {
+			l.Errorw("failed to add course guests", "err", err)
+			return err
+		}
+
+		if err := s.audit(r, AuditAddGuests, c.ID.String(), nil, guests); err != nil {
+			return err
+		}
+		l.Infow("added guests", "guests", guests)
+		return s.sendResponse(http.StatusNoContent, nil, w, r)
+	}
+}
+
+type removeCourseGuests interface {
+	RemoveCourseGuests(ctx context.Context, course ksuid.KSUID, guests []string) error
+}
+
+func (s *Server) RemoveCourseGuests(rg removeCourseGuests) E {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		c := r.Context().Value(courseContextKey).(*Course)
+		l := s.getCtxLogger(r)
+
+		guests, err := decodeCourseGuests(r)
+		if err != nil {
+			l.Warnw("failed to decode guests from body", "err", err)
+			return err
+		}
+
+		err = rg.RemoveCourseGuests(r.Context(), c.ID, guests)
+		if err != nil {
+			l.Errorw("failed to remove course guests", "err", err)
+			return err
+		}
+
+		if err := s.audit(r, AuditRemoveGuests, c.ID.String(), guests, nil); err != nil {
+			return err
+		}
+		l.Infow("removed guests", "guests", guests)
+		return s.sendResponse(http.StatusNoContent, nil, w, r)
+	}
+}
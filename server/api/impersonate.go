@@ -0,0 +1,187 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+const (
+	impersonatorContextKey = "impersonator"
+	impersonationHeader    = "X-Impersonating"
+	stopImpersonationPath  = "/users/@me/impersonation"
+)
+
+// impersonationAllowed reports whether a request can be made while
+// impersonating. Impersonation is only for looking: nothing done through
+// it could be traced back to the site admin, so the only change allowed
+// is stopping it.
+func impersonationAllowed(r *http.Request) bool {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	case http.MethodDelete:
+		return r.URL.Path == stopImpersonationPath
+	default:
+		return false
+	}
+}
+
+// impersonate swaps the user in the request for the one that the session's
+// site admin is impersonating, if they're impersonating anyone. The site
+// admin is checked again every time, so impersonation only applies while
+// they're still a site admin.
+func (s *Server) impersonate(sa siteAdmin, session map[interface{}]interface{}, w http.ResponseWriter, r *http.Request) (*http.Request, error) {
+	email, ok := session["impersonate_email"].(string)
+	if !ok {
+		return r, nil
+	}
+
+	admin, err := sa.SiteAdmin(r.Context(), r.Context().Value(emailContextKey).(string))
+	if err != nil {
+		return r, err
+	}
+	if !admin {
+		s.getCtxLogger(r).Warnw("non-admin has impersonation in session", "impersonating", email)
+		return r, nil
+	}
+
+	name, _ := session["impersonate_name"].(string)
+	firstName, _ := session["impersonate_first_name"].(string)
+	groups, _ := session["impersonate_groups"].([]string)
+	if groups == nil {
+		groups = make([]string, 0)
+	}
+
+	impersonator := r.Context().Value(emailContextKey).(string)
+	ctx := context.WithValue(r.Context(), impersonatorContextKey, impersonator)
+	ctx = context.WithValue(ctx, emailContextKey, email)
+	ctx = context.WithValue(ctx, nameContextKey, name)
+	ctx = context.WithValue(ctx, firstNameContextKey, firstName)
+	ctx = context.WithValue(ctx, GroupsContextKey, groups)
+	ctx = context.WithValue(ctx, loggerContextKey, s.getCtxLogger(r).With("email", email, "impersonator", impersonator))
+
+	w.Header().Set(impersonationHeader, email)
+	return r.WithContext(ctx), nil
+}
+
+// RejectImpersonation keeps site admins who are viewing the queue as
+// someone else out of things that would outlast the impersonation, like
+// making API tokens for that user, and out of logging in or out, which
+// would replace the session out from under it.
+func (s *Server) RejectImpersonation(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := r.Context().Value(impersonatorContextKey).(string); ok {
+			s.getCtxLogger(r).Warnw("attempted to use impersonated session where the real user is required")
+			s.errorMessage(
+				http.StatusForbidden,
+				"You can't do that while viewing the queue as someone else.",
+				w, r,
+			)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// StartImpersonation makes the site admin's session act as another user,
+// so they can see the queue the way that user does. Until they stop, the
+// session is read-only; requests are logged with the site admin as the
+// impersonator.
+func (s *Server) StartImpersonation() E {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		l := s.getCtxLogger(r)
+		email := r.Context().Value(emailContextKey).(string)
+
+		var user ImpersonatedUser
+		err := json.NewDecoder(r.Body).Decode(&user)
+		if err != nil {
+			l.Warnw("failed to decode impersonated user from body", "err", err)
+			return StatusError{
+				http.StatusBadRequest,
+				"I couldn't decode the body. Are you sure it's an object with an email, and optionally a name, first name, and groups? This error might help: " + err.Error(),
+			}
+		}
+
+		user.Email = strings.TrimSpace(user.Email)
+		if !strings.Contains(user.Email, "@") {
+			l.Warnw("got impersonated user without email", "impersonating", user.Email)
+			return StatusError{
+				http.StatusBadRequest,
+				"It looks like the user you want to view the queue as doesn't have an email address.",
+			}
+		}
+
+		if strings.EqualFold(user.Email, email) {
+			l.Warnw("site admin attempted to impersonate themselves")
+			return StatusError{
+				http.StatusBadRequest,
+				"You're already you!",
+			}
+		}
+
+		if user.Groups == nil {
+			user.Groups = make([]string, 0)
+		}
+
+		session, err := s.sessions.Get(r, "session")
+		if err != nil {
+			l.Errorw("failed to get session for impersonation", "err", err)
+			return err
+		}
+
+		session.Values["impersonate_email"] = user.Email
+		session.Values["impersonate_name"] = user.Name
+		session.Values["impersonate_first_name"] = user.FirstName
+		session.Values["impersonate_groups"] = user.Groups
+
+		err = s.sessions.Save(r, w, session)
+		if err != nil {
+			l.Errorw("failed to save session for impersonation", "err", err)
+			return err
+		}
+
+		l.Infow("started impersonating", "impersonating", user.Email)
+		w.Header().Set(impersonationHeader, user.Email)
+		return s.sendResponse(http.StatusNoContent, nil, w, r)
+	}
+}
+
+// StopImpersonation puts the site admin's session back to acting as
+// themselves.
+func (s *Server) StopImpersonation() E {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		l := s.getCtxLogger(r)
+
+		if _, ok := r.Context().Value(impersonatorContextKey).(string); !ok {
+			l.Warnw("attempted to stop impersonating without impersonating")
+			return StatusError{
+				http.StatusBadRequest,
+				"You aren't viewing the queue as anyone else.",
+			}
+		}
+
+		session, err := s.sessions.Get(r, "session")
+		if err != nil {
+			l.Errorw("failed to get session for impersonation", "err", err)
+			return err
+		}
+
+		delete(session.Values, "impersonate_email")
+		delete(session.Values, "impersonate_name")
+		delete(session.Values, "impersonate_first_name")
+		delete(session.Values, "impersonate_groups")
+
+		err = s.sessions.Save(r, w, session)
+		if err != nil {
+			l.Errorw("failed to save session after impersonation", "err", err)
+			return err
+		}
+
+		l.Infow("stopped impersonating")
+		w.Header().Del(impersonationHeader)
+		return s.sendResponse(http.StatusNoContent, nil, w, r)
+	}
+}
@@ -0,0 +1,172 @@
+package api
+
+import (
+	"context"
+	"time"
+
+	"github.com/segmentio/ksuid"
+	"go.uber.org/zap"
+)
+
+// A job is a unit of background work. Like a request, it runs inside
+// its own transaction, which is available through the context so the
+// same store functions can be used from here.
+type job func(ctx context.Context, l *zap.SugaredLogger) error
+
+// The abilities needed by the background jobs.
+type jobStore interface {
+	transactioner
+	syncTermQueues
+	getLastCallQueues
+	markAppointmentReminders
+	purgeExpiredSessions
+	backfillUserSessions
+}
+
+func (s *Server) startJobs(js jobStore) {
+	go s.runJobOnce(js, s.logger.With("job", "backfill_user_sessions"), s.backfillUserSessions(js))
+	go s.runJob(js, "sync_term_queues", time.Minute, s.syncTermQueues(js))
+	go s.runJob(js, "announce_last_call", time.Minute, s.announceLastCall(js))
+	go s.runJob(js, "remind_appointments", time.Minute, s.remindAppointments(js))
+	go s.runJob(js, "purge_expired_sessions", time.Hour, s.purgeExpiredSessions(js))
+}
+
+// runJob runs j immediately and then once every interval, forever.
+func (s *Server) runJob(tr transactioner, name string, interval time.Duration, j job) {
+	l := s.logger.With("job", name)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		s.runJobOnce(tr, l, j)
+		<-ticker.C
+	}
+}
+
+func (s *Server) runJobOnce(tr transactioner, l *zap.SugaredLogger, j job) {
+	defer func() {
+		if msg := recover(); msg != nil {
+			l.Errorw("recovered panic in job", "panic_message", msg)
+		}
+	}()
+
+	tx, err := tr.BeginTx()
+	if err != nil {
+		l.Errorw("failed to begin DB transaction", "err", err)
+		return
+	}
+
+	ctx := context.WithValue(context.Background(), TransactionContextKey, tx)
+	err = j(ctx, l)
+	if err != nil {
+		l.Errorw("job failed", "err", err)
+		err = tx.Rollback()
+		if err != nil {
+			l.Errorw("transaction rollback failed", "err", err)
+		}
+		return
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		l.Errorw("transaction commit failed", "err", err)
+	}
+}
+
+type syncTermQueues interface {
+	SyncTermQueues(ctx context.Context) ([]*Queue, error)
+}
+
+// syncTermQueues opens a course's queues when its term starts and takes
+// them down when it ends. In between, staff are free to change whether
+// queues are active.
+func (s *Server) syncTermQueues(sq syncTermQueues) job {
+	return func(ctx context.Context, l *zap.SugaredLogger) error {
+		queues, err := sq.SyncTermQueues(ctx)
+		if err != nil {
+			return err
+		}
+
+		for _, q := range queues {
+			l.Infow("updated queue activity for course term",
+				"queue_id", q.ID,
+				"course_id", q.Course,
+				"active", q.Active,
+			)
+			s.ps.Pub(WS("REFRESH", nil), QueueTopicGeneric(q.ID))
+		}
+
+		return nil
+	}
+}
+
+type getLastCallQueues interface {
+	GetLastCallQueues(ctx context.Context) ([]*QueueLastCall, error)
+}
+
+// announceLastCall lets everyone watching a scheduled queue know once it
+// enters its last call window, after which no new sign ups are taken.
+func (s *Server) announceLastCall(gl getLastCallQueues) job {
+	type closingSoon struct {
+		ClosesAt time.Time `json:"closes_at"`
+		LastCall int       `json:"last_call"`
+	}
+
+	// The closing time each queue was last announced for, so each closing
+	// is only announced once. Only this job touches it.
+	announced := make(map[ksuid.KSUID]time.Time)
+
+	return func(ctx context.Context, l *zap.SugaredLogger) error {
+		queues, err := gl.GetLastCallQueues(ctx)
+		if err != nil {
+			return err
+		}
+
+		now := time.Now()
+		for _, q := range queues {
+			closesAt, ok := ScheduleClosingTime(q.Schedule, now)
+			if !ok || !InLastCall(closesAt, q.LastCall, now) || announced[q.Queue].Equal(closesAt) {
+				continue
+			}
+			announced[q.Queue] = closesAt
+
+			l.Infow("announced last call",
+				"queue_id", q.Queue,
+				"closes_at", closesAt,
+			)
+			s.ps.Pub(WS("QUEUE_CLOSING_SOON", closingSoon{closesAt, q.LastCall}), QueueTopicGeneric(q.Queue))
+		}
+
+		return nil
+	}
+}
+
+type markAppointmentReminders interface {
+	MarkAppointmentReminders(ctx context.Context) ([]*AppointmentSlot, error)
+}
+
+// remindAppointments reminds the student and staff member of each
+// appointment coming up within its queue's reminder lead time. Reminders
+// are marked as sent in the same transaction, so each goes out once.
+func (s *Server) remindAppointments(ma markAppointmentReminders) job {
+	return func(ctx context.Context, l *zap.SugaredLogger) error {
+		appointments, err := ma.MarkAppointmentReminders(ctx)
+		if err != nil {
+			return err
+		}
+
+		for _, a := range appointments {
+			l.Infow("sent appointment reminder",
+				"queue_id", a.Queue,
+				"appointment_id", a.ID,
+				"scheduled_time", a.ScheduledTime,
+			)
+			s.ps.Pub(WS("APPOINTMENT_REMINDER", a.NoStaffEmail()), QueueTopicEmail(a.Queue, *a.StudentEmail))
+			if a.StaffEmail != nil {
+				s.ps.Pub(WS("APPOINTMENT_REMINDER", a), QueueTopicEmail(a.Queue, *a.StaffEmail))
+			}
+		}
+
+		return nil
+	}
+}
@@ -44,6 +44,13 @@ type transactioner interface {
 	BeginTx() (*sqlx.Tx, error)
 }
 
+// What the transaction middleware needs: transactions, and somewhere for
+// handlers to write audit records.
+type auditedTransactioner interface {
+	transactioner
+	addAuditRecord
+}
+
 const (
 	RequestErrorContextKey = "request_error"
 	TransactionContextKey  = "transaction"
@@ -55,7 +62,7 @@ const (
 // through transparently in the context). I'm not advocating that this is
 // the cleanest pattern, but we definitely need to get transactions into
 // each request.
-func (s *Server) transaction(tr transactioner) func(http.Handler) http.Handler {
+func (s *Server) transaction(tr auditedTransactioner) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			tx, err := tr.BeginTx()
@@ -72,6 +79,7 @@ func (s *Server) transaction(tr transactioner) func(http.Handler) http.Handler {
 			// other place (E.ServeHTTP).
 			ctx := context.WithValue(r.Context(), RequestErrorContextKey, &err)
 			ctx = context.WithValue(ctx, TransactionContextKey, tx)
+			ctx = context.WithValue(ctx, auditContextKey, addAuditRecord(tr))
 			r = r.WithContext(ctx)
 			next.ServeHTTP(w, r)
 
@@ -97,8 +105,27 @@ func (s *Server) transaction(tr transactioner) func(http.Handler) http.Handler {
 	}
 }
 
-func (s *Server) sessionRetriever(next http.Handler) http.Handler {
+// sessionRetriever fills in the user's details from their session, or
+// from their API token if the request has one in a bearer Authorization
+// header.
+func (s *Server) sessionRetriever(ru retrieveUser) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return s.retrieveSession(ru, next)
+	}
+}
+
+type retrieveUser interface {
+	useAPIToken
+	siteAdmin
+}
+
+func (s *Server) retrieveSession(ru retrieveUser, next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if header := r.Header.Get("Authorization"); strings.HasPrefix(header, "Bearer ") {
+			s.tokenRetriever(ru, strings.TrimPrefix(header, "Bearer "), next, w, r)
+			return
+		}
+
 		session, err := s.sessions.Get(r, "session")
 		if err != nil {
 			s.getCtxLogger(r).Infow("got invalid session", "err", err)
@@ -142,7 +169,24 @@ func (s *Server) sessionRetriever(next http.Handler) http.Handler {
 		ctx = context.WithValue(ctx, GroupsContextKey, groups)
 		ctx = context.WithValue(ctx, loggerContextKey, s.getCtxLogger(r).With("email", email))
 
-		next.ServeHTTP(w, r.WithContext(ctx))
+		r, err = s.impersonate(ru, session.Values, w, r.WithContext(ctx))
+		if err != nil {
+			s.getCtxLogger(r).Errorw("failed to check impersonation", "err", err)
+			s.internalServerError(w, r)
+			return
+		}
+
+		if _, ok := r.Context().Value(impersonatorContextKey).(string); ok && !impersonationAllowed(r) {
+			s.getCtxLogger(r).Warnw("attempted to make changes while impersonating", "method", r.Method, "path", r.URL.Path)
+			s.errorMessage(
+				http.StatusForbidden,
+				"You can only look around while viewing the queue as someone else. Stop viewing as them to make changes.",
+				w, r,
+			)
+			return
+		}
+
+		next.ServeHTTP(w, r)
 	})
 }
 
@@ -0,0 +1,179 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/segmentio/ksuid"
+)
+
+const (
+	maxNoteLength     = 5000
+	maxCategoryLength = 64
+)
+
+// decodeStaffNote reads a note from the request body and checks its
+// fields.
+func decodeStaffNote(r *http.Request) (*StaffNote, error) {
+	var note StaffNote
+	err := json.NewDecoder(r.Body).Decode(&note)
+	if err != nil {
+		return nil, StatusError{
+			http.StatusBadRequest,
+			"We couldn't read the note in the request body.",
+		}
+	}
+
+	note.Category = strings.TrimSpace(note.Category)
+	if len(note.Note) > maxNoteLength || len(note.Category) > maxCategoryLength {
+		return nil, StatusError{
+			http.StatusBadRequest,
+			fmt.Sprintf("Notes can be at most %d characters, and categories at most %d.", maxNoteLength, maxCategoryLength),
+		}
+	}
+
+	switch note.Resolution {
+	case ResolutionNone, ResolutionResolved, ResolutionUnresolved, ResolutionFollowUp:
+	default:
+		return nil, StatusError{
+			http.StatusBadRequest,
+			fmt.Sprintf("We don't know the resolution %q.", note.Resolution),
+		}
+	}
+
+	return &note, nil
+}
+
+type setEntryNote interface {
+	getQueueEntry
+	SetEntryNote(ctx context.Context, note *StaffNote) (*StaffNote, error)
+}
+
+func (s *Server) SetEntryNote(sn setEntryNote) E {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		q := r.Context().Value(queueContextKey).(*Queue)
+		email := r.Context().Value(emailContextKey).(string)
+		id := chi.URLParam(r, "entry_id")
+		l := s.getCtxLogger(r).With("entry_id", id)
+
+		entryID, err := ksuid.Parse(id)
+		if err != nil {
+			l.Warnw("failed to parse entry ID", "err", err)
+			return StatusError{
+				http.StatusNotFound,
+				"I'm not able to find that queue entry.",
+			}
+		}
+
+		entry, err := sn.GetQueueEntry(r.Context(), entryID, true)
+		if err != nil || entry.Queue != q.ID {
+			l.Warnw("attempted to get non-existent queue entry with valid ksuid")
+			return StatusError{
+				http.StatusNotFound,
+				"I'm not able to find that queue entry.",
+			}
+		}
+
+		if entry.Active.Valid {
+			l.Warnw("attempted to add note to entry still on queue")
+			return StatusError{
+				http.StatusBadRequest,
+				"That student is still on the queue. Notes can be added once they're off it.",
+			}
+		}
+
+		note, err := decodeStaffNote(r)
+		if err != nil {
+			l.Warnw("got invalid staff note", "err", err)
+			return err
+		}
+
+		note.Queue = q.ID
+		note.Email = entry.Email
+		note.Entry = &entry.ID
+		note.Author = email
+
+		newNote, err := sn.SetEntryNote(r.Context(), note)
+		if err != nil {
+			l.Errorw("failed to set entry note", "err", err)
+			return err
+		}
+
+		l.Infow("set entry note", "note_id", newNote.ID)
+
+		return s.sendResponse(http.StatusOK, newNote, w, r)
+	}
+}
+
+type setAppointmentNote interface {
+	SetAppointmentNote(ctx context.Context, note *StaffNote) (*StaffNote, error)
+}
+
+func (s *Server) SetAppointmentNote(sn setAppointmentNote) E {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		q := r.Context().Value(queueContextKey).(*Queue)
+		appointment := r.Context().Value(appointmentContextKey).(*AppointmentSlot)
+		email := r.Context().Value(emailContextKey).(string)
+		l := s.getCtxLogger(r)
+
+		if appointment.StudentEmail == nil {
+			l.Warnw("attempted to add note to appointment without a student")
+			return StatusError{
+				http.StatusBadRequest,
+				"Nobody signed up for this appointment, so there's nothing to write down.",
+			}
+		}
+
+		if time.Now().Before(appointment.ScheduledTime) {
+			l.Warnw("attempted to add note to appointment in the future")
+			return StatusError{
+				http.StatusBadRequest,
+				"This appointment hasn't started yet!",
+			}
+		}
+
+		note, err := decodeStaffNote(r)
+		if err != nil {
+			l.Warnw("got invalid staff note", "err", err)
+			return err
+		}
+
+		note.Queue = q.ID
+		note.Email = *appointment.StudentEmail
+		note.Appointment = &appointment.ID
+		note.Author = email
+
+		newNote, err := sn.SetAppointmentNote(r.Context(), note)
+		if err != nil {
+			l.Errorw("failed to set appointment note", "err", err)
+			return err
+		}
+
+		l.Infow("set appointment note", "note_id", newNote.ID)
+
+		return s.sendResponse(http.StatusOK, newNote, w, r)
+	}
+}
+
+type getStaffNotes interface {
+	GetStaffNotes(ctx context.Context, queue ksuid.KSUID, emails []string) ([]*StaffNote, error)
+}
+
+// staffNotesByEmail groups the notes left about each of the given students.
+func staffNotesByEmail(ctx context.Context, gn getStaffNotes, queue ksuid.KSUID, emails []string) (map[string][]*StaffNote, error) {
+	notes, err := gn.GetStaffNotes(ctx, queue, emails)
+	if err != nil {
+		return nil, err
+	}
+
+	byEmail := make(map[string][]*StaffNote)
+	for _, n := range notes {
+		byEmail[n.Email] = append(byEmail[n.Email], n)
+	}
+	return byEmail, nil
+}
@@ -105,6 +105,8 @@ type getQueueDetails interface {
 	getQueueAnnouncements
 	getCurrentDaySchedule
 	getQueueConfiguration
+	getAppointmentsInTimeFrame
+	getStaffNotes
 }
 
 func (s *Server) GetQueue(gd getQueueDetails) E {
@@ -164,6 +166,34 @@ func (s *Server) GetQueue(gd getQueueDetails) E {
 			}
 			s.websocketCountLock.Unlock()
 			response["online"] = m
+
+			// Notes from earlier sessions with everyone waiting today
+			emails := make([]string, 0, len(entries))
+			for _, e := range entries {
+				emails = append(emails, e.Email)
+			}
+
+			if q.Type == Appointments {
+				from, to := DateBounds(time.Now())
+				appointments, err := gd.GetAppointments(r.Context(), q.ID, from, to)
+				if err != nil {
+					l.Errorw("failed to get appointments", "err", err)
+					return err
+				}
+
+				for _, a := range appointments {
+					if a.StudentEmail != nil {
+						emails = append(emails, *a.StudentEmail)
+					}
+				}
+			}
+
+			notes, err := staffNotesByEmail(r.Context(), gd, q.ID, emails)
+			if err != nil {
+				l.Errorw("failed to get staff notes", "err", err)
+				return err
+			}
+			response["notes"] = notes
 		}
 
 		config, err := gd.GetQueueConfiguration(r.Context(), q.ID)
@@ -184,6 +214,10 @@ func (s *Server) GetQueue(gd getQueueDetails) E {
 		response["half_hour"] = halfHour
 		if config.Scheduled {
 			response["open"] = schedule[halfHour] == 'o' || schedule[halfHour] == 'p'
+			if closesAt, ok := ScheduleClosingTime(schedule, time.Now()); ok {
+				response["closes_at"] = closesAt
+				response["in_last_call"] = InLastCall(closesAt, config.LastCall, time.Now())
+			}
 		} else {
 			response["open"] = config.ManualOpen
 		}
@@ -413,10 +447,97 @@ func (s *Server) UpdateQueue(uq updateQueue) E {
 	}
 }
 
+type archiveQueue interface {
+	ArchiveQueue(ctx context.Context, queue ksuid.KSUID) error
+}
+
+// ArchiveQueue takes the queue down for good without losing its history,
+// which course staff can still look through.
+func (s *Server) ArchiveQueue(aq archiveQueue) E {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		q := r.Context().Value(queueContextKey).(*Queue)
+		l := s.getCtxLogger(r)
+
+		if q.ArchivedAt != nil {
+			l.Warnw("attempted to archive archived queue")
+			return StatusError{
+				http.StatusConflict,
+				"This queue has already been archived.",
+			}
+		}
+
+		err := aq.ArchiveQueue(r.Context(), q.ID)
+		if err != nil {
+			l.Errorw("failed to archive queue", "err", err)
+			return err
+		}
+
+		if err := s.audit(r, AuditArchiveQueue, q.ID.String(), nil, nil); err != nil {
+			return err
+		}
+
+		s.ps.Pub(WS("REFRESH", nil), QueueTopicGeneric(q.ID))
+
+		l.Infow("archived queue")
+		return s.sendResponse(http.StatusNoContent, nil, w, r)
+	}
+}
+
+type restoreQueue interface {
+	getCourse
+	RestoreQueue(ctx context.Context, queue ksuid.KSUID, active bool) error
+}
+
+func (s *Server) RestoreQueue(rq restoreQueue) E {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		q := r.Context().Value(queueContextKey).(*Queue)
+		l := s.getCtxLogger(r)
+
+		if q.ArchivedAt == nil {
+			l.Warnw("attempted to restore queue that isn't archived")
+			return StatusError{
+				http.StatusConflict,
+				"This queue isn't archived.",
+			}
+		}
+
+		course, err := rq.GetCourse(r.Context(), q.Course)
+		if err != nil {
+			l.Errorw("failed to get course", "err", err)
+			return err
+		}
+
+		if course.ArchivedAt != nil {
+			l.Warnw("attempted to restore queue in archived course")
+			return StatusError{
+				http.StatusConflict,
+				"This queue's course is archived. Restore the course first!",
+			}
+		}
+
+		err = rq.RestoreQueue(r.Context(), q.ID, course.InTerm(time.Now()))
+		if err != nil {
+			l.Errorw("failed to restore queue", "err", err)
+			return err
+		}
+
+		if err := s.audit(r, AuditRestoreQueue, q.ID.String(), nil, nil); err != nil {
+			return err
+		}
+
+		s.ps.Pub(WS("REFRESH", nil), QueueTopicGeneric(q.ID))
+
+		l.Infow("restored queue")
+		return s.sendResponse(http.StatusNoContent, nil, w, r)
+	}
+}
+
 type removeQueue interface {
 	RemoveQueue(ctx context.Context, queue ksuid.KSUID) error
 }
 
+// RemoveQueue permanently removes the queue along with all of its
+// history.
 func (s *Server) RemoveQueue(rq removeQueue) E {
 	return func(w http.ResponseWriter, r *http.Request) error {
 		q := r.Context().Value(queueContextKey).(*Queue)
@@ -850,7 +971,11 @@ func (s *Server) PinQueueEntry(pb pinQueueEntry) E {
 			return err
 		}
 
+		before := *entry
 		entry.Pinned = true
+		if err := s.audit(r, AuditPinEntry, entry.ID.String(), before, entry); err != nil {
+			return err
+		}
 
 		l.Infow("pinned queue entry")
 
@@ -942,7 +1067,15 @@ type randomizeQueueEntries interface {
 func (s *Server) RandomizeQueueEntries(re randomizeQueueEntries) E {
 	return func(w http.ResponseWriter, r *http.Request) error {
 		q := r.Context().Value(queueContextKey).(*Queue)
-		err := re.RandomizeQueueEntries(r.Context(), q.ID)
+		before, err := re.GetQueueEntries(r.Context(), q.ID, true)
+		if err != nil {
+			s.getCtxLogger(r).Errorw("failed to get queue entries before randomization",
+				"err", err,
+			)
+			return err
+		}
+
+		err = re.RandomizeQueueEntries(r.Context(), q.ID)
 		if err != nil {
 			s.getCtxLogger(r).Errorw("failed to randomize queue",
 				"err", err,
@@ -957,6 +1090,10 @@ func (s *Server) RandomizeQueueEntries(re randomizeQueueEntries) E {
 			return err
 		}
 
+		if err := s.audit(r, AuditRandomizeQueue, q.ID.String(), entryIDs(before), entryIDs(entries)); err != nil {
+			return err
+		}
+
 		s.ps.Pub(WS("QUEUE_RANDOMIZE", nil), QueueTopicGeneric(q.ID))
 
 		for _, e := range entries {
@@ -971,6 +1108,7 @@ func (s *Server) RandomizeQueueEntries(re randomizeQueueEntries) E {
 }
 
 type clearQueueEntries interface {
+	getQueueEntries
 	ClearQueueEntries(ctx context.Context, queue ksuid.KSUID, remover string) error
 }
 
@@ -978,12 +1116,22 @@ func (s *Server) ClearQueueEntries(ce clearQueueEntries) E {
 	return func(w http.ResponseWriter, r *http.Request) error {
 		q := r.Context().Value(queueContextKey).(*Queue)
 		email := r.Context().Value(emailContextKey).(string)
-		err := ce.ClearQueueEntries(r.Context(), q.ID, email)
+		entries, err := ce.GetQueueEntries(r.Context(), q.ID, true)
+		if err != nil {
+			s.getCtxLogger(r).Errorw("failed to get queue entries before clearing", "err", err)
+			return err
+		}
+
+		err = ce.ClearQueueEntries(r.Context(), q.ID, email)
 		if err != nil {
 			s.getCtxLogger(r).Errorw("failed to clear queue", "err", err)
 			return err
 		}
 
+		if err := s.audit(r, AuditClearQueue, q.ID.String(), entryIDs(entries), nil); err != nil {
+			return err
+		}
+
 		s.getCtxLogger(r).Info("cleared queue")
 
 		s.ps.Pub(WS("QUEUE_CLEAR", email), QueueTopicAdmin(q.ID))
@@ -1033,6 +1181,10 @@ func (s *Server) AddQueueAnnouncement(aa addQueueAnnouncement) E {
 			"announcement", newAnnouncement,
 		)
 
+		if err := s.audit(r, AuditAddAnnouncement, newAnnouncement.ID.String(), nil, newAnnouncement); err != nil {
+			return err
+		}
+
 		s.ps.Pub(WS("ANNOUNCEMENT_CREATE", newAnnouncement), QueueTopicGeneric(q.ID))
 
 		return s.sendResponse(http.StatusCreated, newAnnouncement, w, r)
@@ -1040,6 +1192,7 @@ func (s *Server) AddQueueAnnouncement(aa addQueueAnnouncement) E {
 }
 
 type removeQueueAnnouncement interface {
+	getQueueAnnouncements
 	RemoveQueueAnnouncement(context.Context, ksuid.KSUID) error
 }
 
@@ -1060,6 +1213,19 @@ func (s *Server) RemoveQueueAnnouncement(ra removeQueueAnnouncement) E {
 			}
 		}
 
+		announcements, err := ra.GetQueueAnnouncements(r.Context(), q.ID)
+		if err != nil {
+			s.getCtxLogger(r).Errorw("failed to get announcements", "err", err)
+			return err
+		}
+
+		var removed *Announcement
+		for _, a := range announcements {
+			if a.ID == announcement {
+				removed = a
+			}
+		}
+
 		err = ra.RemoveQueueAnnouncement(r.Context(), announcement)
 		if err != nil {
 			s.getCtxLogger(r).Errorw("failed to remove announcement",
@@ -1073,6 +1239,12 @@ func (s *Server) RemoveQueueAnnouncement(ra removeQueueAnnouncement) E {
 			"announcement_id", announcement,
 		)
 
+		if removed != nil {
+			if err := s.audit(r, AuditRemoveAnnouncement, announcement.String(), removed, nil); err != nil {
+				return err
+			}
+		}
+
 		s.ps.Pub(WS("ANNOUNCEMENT_DELETE", announcement.String()), QueueTopicGeneric(q.ID))
 
 		return s.sendResponse(http.StatusNoContent, nil, w, r)
@@ -1096,7 +1268,30 @@ func (s *Server) GetQueueSchedule(gs getQueueSchedule) E {
 	}
 }
 
+// validateQueueSchedule checks that a day's schedule has one valid
+// state for each half hour of the day. It's only used for imports;
+// UpdateQueueSchedule has always only checked the length, and existing
+// callers rely on that.
+func validateQueueSchedule(schedule string) error {
+	if len(schedule) != 48 {
+		return StatusError{
+			http.StatusBadRequest,
+			"Make sure your schedule is 48 characters long!",
+		}
+	}
+
+	if strings.Trim(schedule, "ocp") != "" {
+		return StatusError{
+			http.StatusBadRequest,
+			"Your schedule can only contain o (open), p (early sign up), and c (closed).",
+		}
+	}
+
+	return nil
+}
+
 type updateQueueSchedule interface {
+	getQueueSchedule
 	UpdateQueueSchedule(ctx context.Context, queue ksuid.KSUID, schedules []string) error
 }
 
@@ -1128,12 +1323,22 @@ func (s *Server) UpdateQueueSchedule(us updateQueueSchedule) E {
 			}
 		}
 
+		before, err := us.GetQueueSchedule(r.Context(), q.ID)
+		if err != nil {
+			s.getCtxLogger(r).Errorw("failed to get queue schedule", "err", err)
+			return err
+		}
+
 		err = us.UpdateQueueSchedule(r.Context(), q.ID, schedules)
 		if err != nil {
 			s.getCtxLogger(r).Errorw("failed to update schedule", "err", err)
 			return err
 		}
 
+		if err := s.audit(r, AuditUpdateSchedule, q.ID.String(), before, schedules); err != nil {
+			return err
+		}
+
 		s.getCtxLogger(r).Infow("updated queue schedule", "schedules", schedules)
 
 		s.ps.Pub(WS("REFRESH", nil), QueueTopicGeneric(q.ID))
@@ -1161,6 +1366,7 @@ func (s *Server) GetQueueConfiguration(gc getQueueConfiguration) E {
 }
 
 type updateQueueConfiguration interface {
+	getQueueConfiguration
 	UpdateQueueConfiguration(ctx context.Context, queue ksuid.KSUID, configuration *QueueConfiguration) error
 }
 
@@ -1201,12 +1407,81 @@ func (s *Server) UpdateQueueConfiguration(uc updateQueueConfiguration) E {
 			}
 		}
 
+		if config.LastCall < 0 {
+			s.getCtxLogger(r).Warnw("negative last call", "last_call", config.LastCall)
+			return StatusError{
+				http.StatusBadRequest,
+				"The last call window can't be negative.",
+			}
+		}
+
+		// Sections are matched exactly against the roster, where they're
+		// trimmed on import.
+		sections := make(pq.StringArray, 0, len(config.Sections))
+		sectionSet := make(map[string]struct{})
+		for _, section := range config.Sections {
+			section = strings.TrimSpace(section)
+			if section == "" {
+				s.getCtxLogger(r).Warnw("got blank section", "sections", config.Sections)
+				return StatusError{
+					http.StatusBadRequest,
+					"Sections can't be blank.",
+				}
+			}
+
+			if _, ok := sectionSet[section]; !ok {
+				sectionSet[section] = struct{}{}
+				sections = append(sections, section)
+			}
+		}
+		config.Sections = sections
+
+		if config.BookingHorizon < 1 {
+			s.getCtxLogger(r).Warnw("booking horizon too short", "booking_horizon", config.BookingHorizon)
+			return StatusError{
+				http.StatusBadRequest,
+				"The booking horizon has to be at least one day.",
+			}
+		}
+
+		if config.AppointmentReminder < 0 {
+			s.getCtxLogger(r).Warnw("negative appointment reminder", "appointment_reminder", config.AppointmentReminder)
+			return StatusError{
+				http.StatusBadRequest,
+				"The appointment reminder lead time can't be negative.",
+			}
+		}
+
+		if config.NoShowLimit < 0 || config.NoShowPenalty < 0 {
+			s.getCtxLogger(r).Warnw("negative no-show policy",
+				"no_show_limit", config.NoShowLimit,
+				"no_show_penalty", config.NoShowPenalty,
+			)
+			return StatusError{
+				http.StatusBadRequest,
+				"The no-show limit and penalty can't be negative.",
+			}
+		}
+
+		before, err := uc.GetQueueConfiguration(r.Context(), q.ID)
+		if err != nil {
+			s.getCtxLogger(r).Errorw("failed to get queue configuration", "err", err)
+			return err
+		}
+
 		err = uc.UpdateQueueConfiguration(r.Context(), q.ID, &config)
 		if err != nil {
 			s.getCtxLogger(r).Errorw("failed to update queue configuration", "err", err)
 			return err
 		}
 
+		// The manual open status isn't part of the update, so it stays put.
+		config.ID = q.ID
+		config.ManualOpen = before.ManualOpen
+		if err := s.audit(r, AuditUpdateConfiguration, q.ID.String(), before, config); err != nil {
+			return err
+		}
+
 		s.getCtxLogger(r).Infow("updated queue configuration", "configuration", config)
 
 		s.ps.Pub(WS("REFRESH", nil), QueueTopicGeneric(q.ID))
@@ -1216,6 +1491,7 @@ func (s *Server) UpdateQueueConfiguration(uc updateQueueConfiguration) E {
 }
 
 type updateQueueOpenStatus interface {
+	getQueueConfiguration
 	UpdateQueueOpenStatus(ctx context.Context, queue ksuid.KSUID, open bool) error
 }
 
@@ -1237,12 +1513,22 @@ func (s *Server) UpdateQueueOpenStatus(uo updateQueueOpenStatus) E {
 			}
 		}
 
-		err := uo.UpdateQueueOpenStatus(r.Context(), q.ID, open)
+		config, err := uo.GetQueueConfiguration(r.Context(), q.ID)
+		if err != nil {
+			s.getCtxLogger(r).Errorw("failed to get queue configuration", "err", err)
+			return err
+		}
+
+		err = uo.UpdateQueueOpenStatus(r.Context(), q.ID, open)
 		if err != nil {
 			s.getCtxLogger(r).Errorw("failed to update queue open status", "err", err)
 			return err
 		}
 
+		if err := s.audit(r, AuditUpdateOpenStatus, q.ID.String(), config.ManualOpen, open); err != nil {
+			return err
+		}
+
 		s.getCtxLogger(r).Infow("updated queue open status", "open", open)
 
 		s.ps.Pub(WS("QUEUE_OPEN", open), QueueTopicGeneric(q.ID))
@@ -1329,7 +1615,6 @@ func (s *Server) GetQueueGroups(gg getQueueGroups) E {
 }
 
 type updateQueueGroups interface {
-	UpdateQueueRoster(ctx context.Context, queue ksuid.KSUID, students []string) error
 	UpdateQueueGroups(ctx context.Context, queue ksuid.KSUID, groups [][]string) error
 }
 
@@ -1353,19 +1638,6 @@ func (s *Server) UpdateQueueGroups(ug updateQueueGroups) E {
 			return err
 		}
 
-		var students []string
-		for _, group := range groups {
-			for _, student := range group {
-				students = append(students, student)
-			}
-		}
-
-		err = ug.UpdateQueueRoster(r.Context(), q.ID, students)
-		if err != nil {
-			s.getCtxLogger(r).Errorw("failed to update roster", "err", err)
-			return err
-		}
-
 		s.getCtxLogger(r).Infow("updated groups")
 		return s.sendResponse(http.StatusNoContent, nil, w, r)
 	}
@@ -0,0 +1,84 @@
+package api
+
+// Permission is something a course's staff can be allowed to do.
+type Permission string
+
+const (
+	// Day-to-day work on queues: helping students, announcements,
+	// messages, claiming appointments, and leaving notes.
+	PermissionHelpStudents Permission = "help_students"
+
+	// Changing how queues run: configuration, schedules, groups, the
+	// course roster, appointment types, and clearing or randomizing the
+	// queue.
+	PermissionManageQueues Permission = "manage_queues"
+
+	// Adding and removing course staff. Staff can only manage roles
+	// below their own (see CanManage).
+	PermissionManageStaff Permission = "manage_staff"
+
+	// Changing the course itself, creating its queues, and archiving
+	// or restoring the course and its queues.
+	PermissionManageCourse Permission = "manage_course"
+)
+
+// rolePermissions is the permission matrix for course roles.
+var rolePermissions = map[CourseRole][]Permission{
+	RoleTA: {
+		PermissionHelpStudents,
+	},
+	RoleHeadTA: {
+		PermissionHelpStudents,
+		PermissionManageQueues,
+		PermissionManageStaff,
+	},
+	RoleInstructor: {
+		PermissionHelpStudents,
+		PermissionManageQueues,
+		PermissionManageStaff,
+		PermissionManageCourse,
+	},
+}
+
+// Valid reports whether r is a role that can be given to course staff.
+func (r CourseRole) Valid() bool {
+	_, ok := rolePermissions[r]
+	return ok
+}
+
+// Can reports whether the role grants p.
+func (r CourseRole) Can(p Permission) bool {
+	for _, rp := range rolePermissions[r] {
+		if rp == p {
+			return true
+		}
+	}
+	return false
+}
+
+func (r CourseRole) rank() int {
+	switch r {
+	case RoleTA:
+		return 1
+	case RoleHeadTA:
+		return 2
+	case RoleInstructor:
+		return 3
+	}
+	return 0
+}
+
+// Outranks reports whether r is a higher role than other.
+func (r CourseRole) Outranks(other CourseRole) bool {
+	return r.rank() > other.rank()
+}
+
+// CanManage reports whether staff with role r may give someone the role
+// other, or take it away. Instructors can manage anyone, including each
+// other; everyone else can only manage roles below their own.
+func (r CourseRole) CanManage(other CourseRole) bool {
+	if !r.Can(PermissionManageStaff) {
+		return false
+	}
+	return r == RoleInstructor || r.Outranks(other)
+}
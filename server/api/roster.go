@@ -0,0 +1,308 @@
+package api
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/segmentio/ksuid"
+	"go.uber.org/zap"
+)
+
+// Course rosters are imported from CSV. The header names the columns, in
+// any order; only email is required:
+//
+//	email,name,student_id,section
+//	student@example.com,Jane Doe,12345678,001
+//
+// Adding to the roster leaves everyone not in the file alone, and keeps
+// the current values of any columns the file leaves out. Overwriting the
+// roster removes everyone not in the file.
+var rosterCSVColumns = []string{"email", "name", "student_id", "section"}
+
+// The maximum size of an uploaded roster CSV.
+const maxRosterCSVSize = 4 << 20
+
+type getCourseRoster interface {
+	GetCourseRoster(ctx context.Context, course ksuid.KSUID) ([]*RosterStudent, error)
+}
+
+func (s *Server) GetCourseRoster(gr getCourseRoster) E {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		c := r.Context().Value(courseContextKey).(*Course)
+
+		roster, err := gr.GetCourseRoster(r.Context(), c.ID)
+		if err != nil {
+			s.getCtxLogger(r).Errorw("failed to get course roster", "err", err)
+			return err
+		}
+
+		return s.sendResponse(http.StatusOK, roster, w, r)
+	}
+}
+
+type updateCourseRoster interface {
+	getCourseRoster
+	AddCourseRosterStudents(ctx context.Context, course ksuid.KSUID, students []*RosterStudent) error
+	RemoveCourseRosterStudents(ctx context.Context, course ksuid.KSUID, students []string) error
+}
+
+// AddCourseRoster adds the students in the uploaded CSV to the course's
+// roster, or updates them if they're already on it.
+func (s *Server) AddCourseRoster(ur updateCourseRoster) E {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		return s.importCourseRoster(ur, false, w, r)
+	}
+}
+
+// UpdateCourseRoster replaces the course's roster with the uploaded CSV.
+func (s *Server) UpdateCourseRoster(ur updateCourseRoster) E {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		return s.importCourseRoster(ur, true, w, r)
+	}
+}
+
+// importCourseRoster responds with what the import changes. With
+// ?dry_run=true, nothing is actually changed, so staff can check the
+// import first.
+func (s *Server) importCourseRoster(ur updateCourseRoster, overwrite bool, w http.ResponseWriter, r *http.Request) error {
+	c := r.Context().Value(courseContextKey).(*Course)
+	dryRun := r.URL.Query().Get("dry_run") == "true"
+	l := s.getCtxLogger(r).With("overwrite", overwrite, "dry_run", dryRun)
+
+	reader := csv.NewReader(http.MaxBytesReader(w, r.Body, maxRosterCSVSize))
+	reader.TrimLeadingSpace = true
+	records, err := reader.ReadAll()
+	if err != nil {
+		l.Warnw("failed to read roster CSV", "err", err)
+		return StatusError{
+			http.StatusBadRequest,
+			"I couldn't read the CSV you uploaded. This error might help: " + err.Error(),
+		}
+	}
+
+	if len(records) < 1 {
+		l.Warnw("got empty roster CSV")
+		return StatusError{
+			http.StatusBadRequest,
+			"The CSV should start with a header naming its columns.",
+		}
+	}
+
+	var rowErrors []CSVRowError
+	fail := func(row int, format string, args ...interface{}) {
+		rowErrors = append(rowErrors, CSVRowError{Row: row, Message: fmt.Sprintf(format, args...)})
+	}
+
+	// Where each column is in the file, if it's there at all.
+	columns := make(map[string]int)
+	for i, name := range records[0] {
+		name = strings.ReplaceAll(strings.ToLower(strings.TrimSpace(name)), " ", "_")
+		known := false
+		for _, column := range rosterCSVColumns {
+			known = known || name == column
+		}
+
+		if !known {
+			fail(1, "%q isn't a roster column; use %s", name, strings.Join(rosterCSVColumns, ", "))
+			continue
+		}
+
+		if _, ok := columns[name]; ok {
+			fail(1, "the %s column appears more than once", name)
+			continue
+		}
+		columns[name] = i
+	}
+
+	if _, ok := columns["email"]; !ok {
+		fail(1, "the header has to include an email column")
+	}
+
+	if len(rowErrors) > 0 {
+		return s.rejectRosterImport(l, rowErrors, w, r)
+	}
+
+	existing, err := ur.GetCourseRoster(r.Context(), c.ID)
+	if err != nil {
+		l.Errorw("failed to get course roster", "err", err)
+		return err
+	}
+
+	current := make(map[string]*RosterStudent, len(existing))
+	for _, student := range existing {
+		current[student.Email] = student
+	}
+
+	diff := RosterDiff{
+		DryRun:  dryRun,
+		Added:   make([]*RosterStudent, 0),
+		Updated: make([]*RosterUpdate, 0),
+		Removed: make([]*RosterStudent, 0),
+	}
+	var changed []*RosterStudent
+	seen := make(map[string]int)
+
+	for i, record := range records[1:] {
+		row := i + 2
+		field := func(column string) (string, bool) {
+			idx, ok := columns[column]
+			if !ok {
+				return "", false
+			}
+			return strings.TrimSpace(record[idx]), true
+		}
+
+		email, _ := field("email")
+		email = strings.ToLower(email)
+		if !strings.Contains(email, "@") {
+			fail(row, "%q isn't an email address", email)
+			continue
+		}
+
+		if first, ok := seen[email]; ok {
+			fail(row, "%s is already on row %d", email, first)
+			continue
+		}
+		seen[email] = row
+
+		// Columns left out of the file keep their current values when
+		// adding to the roster.
+		student := &RosterStudent{Email: email}
+		old, onRoster := current[email]
+		if onRoster && !overwrite {
+			*student = *old
+		}
+		if name, ok := field("name"); ok {
+			student.Name = name
+		}
+		if id, ok := field("student_id"); ok {
+			student.StudentID = id
+		}
+		if section, ok := field("section"); ok {
+			student.Section = section
+		}
+
+		if !onRoster {
+			diff.Added = append(diff.Added, student)
+			changed = append(changed, student)
+		} else if *old != *student {
+			diff.Updated = append(diff.Updated, &RosterUpdate{Before: old, After: student})
+			changed = append(changed, student)
+		}
+	}
+
+	if len(rowErrors) > 0 {
+		return s.rejectRosterImport(l, rowErrors, w, r)
+	}
+
+	var removed []string
+	if overwrite {
+		for _, student := range existing {
+			if _, ok := seen[student.Email]; !ok {
+				diff.Removed = append(diff.Removed, student)
+				removed = append(removed, student.Email)
+			}
+		}
+	}
+
+	if dryRun {
+		l.Infow("checked roster import",
+			"added", len(diff.Added),
+			"updated", len(diff.Updated),
+			"removed", len(diff.Removed),
+		)
+		return s.sendResponse(http.StatusOK, diff, w, r)
+	}
+
+	err = ur.AddCourseRosterStudents(r.Context(), c.ID, changed)
+	if err != nil {
+		l.Errorw("failed to update course roster", "err", err)
+		return err
+	}
+
+	if len(removed) > 0 {
+		err = ur.RemoveCourseRosterStudents(r.Context(), c.ID, removed)
+		if err != nil {
+			l.Errorw("failed to remove students from course roster", "err", err)
+			return err
+		}
+	}
+
+	if err := s.audit(r, AuditImportRoster, c.ID.String(), nil, diff); err != nil {
+		return err
+	}
+
+	l.Infow("imported roster",
+		"added", len(diff.Added),
+		"updated", len(diff.Updated),
+		"removed", len(diff.Removed),
+	)
+	return s.sendResponse(http.StatusOK, diff, w, r)
+}
+
+func (s *Server) rejectRosterImport(l *zap.SugaredLogger, rowErrors []CSVRowError, w http.ResponseWriter, r *http.Request) error {
+	l.Warnw("rejected roster import", "errors", rowErrors)
+	return s.sendResponse(http.StatusBadRequest, struct {
+		Message string        `json:"message"`
+		Errors  []CSVRowError `json:"errors"`
+	}{
+		"Some rows of the roster had problems, so nothing was imported.",
+		rowErrors,
+	}, w, r)
+}
+
+// RemoveCourseRosterStudents takes the students with the given emails off
+// the course's roster.
+func (s *Server) RemoveCourseRosterStudents(ur updateCourseRoster) E {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		c := r.Context().Value(courseContextKey).(*Course)
+		l := s.getCtxLogger(r)
+
+		var students []string
+		err := json.NewDecoder(r.Body).Decode(&students)
+		if err != nil {
+			l.Warnw("failed to decode students from body", "err", err)
+			return StatusError{
+				http.StatusBadRequest,
+				"I couldn't decode the body. Are you sure it's a JSON array of emails (strings)? This error might help: " + err.Error(),
+			}
+		}
+
+		existing, err := ur.GetCourseRoster(r.Context(), c.ID)
+		if err != nil {
+			l.Errorw("failed to get course roster", "err", err)
+			return err
+		}
+
+		remove := make(map[string]bool, len(students))
+		for _, email := range students {
+			remove[strings.ToLower(strings.TrimSpace(email))] = true
+		}
+
+		removed := make([]*RosterStudent, 0)
+		var emails []string
+		for _, student := range existing {
+			if remove[student.Email] {
+				removed = append(removed, student)
+				emails = append(emails, student.Email)
+			}
+		}
+
+		err = ur.RemoveCourseRosterStudents(r.Context(), c.ID, emails)
+		if err != nil {
+			l.Errorw("failed to remove students from course roster", "err", err)
+			return err
+		}
+
+		if err := s.audit(r, AuditRemoveRosterStudents, c.ID.String(), removed, nil); err != nil {
+			return err
+		}
+
+		l.Infow("removed students from roster", "students", emails)
+		return s.sendResponse(http.StatusNoContent, nil, w, r)
+	}
+}
@@ -7,13 +7,11 @@ import (
 	"time"
 
 	"github.com/antonlindstrom/pgstore"
-	"github.com/coreos/go-oidc/v3/oidc"
 	"github.com/cskr/pubsub"
 	"github.com/go-chi/chi/v5"
 	"github.com/gorilla/sessions"
 	"github.com/segmentio/ksuid"
 	"go.uber.org/zap"
-	"golang.org/x/oauth2"
 
 	"github.com/CarsonHoffman/office-hours-queue/server/config"
 )
@@ -21,11 +19,10 @@ import (
 type Server struct {
 	chi.Router
 
-	logger       *zap.SugaredLogger
-	sessions     *pgstore.PGStore
-	ps           *pubsub.PubSub
-	oauthConfig  oauth2.Config
-	oidcProvider *oidc.Provider
+	logger        *zap.SugaredLogger
+	sessions      *pgstore.PGStore
+	ps            *pubsub.PubSub
+	oidcProviders []*OIDCProvider
 
 	// The number of WebSockets connected to each queue.
 	websocketCount        map[ksuid.KSUID]int
@@ -38,6 +35,8 @@ type Server struct {
 type queueStore interface {
 	transactioner
 
+	addAuditRecord
+
 	siteAdmin
 	courseAdmin
 	getUserInfo
@@ -48,15 +47,23 @@ type queueStore interface {
 	addCourse
 	updateCourse
 	deleteCourse
+	archiveCourse
+	restoreCourse
+	cloneCourse
 	getCourseAdmins
 	addCourseAdmins
 	removeCourseAdmins
+	getCourseGroups
+	updateCourseGroups
+	getAuditLog
 
 	getQueues
 	getQueue
 	addQueue
 	updateQueue
 	removeQueue
+	archiveQueue
+	restoreQueue
 	getQueueEntry
 	getQueueEntries
 	addQueueEntry
@@ -77,9 +84,30 @@ type queueStore interface {
 	updateQueueConfiguration
 	updateQueueOpenStatus
 	getQueueRoster
+	getCourseRoster
+	updateCourseRoster
+	addUserSession
+	backfillUserSessions
+	getUserSessions
+	removeUserSession
+	removeUserSessions
+	purgeExpiredSessions
+	useAPIToken
+	getAPITokens
+	addAPIToken
+	removeAPIToken
+	getSiteAdmins
+	addSiteAdmins
+	removeSiteAdmins
+	getCourseOverviews
+	getCourseGuests
+	addCourseGuests
+	removeCourseGuests
 	getQueueGroups
 	updateQueueGroups
 	setNotHelped
+	setEntryNote
+	getStaffNotes
 	queueStats
 
 	getAppointment
@@ -89,14 +117,34 @@ type queueStore interface {
 	getAppointmentSchedule
 	getAppointmentScheduleForDay
 	updateAppointmentSchedule
+	getAppointmentScheduleForDate
+	appointmentScheduleOverride
 	claimTimeslot
 	unclaimAppointment
+	claimTimeslots
+	unclaimTimeslots
+	setAppointmentOutcome
 	signupForAppointment
 	updateAppointment
 	removeAppointmentSignup
+	rescheduleAppointment
+	setAppointmentNote
+	getStaffAvailability
+	getAppointmentTypes
+	getAppointmentType
+	addAppointmentType
+	updateAppointmentType
+	removeAppointmentType
+	getWaitlistForUser
+	joinWaitlist
+	leaveWaitlist
+
+	syncTermQueues
+	getLastCallQueues
+	markAppointmentReminders
 }
 
-func New(q queueStore, logger *zap.SugaredLogger, sessionsStore *sql.DB, oidcProvider *oidc.Provider, oauthConfig oauth2.Config) *Server {
+func New(q queueStore, logger *zap.SugaredLogger, sessionsStore *sql.DB, oidcProviders []*OIDCProvider) *Server {
 	var s Server
 	s.websocketCount = make(map[ksuid.KSUID]int)
 	s.websocketCountByEmail = make(map[ksuid.KSUID]map[string]int)
@@ -122,15 +170,14 @@ func New(q queueStore, logger *zap.SugaredLogger, sessionsStore *sql.DB, oidcPro
 	// Just a guess.
 	s.ps = pubsub.New(5)
 
-	s.oauthConfig = oauthConfig
-	s.oidcProvider = oidcProvider
+	s.oidcProviders = oidcProviders
 
 	s.Router = chi.NewRouter()
-	s.Router.Use(instrumenter, ksuidInserter, s.realIPOrFail, s.setupCtxLogger, s.recoverMiddleware, s.transaction(q), s.sessionRetriever)
+	s.Router.Use(instrumenter, ksuidInserter, s.realIPOrFail, s.setupCtxLogger, s.recoverMiddleware, s.transaction(q), s.sessionRetriever(q))
 
 	// Course endpoints
 	s.Route("/courses", func(r chi.Router) {
-		// Get all courses
+		// Get all courses (optionally filtered by term)
 		r.Method("GET", "/", s.GetCourses(q))
 
 		// Create course (site admin)
@@ -138,37 +185,93 @@ func New(q queueStore, logger *zap.SugaredLogger, sessionsStore *sql.DB, oidcPro
 
 		// Course by ID endpoints
 		r.Route("/{id:[a-zA-Z0-9]{27}}", func(r chi.Router) {
-			r.Use(s.CourseIDMiddleware(q))
+			r.Use(s.CourseIDMiddleware(q), s.CheckCourseAdmin(q))
+
+			// Archive course (manage course)
+			r.With(s.ValidLoginMiddleware, s.EnsureCoursePermission(PermissionManageCourse)).Method("PUT", "/archive", s.ArchiveCourse(q))
+
+			// Restore archived course (manage course)
+			r.With(s.ValidLoginMiddleware, s.EnsureCoursePermission(PermissionManageCourse)).Method("DELETE", "/archive", s.RestoreCourse(q))
+
+			// Permanently delete course and its history (site admin)
+			r.With(s.ValidLoginMiddleware, s.EnsureSiteAdmin(q, true)).Method("DELETE", "/", s.DeleteCourse(q))
+
+			// Copy course for a new term (site admin)
+			r.With(s.ValidLoginMiddleware, s.EnsureSiteAdmin(q, true), s.rateLimiter(5, time.Minute)).Method("POST", "/clone", s.CloneCourse(q))
+
+			// Everything else is hidden from students and read-only once the
+			// course is archived
+			r.Group(func(r chi.Router) {
+				r.Use(s.ArchivedMiddleware)
 
-			// Get course by ID
-			r.Method("GET", "/", s.GetCourse(q))
+				// Get course by ID
+				r.Method("GET", "/", s.GetCourse(q))
 
-			// Get course's queues
-			r.Method("GET", "/queues", s.GetQueues(q))
+				// Get course's queues
+				r.Method("GET", "/queues", s.GetQueues(q))
 
-			// Update course (course admin)
-			r.With(s.ValidLoginMiddleware, s.CheckCourseAdmin(q), s.EnsureCourseAdmin).Method("PUT", "/", s.UpdateCourse(q))
+				// Update course (manage course)
+				r.With(s.ValidLoginMiddleware, s.EnsureCoursePermission(PermissionManageCourse)).Method("PUT", "/", s.UpdateCourse(q))
 
-			r.With(s.ValidLoginMiddleware, s.CheckCourseAdmin(q), s.EnsureCourseAdmin).Method("DELETE", "/", s.DeleteCourse(q))
+				// Create queue on course (manage course)
+				r.With(s.ValidLoginMiddleware, s.EnsureCoursePermission(PermissionManageCourse), s.rateLimiter(5, time.Minute)).Method("POST", "/queues", s.AddQueue(q))
 
-			// Create queue on course (course admin)
-			r.With(s.ValidLoginMiddleware, s.CheckCourseAdmin(q), s.EnsureCourseAdmin, s.rateLimiter(5, time.Minute)).Method("POST", "/queues", s.AddQueue(q))
+				// Get course's audit log (course admin)
+				r.With(s.ValidLoginMiddleware, s.EnsureCourseAdmin).Method("GET", "/audit", s.GetAuditLog(q))
+
+				// Course guest list management (course admin)
+				r.Route("/guests", func(r chi.Router) {
+					r.Use(s.ValidLoginMiddleware, s.EnsureCourseAdmin)
+
+					// Get emails outside the valid domains allowed into the course (course admin)
+					r.Method("GET", "/", s.GetCourseGuests(q))
+
+					// Add course guests (course admin)
+					r.Method("POST", "/", s.AddCourseGuests(q))
+
+					// Remove course guests (course admin)
+					r.Method("DELETE", "/", s.RemoveCourseGuests(q))
+				})
 
-			// Course admin management (course admin)
-			r.Route("/admins", func(r chi.Router) {
-				r.Use(s.ValidLoginMiddleware, s.CheckCourseAdmin(q), s.EnsureCourseAdmin)
+				// Course roster management (course admin)
+				r.Route("/roster", func(r chi.Router) {
+					r.Use(s.ValidLoginMiddleware, s.EnsureCourseAdmin)
 
-				// Get course admins (course admin)
-				r.Method("GET", "/", s.GetCourseAdmins(q))
+					// Get course roster (course admin)
+					r.Method("GET", "/", s.GetCourseRoster(q))
 
-				// Add course admins (course admin)
-				r.Method("POST", "/", s.AddCourseAdmins(q))
+					// Add students to course roster from CSV, optionally as a dry run (manage queues)
+					r.With(s.EnsureCoursePermission(PermissionManageQueues)).Method("POST", "/", s.AddCourseRoster(q))
 
-				// Overwrite course admins (course admin)
-				r.Method("PUT", "/", s.UpdateCourseAdmins(q))
+					// Overwrite course roster from CSV, optionally as a dry run (manage queues)
+					r.With(s.EnsureCoursePermission(PermissionManageQueues)).Method("PUT", "/", s.UpdateCourseRoster(q))
 
-				// Remove course admins (course admin)
-				r.Method("DELETE", "/", s.RemoveCourseAdmins(q))
+					// Remove students from course roster (manage queues)
+					r.With(s.EnsureCoursePermission(PermissionManageQueues)).Method("DELETE", "/", s.RemoveCourseRosterStudents(q))
+				})
+
+				// Course admin management (course admin)
+				r.Route("/admins", func(r chi.Router) {
+					r.Use(s.ValidLoginMiddleware, s.EnsureCourseAdmin)
+
+					// Get course admins (course admin)
+					r.Method("GET", "/", s.GetCourseAdmins(q))
+
+					// Add course admins or change their roles (manage staff)
+					r.With(s.EnsureCoursePermission(PermissionManageStaff)).Method("POST", "/", s.AddCourseAdmins(q))
+
+					// Overwrite course admins (manage staff)
+					r.With(s.EnsureCoursePermission(PermissionManageStaff)).Method("PUT", "/", s.UpdateCourseAdmins(q))
+
+					// Remove course admins (manage staff)
+					r.With(s.EnsureCoursePermission(PermissionManageStaff)).Method("DELETE", "/", s.RemoveCourseAdmins(q))
+
+					// Get OIDC groups whose members are course admins (course admin)
+					r.Method("GET", "/groups", s.GetCourseGroups(q))
+
+					// Overwrite OIDC groups whose members are course admins (manage staff)
+					r.With(s.EnsureCoursePermission(PermissionManageStaff)).Method("PUT", "/groups", s.UpdateCourseGroups(q))
+				})
 			})
 		})
 	})
@@ -177,169 +280,316 @@ func New(q queueStore, logger *zap.SugaredLogger, sessionsStore *sql.DB, oidcPro
 	s.Route("/queues/{id:[a-zA-Z0-9]{27}}", func(r chi.Router) {
 		r.Use(s.QueueIDMiddleware(q), s.CheckCourseAdmin(q))
 
-		// Get queue by ID (more information with queue admin)
-		r.Method("GET", "/", s.GetQueue(q))
+		// Archive queue (manage course)
+		r.With(s.ValidLoginMiddleware, s.EnsureCoursePermission(PermissionManageCourse)).Method("PUT", "/archive", s.ArchiveQueue(q))
 
-		r.Method("GET", "/ws", s.QueueWebsocket())
+		// Restore archived queue (manage course)
+		r.With(s.ValidLoginMiddleware, s.EnsureCoursePermission(PermissionManageCourse)).Method("DELETE", "/archive", s.RestoreQueue(q))
 
-		r.With(s.ValidLoginMiddleware, s.EnsureCourseAdmin).Method("PUT", "/", s.UpdateQueue(q))
+		// Permanently delete queue and its history (site admin)
+		r.With(s.ValidLoginMiddleware, s.EnsureSiteAdmin(q, true)).Method("DELETE", "/", s.RemoveQueue(q))
 
-		r.With(s.ValidLoginMiddleware, s.EnsureCourseAdmin).Method("DELETE", "/", s.RemoveQueue(q))
+		// Everything else is hidden from students and read-only once the
+		// queue is archived
+		r.Group(func(r chi.Router) {
+			r.Use(s.ArchivedMiddleware)
 
-		// Get queue's stack (queue admin)
-		r.With(s.ValidLoginMiddleware, s.EnsureCourseAdmin).Method("GET", "/stack", s.GetQueueStack(q))
+			// Get queue by ID (more information with queue admin)
+			r.Method("GET", "/", s.GetQueue(q))
 
-		// Entry by ID endpoints
-		r.Route("/entries", func(r chi.Router) {
-			r.Use(s.ValidLoginMiddleware)
+			r.Method("GET", "/ws", s.QueueWebsocket())
 
-			// Add queue entry (valid login)
-			// Rate limited to 30 requests per 15 minutes for a user to prevent abuse.
-			r.With(s.rateLimiter(30, 15*time.Minute)).Method("POST", "/", s.AddQueueEntry(q))
+			// Update queue (manage queues)
+			r.With(s.ValidLoginMiddleware, s.EnsureCoursePermission(PermissionManageQueues)).Method("PUT", "/", s.UpdateQueue(q))
 
-			// Update queue entry (valid login, same user as creator)
-			r.Method("PUT", "/{entry_id:[a-zA-Z0-9]{27}}", s.UpdateQueueEntry(q))
+			// Get queue's stack (queue admin)
+			r.With(s.ValidLoginMiddleware, s.EnsureCourseAdmin).Method("GET", "/stack", s.GetQueueStack(q))
 
-			// Remove queue entry (valid login, same user or queue admin)
-			r.Method("DELETE", "/{entry_id:[a-zA-Z0-9]{27}}", s.RemoveQueueEntry(q))
+			// Entry by ID endpoints
+			r.Route("/entries", func(r chi.Router) {
+				r.Use(s.ValidLoginMiddleware)
 
-			// Pin queue entry (course admin)
-			r.With(s.EnsureCourseAdmin).Method("POST", "/{entry_id:[a-zA-Z0-9]{27}}/pin", s.PinQueueEntry(q))
+				// Add queue entry (valid login)
+				// Rate limited to 30 requests per 15 minutes for a user to prevent abuse.
+				r.With(s.rateLimiter(30, 15*time.Minute)).Method("POST", "/", s.AddQueueEntry(q))
 
-			// Set queue entry helped state (course admin)
-			r.With(s.EnsureCourseAdmin).Method("PUT", "/{entry_id:[a-zA-Z0-9]{27}}/helping", s.SetQueueEntryHelping(q))
+				// Update queue entry (valid login, same user as creator)
+				r.Method("PUT", "/{entry_id:[a-zA-Z0-9]{27}}", s.UpdateQueueEntry(q))
 
-			// Set student not helped (queue admin)
-			r.With(s.EnsureCourseAdmin).Method("DELETE", "/{entry_id:[a-zA-Z0-9]{27}}/helped", s.SetNotHelped(q))
+				// Remove queue entry (valid login, same user or queue admin)
+				r.Method("DELETE", "/{entry_id:[a-zA-Z0-9]{27}}", s.RemoveQueueEntry(q))
 
-			// Randomize queue (course admin)
-			r.With(s.ValidLoginMiddleware, s.EnsureCourseAdmin).Method("POST", "/randomize", s.RandomizeQueueEntries(q))
+				// Pin queue entry (course admin)
+				r.With(s.EnsureCourseAdmin).Method("POST", "/{entry_id:[a-zA-Z0-9]{27}}/pin", s.PinQueueEntry(q))
 
-			// Clear queue (queue admin)
-			r.With(s.EnsureCourseAdmin).Method("DELETE", "/", s.ClearQueueEntries(q))
-		})
+				// Set queue entry helped state (course admin)
+				r.With(s.EnsureCourseAdmin).Method("PUT", "/{entry_id:[a-zA-Z0-9]{27}}/helping", s.SetQueueEntryHelping(q))
 
-		// Announcements endpoints
-		r.Route("/announcements", func(r chi.Router) {
-			r.Use(s.ValidLoginMiddleware, s.EnsureCourseAdmin)
+				// Set student not helped (queue admin)
+				r.With(s.EnsureCourseAdmin).Method("DELETE", "/{entry_id:[a-zA-Z0-9]{27}}/helped", s.SetNotHelped(q))
 
-			// Create announcement (queue admin)
-			r.Method("POST", "/", s.AddQueueAnnouncement(q))
+				// Leave staff notes on removed entry (queue admin)
+				r.With(s.EnsureCourseAdmin).Method("PUT", "/{entry_id:[a-zA-Z0-9]{27}}/notes", s.SetEntryNote(q))
 
-			// Remove announcement (queue admin)
-			r.Method("DELETE", "/{announcement_id:[a-zA-Z0-9]{27}}", s.RemoveQueueAnnouncement(q))
-		})
+				// Randomize queue (manage queues)
+				r.With(s.ValidLoginMiddleware, s.EnsureCoursePermission(PermissionManageQueues)).Method("POST", "/randomize", s.RandomizeQueueEntries(q))
 
-		// Queue-wide (all days) schedule endpoints
-		r.Route("/schedule", func(r chi.Router) {
-			// Get queue schedule
-			r.Method("GET", "/", s.GetQueueSchedule(q))
+				// Clear queue (manage queues)
+				r.With(s.EnsureCoursePermission(PermissionManageQueues)).Method("DELETE", "/", s.ClearQueueEntries(q))
+			})
 
-			// Update queue schedule (queue admin)
-			r.With(s.ValidLoginMiddleware, s.EnsureCourseAdmin).Method("PUT", "/", s.UpdateQueueSchedule(q))
-		})
+			// Announcements endpoints
+			r.Route("/announcements", func(r chi.Router) {
+				r.Use(s.ValidLoginMiddleware, s.EnsureCourseAdmin)
 
-		// Queue configuration endpoints
-		r.Route("/configuration", func(r chi.Router) {
-			// Get queue configuration
-			r.Method("GET", "/", s.GetQueueConfiguration(q))
+				// Create announcement (queue admin)
+				r.Method("POST", "/", s.AddQueueAnnouncement(q))
 
-			// Update queue configuration (queue admin)
-			r.With(s.ValidLoginMiddleware, s.EnsureCourseAdmin).Method("PUT", "/", s.UpdateQueueConfiguration(q))
+				// Remove announcement (queue admin)
+				r.Method("DELETE", "/{announcement_id:[a-zA-Z0-9]{27}}", s.RemoveQueueAnnouncement(q))
+			})
 
-			// Set manual queue open status (queue admin)
-			r.With(s.ValidLoginMiddleware, s.EnsureCourseAdmin).Method("PUT", "/manual-open", s.UpdateQueueOpenStatus(q))
-		})
+			// Queue-wide (all days) schedule endpoints
+			r.Route("/schedule", func(r chi.Router) {
+				// Get queue schedule
+				r.Method("GET", "/", s.GetQueueSchedule(q))
 
-		// Send message (queue admin)
-		r.With(s.ValidLoginMiddleware, s.EnsureCourseAdmin).Method("POST", "/messages", s.SendMessage())
+				// Update queue schedule (manage queues)
+				r.With(s.ValidLoginMiddleware, s.EnsureCoursePermission(PermissionManageQueues)).Method("PUT", "/", s.UpdateQueueSchedule(q))
 
-		// Get queue roster (queue admin)
-		r.With(s.ValidLoginMiddleware, s.EnsureCourseAdmin).Method("GET", "/roster", s.GetQueueRoster(q))
+				// Export queue and appointment schedules as CSV (queue admin)
+				r.With(s.ValidLoginMiddleware, s.EnsureCourseAdmin).Method("GET", "/csv", s.ExportSchedules(q))
 
-		// Queue groups endpoints
-		r.Route("/groups", func(r chi.Router) {
-			r.Use(s.ValidLoginMiddleware, s.EnsureCourseAdmin)
+				// Import queue and appointment schedules from CSV (manage queues)
+				r.With(s.ValidLoginMiddleware, s.EnsureCoursePermission(PermissionManageQueues)).Method("PUT", "/csv", s.ImportSchedules(q))
+			})
 
-			// Get queue groups (queue admin)
-			r.Method("GET", "/", s.GetQueueGroups(q))
+			// Queue configuration endpoints
+			r.Route("/configuration", func(r chi.Router) {
+				// Get queue configuration
+				r.Method("GET", "/", s.GetQueueConfiguration(q))
 
-			// Update queue groups (queue admin)
-			r.Method("PUT", "/", s.UpdateQueueGroups(q))
-		})
+				// Update queue configuration (manage queues)
+				r.With(s.ValidLoginMiddleware, s.EnsureCoursePermission(PermissionManageQueues)).Method("PUT", "/", s.UpdateQueueConfiguration(q))
 
-		// Appointments endpoints
-		r.Route("/appointments", func(r chi.Router) {
-			// Specific day endpoints
-			r.Route(`/{day:\d+}`, func(r chi.Router) {
-				r.Use(s.AppointmentDayMiddleware)
+				// Set manual queue open status (queue admin)
+				r.With(s.ValidLoginMiddleware, s.EnsureCourseAdmin).Method("PUT", "/manual-open", s.UpdateQueueOpenStatus(q))
+			})
 
-				// Get endpoints on day (more information with queue admin)
-				r.Method("GET", "/", s.GetAppointments(q))
+			// Send message (queue admin)
+			r.With(s.ValidLoginMiddleware, s.EnsureCourseAdmin).Method("POST", "/messages", s.SendMessage())
 
-				// Get appointments for current user on day
-				r.With(s.ValidLoginMiddleware).Method("GET", "/@me", s.GetAppointmentsForCurrentUser(q))
+			// Get queue roster (queue admin)
+			r.With(s.ValidLoginMiddleware, s.EnsureCourseAdmin).Method("GET", "/roster", s.GetQueueRoster(q))
 
-				// Create appointment on day at timeslot
-				r.With(s.ValidLoginMiddleware, s.rateLimiter(30, 15*time.Minute), s.AppointmentTimeslotMiddleware).Method("POST", `/{timeslot:\d+}`, s.SignupForAppointment(q))
+			// Queue groups endpoints
+			r.Route("/groups", func(r chi.Router) {
+				r.Use(s.ValidLoginMiddleware, s.EnsureCourseAdmin)
 
-				// Appointment claiming (queue admin)
-				r.Route(`/claims/{timeslot:\d+}`, func(r chi.Router) {
-					r.Use(s.ValidLoginMiddleware, s.EnsureCourseAdmin, s.AppointmentTimeslotMiddleware)
+				// Get queue groups (queue admin)
+				r.Method("GET", "/", s.GetQueueGroups(q))
 
-					// Claim appointment on day at timeslot (queue admin)
-					r.Method("PUT", "/", s.ClaimTimeslot(q))
-				})
+				// Update queue groups (manage queues)
+				r.With(s.EnsureCoursePermission(PermissionManageQueues)).Method("PUT", "/", s.UpdateQueueGroups(q))
 			})
 
-			// Existing appointment claims by ID (queue admin)
-			r.Route(`/claims/{appointment_id:[a-zA-Z0-9]{27}}`, func(r chi.Router) {
-				r.Use(s.ValidLoginMiddleware, s.EnsureCourseAdmin, s.AppointmentIDMiddleware(q))
+			// Appointments endpoints
+			r.Route("/appointments", func(r chi.Router) {
+				// Endpoints on a single day, addressed either by weekday (the
+				// next occurrence of it) or by date
+				appointmentDay := func(r chi.Router) {
+					// Get endpoints on day (more information with queue admin)
+					r.Method("GET", "/", s.GetAppointments(q))
 
-				// Un-claim appointment (queue admin)
-				r.Method("DELETE", "/", s.UnclaimAppointment(q))
-			})
+					// Get appointments for current user on day
+					r.With(s.ValidLoginMiddleware).Method("GET", "/@me", s.GetAppointmentsForCurrentUser(q))
 
-			// Appointment by ID endpoints
-			r.Route(`/{appointment_id:[a-zA-Z0-9]{27}}`, func(r chi.Router) {
-				r.Use(s.ValidLoginMiddleware, s.AppointmentIDMiddleware(q))
+					// Create appointment on day at timeslot
+					r.With(s.ValidLoginMiddleware, s.rateLimiter(30, 15*time.Minute), s.AppointmentTimeslotMiddleware).Method("POST", `/{timeslot:\d+}`, s.SignupForAppointment(q))
 
-				// Update appointment (valid login, same user as creator)
-				r.Method("PUT", "/", s.UpdateAppointment(q))
+					// Get open appointments claimed by each staff member on day
+					r.With(s.ValidLoginMiddleware).Method("GET", "/staff", s.GetStaffAvailability(q))
 
-				// Cancel appointment (valid login, same user as creator)
-				r.Method("DELETE", "/", s.RemoveAppointmentSignup(q))
-			})
+					// Get waitlist entries for current user on day
+					r.With(s.ValidLoginMiddleware).Method("GET", "/@me/waitlist", s.GetWaitlistForCurrentUser(q))
 
-			// Appointment schedule endpoints
-			r.Route("/schedule", func(r chi.Router) {
-				// Get appointment schedule for all days
-				r.Method("GET", "/", s.GetAppointmentSchedule(q))
+					// Join or leave the waitlist for a full timeslot on day
+					r.Route(`/{timeslot:\d+}/waitlist`, func(r chi.Router) {
+						r.Use(s.ValidLoginMiddleware, s.AppointmentTimeslotMiddleware)
+
+						r.With(s.rateLimiter(30, 15*time.Minute)).Method("POST", "/", s.JoinWaitlist(q))
+						r.Method("DELETE", "/", s.LeaveWaitlist(q))
+					})
+
+					// Claim or unclaim a range of timeslots on day (queue admin)
+					r.With(s.ValidLoginMiddleware, s.EnsureCourseAdmin).Method("PUT", "/claims", s.ClaimTimeslots(q))
+					r.With(s.ValidLoginMiddleware, s.EnsureCourseAdmin).Method("DELETE", "/claims", s.UnclaimTimeslots(q))
+
+					// Appointment claiming (queue admin)
+					r.Route(`/claims/{timeslot:\d+}`, func(r chi.Router) {
+						r.Use(s.ValidLoginMiddleware, s.EnsureCourseAdmin, s.AppointmentTimeslotMiddleware)
+
+						// Claim appointment on day at timeslot (queue admin)
+						r.Method("PUT", "/", s.ClaimTimeslot(q))
+					})
+				}
+
+				// Specific weekday endpoints
+				r.With(s.AppointmentDayMiddleware).Route(`/{day:\d+}`, appointmentDay)
+
+				// Specific date endpoints
+				r.With(s.AppointmentDateMiddleware).Route(`/{date:\d{4}-\d{2}-\d{2}}`, appointmentDay)
+
+				// Existing appointment claims by ID (queue admin)
+				r.Route(`/claims/{appointment_id:[a-zA-Z0-9]{27}}`, func(r chi.Router) {
+					r.Use(s.ValidLoginMiddleware, s.EnsureCourseAdmin, s.AppointmentIDMiddleware(q))
 
-				// Per-day schedules
-				r.Route(`/{day:\d+}`, func(r chi.Router) {
-					r.Use(s.AppointmentDayMiddleware)
+					// Un-claim appointment (queue admin)
+					r.Method("DELETE", "/", s.UnclaimAppointment(q))
 
-					// Get appointment schedule for day
-					r.Method("GET", "/", s.GetAppointmentScheduleForDay(q))
+					// Record how the appointment went (queue admin, same user as claimer)
+					r.Method("PUT", "/outcome", s.SetAppointmentOutcome(q))
+				})
+
+				// Appointment by ID endpoints
+				r.Route(`/{appointment_id:[a-zA-Z0-9]{27}}`, func(r chi.Router) {
+					r.Use(s.ValidLoginMiddleware, s.AppointmentIDMiddleware(q))
+
+					// Update appointment (valid login, same user as creator)
+					r.Method("PUT", "/", s.UpdateAppointment(q))
+
+					// Cancel appointment (valid login, same user as creator)
+					r.Method("DELETE", "/", s.RemoveAppointmentSignup(q))
+
+					// Move appointment to another date and timeslot (valid login, same user as creator)
+					r.Method("PUT", "/reschedule", s.RescheduleAppointment(q))
+
+					// Leave staff notes on past appointment (queue admin)
+					r.With(s.EnsureCourseAdmin).Method("PUT", "/notes", s.SetAppointmentNote(q))
+				})
+
+				// Appointment types
+				r.Route("/types", func(r chi.Router) {
+					// Get appointment types
+					r.Method("GET", "/", s.GetAppointmentTypes(q))
+
+					// Add appointment type (manage queues)
+					r.With(s.ValidLoginMiddleware, s.EnsureCoursePermission(PermissionManageQueues)).Method("POST", "/", s.AddAppointmentType(q))
+
+					r.Route(`/{type_id:[a-zA-Z0-9]{27}}`, func(r chi.Router) {
+						r.Use(s.ValidLoginMiddleware, s.EnsureCoursePermission(PermissionManageQueues), s.AppointmentTypeMiddleware(q))
+
+						// Update appointment type (manage queues)
+						r.Method("PUT", "/", s.UpdateAppointmentType(q))
 
-					// Update appointment schedule for day (queue admin)
-					r.With(s.ValidLoginMiddleware, s.EnsureCourseAdmin).Method("PUT", "/", s.UpdateAppointmentSchedule(q))
+						// Remove appointment type (manage queues)
+						r.Method("DELETE", "/", s.RemoveAppointmentType(q))
+					})
+				})
+
+				// Appointment schedule endpoints
+				r.Route("/schedule", func(r chi.Router) {
+					// Get appointment schedule for all days
+					r.Method("GET", "/", s.GetAppointmentSchedule(q))
+
+					// Per-day schedules
+					r.Route(`/{day:\d+}`, func(r chi.Router) {
+						r.Use(s.AppointmentDayMiddleware)
+
+						// Get appointment schedule for day
+						r.Method("GET", "/", s.GetAppointmentScheduleForDay(q))
+
+						// Update appointment schedule for day (manage queues)
+						r.With(s.ValidLoginMiddleware, s.EnsureCoursePermission(PermissionManageQueues)).Method("PUT", "/", s.UpdateAppointmentSchedule(q))
+					})
+
+					// Per-date schedules, which override the weekly schedule
+					r.Route(`/{date:\d{4}-\d{2}-\d{2}}`, func(r chi.Router) {
+						r.Use(s.AppointmentDateMiddleware)
+
+						// Get appointment schedule in effect on date
+						r.Method("GET", "/", s.GetAppointmentScheduleForDate(q))
+
+						// Override appointment schedule on date (manage queues)
+						r.With(s.ValidLoginMiddleware, s.EnsureCoursePermission(PermissionManageQueues)).Method("PUT", "/", s.UpdateAppointmentScheduleForDate(q))
+
+						// Go back to the weekly schedule on date (manage queues)
+						r.With(s.ValidLoginMiddleware, s.EnsureCoursePermission(PermissionManageQueues)).Method("DELETE", "/", s.RemoveAppointmentScheduleForDate(q))
+					})
 				})
 			})
 		})
 	})
 
-	s.Method("GET", "/oauth2login", s.OAuth2LoginLink())
+	// Site administration endpoints (site admin)
+	s.Route("/admin", func(r chi.Router) {
+		r.Use(s.ValidLoginMiddleware, s.EnsureSiteAdmin(q, true))
+
+		// Log a user out of all of their sessions (site admin)
+		r.Method("DELETE", "/sessions", s.RevokeUserSessions(q))
+
+		// View the queue as another user (site admin)
+		r.With(s.RejectAPITokens).Method("POST", "/impersonation", s.StartImpersonation())
+
+		// Get every course with its queues and staff (site admin)
+		r.Method("GET", "/courses", s.GetCourseOverviews(q))
+
+		r.Route("/site-admins", func(r chi.Router) {
+			// Get site admins (site admin)
+			r.Method("GET", "/", s.GetSiteAdmins(q))
+
+			// Add site admins (site admin)
+			r.Method("POST", "/", s.AddSiteAdmins(q))
+
+			// Remove site admins (site admin)
+			r.Method("DELETE", "/", s.RemoveSiteAdmins(q))
+		})
+	})
+
+	s.Method("GET", "/oauth2providers", s.GetOAuth2Providers())
+
+	s.With(s.RejectImpersonation).Method("GET", "/oauth2login", s.OAuth2LoginLink())
 
 	// To not overwhelm our IdP with requests...
-	s.With(s.rateLimiter(15, 15*time.Minute)).Method("GET", "/oauth2callback", s.OAuth2Callback())
+	s.With(s.rateLimiter(15, 15*time.Minute), s.RejectImpersonation).Method("GET", "/oauth2callback", s.OAuth2Callback(q))
 
-	s.Method("GET", "/logout", s.Logout())
+	s.With(s.RejectImpersonation).Method("GET", "/logout", s.Logout())
 
 	s.With(s.ValidLoginMiddleware, s.EnsureSiteAdmin(q, false)).Method("GET", "/users/@am-site-admin", s.FowardAuth())
 
-	s.With(s.ValidLoginMiddleware).Method("GET", "/users/@me", s.GetCurrentUserInfo(q))
+	s.With(s.CheckGuest(q), s.ValidLoginMiddleware).Method("GET", "/users/@me", s.GetCurrentUserInfo(q))
+
+	// Login sessions, which can only be managed with the session itself
+	s.Route("/users/@me/sessions", func(r chi.Router) {
+		r.Use(s.CheckGuest(q), s.ValidLoginMiddleware, s.RejectAPITokens, s.RejectImpersonation)
+
+		// Get current user's sessions
+		r.Method("GET", "/", s.GetUserSessions(q))
+
+		// Log out of every other session
+		r.Method("DELETE", "/", s.RemoveOtherUserSessions(q))
+
+		// Log out of session
+		r.Method("DELETE", "/{session_id:[a-zA-Z0-9]{27}}", s.RemoveUserSession(q))
+	})
+
+	// Stop viewing the queue as another user. This can't require a valid
+	// login, since the impersonated user might not have one.
+	s.Method("DELETE", stopImpersonationPath, s.StopImpersonation())
+
+	// Personal API tokens, which can only be managed with a login session
+	s.Route("/users/@me/tokens", func(r chi.Router) {
+		r.Use(s.CheckGuest(q), s.ValidLoginMiddleware, s.RejectAPITokens, s.RejectImpersonation)
+
+		// Get current user's API tokens
+		r.Method("GET", "/", s.GetAPITokens(q))
+
+		// Create API token, optionally limited to a course
+		r.With(s.rateLimiter(5, time.Minute)).Method("POST", "/", s.AddAPIToken(q))
+
+		// Revoke API token
+		r.Method("DELETE", "/{token_id:[a-zA-Z0-9]{27}}", s.RemoveAPIToken(q))
+	})
 
 	s.Method("GET", "/metrics", s.MetricsHandler())
 
@@ -348,6 +598,7 @@ func New(q queueStore, logger *zap.SugaredLogger, sessionsStore *sql.DB, oidcPro
 	})
 
 	s.RegisterQueueStats(q)
+	s.startJobs(q)
 
 	return &s
 }
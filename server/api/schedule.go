@@ -0,0 +1,460 @@
+package api
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Weekly schedules can be exported to and imported from CSV, which is a
+// lot friendlier than editing 48-character strings by hand. Each row
+// covers a stretch of time on one day:
+//
+//	type,day,start,end,value,duration,padding
+//	queue,Monday,09:00,12:00,open,,
+//	appointments,Tuesday,13:00,15:00,2,15,2
+//
+// Queue rows take a value of open, early, or closed (or o, p, and c).
+// Appointment rows take the number of slots at each timeslot, along with
+// that day's appointment duration and padding in minutes. Any time not
+// covered by a row is closed.
+var scheduleCSVHeader = []string{"type", "day", "start", "end", "value", "duration", "padding"}
+
+const (
+	queueScheduleRow       = "queue"
+	appointmentScheduleRow = "appointments"
+
+	// The maximum size of an uploaded schedule CSV.
+	maxScheduleCSVSize = 1 << 20
+)
+
+var queueScheduleStates = map[string]byte{
+	"open":   'o',
+	"o":      'o',
+	"early":  'p',
+	"p":      'p',
+	"closed": 'c',
+	"c":      'c',
+}
+
+var queueScheduleStateNames = map[byte]string{
+	'o': "open",
+	'p': "early",
+	'c': "closed",
+}
+
+// CSVRowError describes a problem with one row of an imported CSV. A
+// Row of 0 refers to the file as a whole.
+type CSVRowError struct {
+	Row     int    `json:"row"`
+	Message string `json:"message"`
+}
+
+// parseScheduleDay accepts a weekday as its number (Sunday is 0), its
+// full name, or its three-letter abbreviation.
+func parseScheduleDay(s string) (int, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if day, err := strconv.Atoi(s); err == nil && day >= 0 && day < 7 {
+		return day, nil
+	}
+
+	for day := time.Sunday; day <= time.Saturday; day++ {
+		name := strings.ToLower(day.String())
+		if s == name || s == name[:3] {
+			return int(day), nil
+		}
+	}
+
+	return 0, fmt.Errorf("%q isn't a day of the week", s)
+}
+
+// parseScheduleTime parses a time of day in 24-hour HH:MM format into
+// minutes since midnight. 24:00 is allowed to mark the end of the day.
+func parseScheduleTime(s string) (int, error) {
+	s = strings.TrimSpace(s)
+	if s == "24:00" {
+		return 24 * 60, nil
+	}
+
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("%q isn't a time in HH:MM format", s)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+func formatScheduleTime(minutes int) string {
+	return fmt.Sprintf("%02d:%02d", minutes/60, minutes%60)
+}
+
+type scheduleRun struct {
+	start, end int
+	value      byte
+}
+
+// scheduleRuns splits a schedule string into runs of identical values.
+func scheduleRuns(schedule string) []scheduleRun {
+	var runs []scheduleRun
+	for i := 0; i < len(schedule); i++ {
+		if len(runs) > 0 && runs[len(runs)-1].value == schedule[i] {
+			runs[len(runs)-1].end = i + 1
+			continue
+		}
+		runs = append(runs, scheduleRun{start: i, end: i + 1, value: schedule[i]})
+	}
+	return runs
+}
+
+type exportSchedules interface {
+	getQueueSchedule
+	getAppointmentSchedule
+}
+
+func (s *Server) ExportSchedules(es exportSchedules) E {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		q := r.Context().Value(queueContextKey).(*Queue)
+		l := s.getCtxLogger(r)
+
+		schedules, err := es.GetQueueSchedule(r.Context(), q.ID)
+		if err != nil {
+			l.Errorw("failed to get queue schedule", "err", err)
+			return err
+		}
+
+		records := [][]string{scheduleCSVHeader}
+		for day, schedule := range schedules {
+			for _, run := range scheduleRuns(schedule) {
+				if run.value == 'c' {
+					continue
+				}
+
+				records = append(records, []string{
+					queueScheduleRow,
+					time.Weekday(day).String(),
+					formatScheduleTime(run.start * 30),
+					formatScheduleTime(run.end * 30),
+					queueScheduleStateNames[run.value],
+					"",
+					"",
+				})
+			}
+		}
+
+		if q.Type == Appointments {
+			appointmentSchedules, err := es.GetAppointmentSchedule(r.Context(), q.ID)
+			if err != nil {
+				l.Errorw("failed to get appointment schedule", "err", err)
+				return err
+			}
+
+			for _, schedule := range appointmentSchedules {
+				for _, run := range scheduleRuns(schedule.Schedule) {
+					if run.value == '0' {
+						continue
+					}
+
+					records = append(records, []string{
+						appointmentScheduleRow,
+						schedule.Day.String(),
+						formatScheduleTime(run.start * schedule.Duration),
+						formatScheduleTime(run.end * schedule.Duration),
+						string(run.value),
+						strconv.Itoa(schedule.Duration),
+						strconv.Itoa(schedule.Padding),
+					})
+				}
+			}
+		}
+
+		l.Infow("exported schedules", "rows", len(records)-1)
+
+		w.Header().Add("Content-Type", "text/csv")
+		w.Header().Add("Content-Disposition", `attachment; filename="schedule.csv"`)
+		w.WriteHeader(http.StatusOK)
+		return csv.NewWriter(w).WriteAll(records)
+	}
+}
+
+type importSchedules interface {
+	getAppointmentSchedule
+	updateQueueSchedule
+	updateAppointmentSchedule
+}
+
+// The schedules an import touched, as recorded in the audit log.
+type importedSchedules struct {
+	QueueSchedule        []string               `json:"queue_schedule,omitempty"`
+	AppointmentSchedules []*AppointmentSchedule `json:"appointment_schedules,omitempty"`
+}
+
+// An appointment schedule for one day being assembled from imported rows.
+type importedAppointmentDay struct {
+	duration, padding int
+	firstRow          int
+	slots             []byte
+}
+
+func (s *Server) ImportSchedules(is importSchedules) E {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		q := r.Context().Value(queueContextKey).(*Queue)
+		l := s.getCtxLogger(r)
+
+		reader := csv.NewReader(http.MaxBytesReader(w, r.Body, maxScheduleCSVSize))
+		reader.FieldsPerRecord = len(scheduleCSVHeader)
+		reader.TrimLeadingSpace = true
+		records, err := reader.ReadAll()
+		if err != nil {
+			l.Warnw("failed to read schedule CSV", "err", err)
+			return StatusError{
+				http.StatusBadRequest,
+				"I couldn't read the CSV you uploaded. This error might help: " + err.Error(),
+			}
+		}
+
+		if len(records) < 2 || !strings.EqualFold(strings.Join(records[0], ","), strings.Join(scheduleCSVHeader, ",")) {
+			l.Warnw("got schedule CSV without header or rows", "rows", len(records))
+			return StatusError{
+				http.StatusBadRequest,
+				"The CSV should start with the header " + strings.Join(scheduleCSVHeader, ",") + " and have at least one row after it.",
+			}
+		}
+
+		var rowErrors []CSVRowError
+		fail := func(row int, format string, args ...interface{}) {
+			rowErrors = append(rowErrors, CSVRowError{Row: row, Message: fmt.Sprintf(format, args...)})
+		}
+
+		// Which row set each half hour (or timeslot) so far, to catch overlaps.
+		var queueDays [7][]byte
+		var queueOwners [7][48]int
+		appointmentDays := make(map[int]*importedAppointmentDay)
+		var appointmentOwners [7][]int
+
+		for i, record := range records[1:] {
+			row := i + 2
+			kind := strings.ToLower(strings.TrimSpace(record[0]))
+			value := strings.ToLower(strings.TrimSpace(record[4]))
+
+			day, err := parseScheduleDay(record[1])
+			if err != nil {
+				fail(row, "%v", err)
+				continue
+			}
+
+			start, err := parseScheduleTime(record[2])
+			if err != nil {
+				fail(row, "%v", err)
+				continue
+			}
+
+			end, err := parseScheduleTime(record[3])
+			if err != nil {
+				fail(row, "%v", err)
+				continue
+			}
+
+			if end <= start {
+				fail(row, "the row has to end after it starts")
+				continue
+			}
+
+			switch kind {
+			case queueScheduleRow:
+				state, ok := queueScheduleStates[value]
+				if !ok {
+					fail(row, "%q isn't a queue state; use open, early, or closed", value)
+					continue
+				}
+
+				if start%30 != 0 || end%30 != 0 {
+					fail(row, "queue schedules go by half hours, so the row has to start and end on the hour or half hour")
+					continue
+				}
+
+				if queueDays[day] == nil {
+					queueDays[day] = []byte(defaultQueueSchedule)
+				}
+
+				for slot := start / 30; slot < end/30; slot++ {
+					if owner := queueOwners[day][slot]; owner != 0 {
+						fail(row, "the row overlaps with row %d", owner)
+						break
+					}
+					queueOwners[day][slot] = row
+					queueDays[day][slot] = state
+				}
+
+			case appointmentScheduleRow:
+				if q.Type != Appointments {
+					fail(row, "this queue doesn't take appointments")
+					continue
+				}
+
+				slots, err := strconv.Atoi(value)
+				if err != nil || slots < 0 || slots > 9 {
+					fail(row, "the number of slots has to be between 0 and 9")
+					continue
+				}
+
+				duration, err := strconv.Atoi(strings.TrimSpace(record[5]))
+				if err != nil || duration <= 0 || (24*60)%duration != 0 {
+					fail(row, "the appointment duration has to be a number of minutes that divides evenly into a day")
+					continue
+				}
+
+				padding, err := strconv.Atoi(strings.TrimSpace(record[6]))
+				if err != nil || padding < 0 || padding >= duration {
+					fail(row, "the padding has to be a number of minutes shorter than the appointment duration")
+					continue
+				}
+
+				d, ok := appointmentDays[day]
+				if !ok {
+					d = &importedAppointmentDay{
+						duration: duration,
+						padding:  padding,
+						firstRow: row,
+						slots:    []byte(strings.Repeat("0", 24*60/duration)),
+					}
+					appointmentDays[day] = d
+					appointmentOwners[day] = make([]int, len(d.slots))
+				} else if d.duration != duration || d.padding != padding {
+					fail(row, "every appointment row for %s has to use the same duration and padding as row %d", time.Weekday(day), d.firstRow)
+					continue
+				}
+
+				if start%duration != 0 || end%duration != 0 {
+					fail(row, "the row has to start and end on a %d-minute timeslot boundary", duration)
+					continue
+				}
+
+				for slot := start / duration; slot < end/duration; slot++ {
+					if owner := appointmentOwners[day][slot]; owner != 0 {
+						fail(row, "the row overlaps with row %d", owner)
+						break
+					}
+					appointmentOwners[day][slot] = row
+					d.slots[slot] = byte('0' + slots)
+				}
+
+			default:
+				fail(row, "%q isn't a schedule type; use queue or appointments", kind)
+			}
+		}
+
+		importQueue := false
+		queueSchedules := make([]string, 7)
+		for _, schedule := range queueDays {
+			if schedule != nil {
+				importQueue = true
+			}
+		}
+		if importQueue {
+			for day, schedule := range queueDays {
+				queueSchedules[day] = defaultQueueSchedule
+				if schedule != nil {
+					queueSchedules[day] = string(schedule)
+				}
+
+				if err := validateQueueSchedule(queueSchedules[day]); err != nil {
+					fail(0, "%s: %v", time.Weekday(day), err)
+				}
+			}
+		}
+
+		// Days left out of an appointment import are closed, but keep their
+		// current duration and padding.
+		var before, after importedSchedules
+		var appointmentSchedules []*AppointmentSchedule
+		if len(appointmentDays) > 0 && len(rowErrors) == 0 {
+			currentSchedules, err := is.GetAppointmentSchedule(r.Context(), q.ID)
+			if err != nil {
+				l.Errorw("failed to get appointment schedule", "err", err)
+				return err
+			}
+			before.AppointmentSchedules = currentSchedules
+
+			for _, current := range currentSchedules {
+				day := int(current.Day)
+				schedule := &AppointmentSchedule{
+					Queue:    q.ID,
+					Day:      current.Day,
+					Duration: current.Duration,
+					Padding:  current.Padding,
+					Schedule: strings.Repeat("0", 24*60/current.Duration),
+				}
+
+				row := 0
+				if d, ok := appointmentDays[day]; ok {
+					schedule.Duration = d.duration
+					schedule.Padding = d.padding
+					schedule.Schedule = string(d.slots)
+					row = d.firstRow
+				}
+
+				err = s.validateWeeklyAppointmentSchedule(r.Context(), l, is, q.ID, day, current, schedule)
+				var statusErr StatusError
+				if errors.As(err, &statusErr) {
+					fail(row, "%s: %s", current.Day, statusErr.message)
+					continue
+				} else if err != nil {
+					return err
+				}
+
+				appointmentSchedules = append(appointmentSchedules, schedule)
+			}
+		}
+
+		if len(rowErrors) > 0 {
+			l.Warnw("rejected schedule import", "errors", rowErrors)
+			return s.sendResponse(http.StatusBadRequest, struct {
+				Message string        `json:"message"`
+				Errors  []CSVRowError `json:"errors"`
+			}{
+				"Some rows of the schedule had problems, so nothing was imported.",
+				rowErrors,
+			}, w, r)
+		}
+
+		if importQueue {
+			before.QueueSchedule, err = is.GetQueueSchedule(r.Context(), q.ID)
+			if err != nil {
+				l.Errorw("failed to get queue schedule", "err", err)
+				return err
+			}
+
+			err = is.UpdateQueueSchedule(r.Context(), q.ID, queueSchedules)
+			if err != nil {
+				l.Errorw("failed to update queue schedule", "err", err)
+				return err
+			}
+			after.QueueSchedule = queueSchedules
+		}
+
+		for _, schedule := range appointmentSchedules {
+			err = is.UpdateAppointmentSchedule(r.Context(), q.ID, int(schedule.Day), schedule)
+			if err != nil {
+				l.Errorw("failed to update appointment schedule", "day", schedule.Day, "err", err)
+				return err
+			}
+		}
+
+		after.AppointmentSchedules = appointmentSchedules
+		if err := s.audit(r, AuditImportSchedules, q.ID.String(), before, after); err != nil {
+			return err
+		}
+
+		l.Infow("imported schedules",
+			"queue_schedule", importQueue,
+			"appointment_days", len(appointmentSchedules),
+		)
+
+		s.ps.Pub(WS("REFRESH", nil), QueueTopicGeneric(q.ID))
+
+		return s.sendResponse(http.StatusNoContent, nil, w, r)
+	}
+}
@@ -0,0 +1,220 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/gorilla/securecookie"
+	"github.com/segmentio/ksuid"
+	"go.uber.org/zap"
+)
+
+type addUserSession interface {
+	AddUserSession(ctx context.Context, email, key, ip, userAgent string) error
+}
+
+type trackUserSession interface {
+	TrackUserSession(ctx context.Context, email, key, ip, userAgent string) error
+}
+
+// currentSessionKey gets the key of the request's session in the session
+// store, or an empty string if it doesn't have one.
+func (s *Server) currentSessionKey(r *http.Request) string {
+	session, err := s.sessions.Get(r, "session")
+	if err != nil {
+		return ""
+	}
+	return session.ID
+}
+
+type getUserSessions interface {
+	GetUserSessions(ctx context.Context, email string) ([]*UserSession, error)
+}
+
+// GetUserSessions lists the current user's live sessions. Sessions that
+// were logged in before sessions were tracked show up without an IP or
+// user agent, since those weren't kept.
+func (s *Server) GetUserSessions(gs getUserSessions) E {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		email := r.Context().Value(emailContextKey).(string)
+
+		sessions, err := gs.GetUserSessions(r.Context(), email)
+		if err != nil {
+			s.getCtxLogger(r).Errorw("failed to get sessions", "err", err)
+			return err
+		}
+
+		current := s.currentSessionKey(r)
+		for _, session := range sessions {
+			session.Current = session.Key == current
+		}
+
+		return s.sendResponse(http.StatusOK, sessions, w, r)
+	}
+}
+
+type removeUserSession interface {
+	RemoveUserSession(ctx context.Context, email string, session ksuid.KSUID) error
+}
+
+func (s *Server) RemoveUserSession(rs removeUserSession) E {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		email := r.Context().Value(emailContextKey).(string)
+		id := chi.URLParam(r, "session_id")
+		l := s.getCtxLogger(r).With("session_id", id)
+
+		session, err := ksuid.Parse(id)
+		if err != nil {
+			l.Warnw("failed to parse session ID", "err", err)
+			return StatusError{
+				http.StatusNotFound,
+				"I couldn't find that session.",
+			}
+		}
+
+		err = rs.RemoveUserSession(r.Context(), email, session)
+		if errors.Is(err, sql.ErrNoRows) {
+			l.Warnw("attempted to revoke non-existent session")
+			return StatusError{
+				http.StatusNotFound,
+				"I couldn't find that session.",
+			}
+		} else if err != nil {
+			l.Errorw("failed to revoke session", "err", err)
+			return err
+		}
+
+		l.Infow("revoked session")
+		return s.sendResponse(http.StatusNoContent, nil, w, r)
+	}
+}
+
+type removeUserSessions interface {
+	RemoveUserSessions(ctx context.Context, email, except string) (int64, error)
+}
+
+// RemoveOtherUserSessions logs the current user out everywhere except
+// the session they're using.
+func (s *Server) RemoveOtherUserSessions(rs removeUserSessions) E {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		email := r.Context().Value(emailContextKey).(string)
+		l := s.getCtxLogger(r)
+
+		n, err := rs.RemoveUserSessions(r.Context(), email, s.currentSessionKey(r))
+		if err != nil {
+			l.Errorw("failed to revoke other sessions", "err", err)
+			return err
+		}
+
+		l.Infow("revoked other sessions", "sessions", n)
+		return s.sendResponse(http.StatusNoContent, nil, w, r)
+	}
+}
+
+// RevokeUserSessions logs a user out of all of their sessions, for site
+// admins dealing with a compromised or departed account. API tokens are
+// separate, and aren't touched.
+func (s *Server) RevokeUserSessions(rs removeUserSessions) E {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		l := s.getCtxLogger(r)
+
+		var user struct {
+			Email string `json:"email"`
+		}
+		err := json.NewDecoder(r.Body).Decode(&user)
+		if err != nil {
+			l.Warnw("failed to decode user from body", "err", err)
+			return StatusError{
+				http.StatusBadRequest,
+				"I couldn't decode the body. Are you sure it's an object with the user's email? This error might help: " + err.Error(),
+			}
+		}
+
+		user.Email = strings.ToLower(strings.TrimSpace(user.Email))
+		if !strings.Contains(user.Email, "@") {
+			l.Warnw("got session revocation without email", "target", user.Email)
+			return StatusError{
+				http.StatusBadRequest,
+				"It looks like the user isn't an email address.",
+			}
+		}
+
+		n, err := rs.RemoveUserSessions(r.Context(), user.Email, "")
+		if err != nil {
+			l.Errorw("failed to revoke user's sessions", "target", user.Email, "err", err)
+			return err
+		}
+
+		l.Infow("revoked user's sessions", "target", user.Email, "sessions", n)
+		return s.sendResponse(http.StatusNoContent, nil, w, r)
+	}
+}
+
+type purgeExpiredSessions interface {
+	PurgeExpiredSessions(ctx context.Context) (int64, error)
+}
+
+// purgeExpiredSessions clears out sessions that have expired, which the
+// session store otherwise keeps around forever.
+func (s *Server) purgeExpiredSessions(ps purgeExpiredSessions) job {
+	return func(ctx context.Context, l *zap.SugaredLogger) error {
+		n, err := ps.PurgeExpiredSessions(ctx)
+		if err != nil {
+			return err
+		}
+
+		if n > 0 {
+			l.Infow("purged expired sessions", "sessions", n)
+		}
+		return nil
+	}
+}
+
+type backfillUserSessions interface {
+	GetUntrackedSessions(ctx context.Context) ([]*StoredSession, error)
+	trackUserSession
+}
+
+// backfillUserSessions links sessions that were logged in before sessions
+// were tracked to their users, so they can be listed and revoked like the
+// rest, even if they're never used again. Sessions that never finished
+// logging in don't belong to anyone, and are left alone.
+func (s *Server) backfillUserSessions(bs backfillUserSessions) job {
+	return func(ctx context.Context, l *zap.SugaredLogger) error {
+		stored, err := bs.GetUntrackedSessions(ctx)
+		if err != nil {
+			return err
+		}
+
+		var n int
+		for _, session := range stored {
+			values := make(map[interface{}]interface{})
+			err := securecookie.DecodeMulti("session", session.Data, &values, s.sessions.Codecs...)
+			if err != nil {
+				l.Warnw("failed to decode stored session", "err", err)
+				continue
+			}
+
+			email, ok := values["email"].(string)
+			if !ok {
+				continue
+			}
+
+			err = bs.TrackUserSession(ctx, email, session.Key, "", "")
+			if err != nil {
+				return err
+			}
+			n++
+		}
+
+		if n > 0 {
+			l.Infow("backfilled user sessions", "sessions", n)
+		}
+		return nil
+	}
+}
@@ -0,0 +1,225 @@
+package api
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/dchest/uniuri"
+	"github.com/go-chi/chi/v5"
+	"github.com/segmentio/ksuid"
+)
+
+const (
+	apiTokenContextKey = "api_token"
+	apiTokenPrefix     = "ohq_"
+	apiTokenLength     = 40
+)
+
+func hashAPIToken(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(hash[:])
+}
+
+type useAPIToken interface {
+	UseAPIToken(ctx context.Context, hash string) (*APIToken, error)
+	MarkAPITokenUsed(ctx context.Context, token ksuid.KSUID) error
+}
+
+// tokenRetriever logs in the request with the API token from its
+// Authorization header, filling in the same context as a session would.
+func (s *Server) tokenRetriever(ut useAPIToken, raw string, next http.Handler, w http.ResponseWriter, r *http.Request) {
+	token, err := ut.UseAPIToken(r.Context(), hashAPIToken(raw))
+	if errors.Is(err, sql.ErrNoRows) {
+		s.getCtxLogger(r).Warnw("got invalid API token")
+		s.errorMessage(
+			http.StatusUnauthorized,
+			"That API token isn't valid. It might have expired or been revoked.",
+			w, r,
+		)
+		return
+	} else if err != nil {
+		s.getCtxLogger(r).Errorw("failed to get API token", "err", err)
+		s.internalServerError(w, r)
+		return
+	}
+
+	// Knowing when a token was last used is nice, but not worth failing
+	// the request over.
+	err = ut.MarkAPITokenUsed(r.Context(), token.ID)
+	if err != nil {
+		s.getCtxLogger(r).Warnw("failed to mark API token as used", "token_id", token.ID, "err", err)
+	}
+
+	ctx := context.WithValue(r.Context(), emailContextKey, token.Email)
+	ctx = context.WithValue(ctx, nameContextKey, token.UserName)
+	ctx = context.WithValue(ctx, firstNameContextKey, token.FirstName)
+	// Groups aren't kept with tokens, since they'd go stale; a token's
+	// roles only come from the site and course admin lists.
+	ctx = context.WithValue(ctx, GroupsContextKey, make([]string, 0))
+	ctx = context.WithValue(ctx, apiTokenContextKey, token)
+	ctx = context.WithValue(ctx, loggerContextKey, s.getCtxLogger(r).With("email", token.Email, "api_token", token.ID))
+
+	next.ServeHTTP(w, r.WithContext(ctx))
+}
+
+// tokenCourse gets the course that the request's API token is limited
+// to, if it's limited to one.
+func tokenCourse(r *http.Request) (ksuid.KSUID, bool) {
+	token, ok := r.Context().Value(apiTokenContextKey).(*APIToken)
+	if !ok || token.Course == nil {
+		return ksuid.Nil, false
+	}
+	return *token.Course, true
+}
+
+// RejectAPITokens only lets through requests logged in with a session, so
+// that a token can't be used to make itself more tokens.
+func (s *Server) RejectAPITokens(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := r.Context().Value(apiTokenContextKey).(*APIToken); ok {
+			s.getCtxLogger(r).Warnw("attempted to use API token where a session is required")
+			s.errorMessage(
+				http.StatusForbidden,
+				"API tokens can't be used here; log in instead.",
+				w, r,
+			)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+type getAPITokens interface {
+	GetAPITokens(ctx context.Context, email string) ([]*APIToken, error)
+}
+
+func (s *Server) GetAPITokens(gt getAPITokens) E {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		email := r.Context().Value(emailContextKey).(string)
+
+		tokens, err := gt.GetAPITokens(r.Context(), email)
+		if err != nil {
+			s.getCtxLogger(r).Errorw("failed to get API tokens", "err", err)
+			return err
+		}
+
+		return s.sendResponse(http.StatusOK, tokens, w, r)
+	}
+}
+
+type addAPIToken interface {
+	getCourse
+	AddAPIToken(ctx context.Context, token *APIToken, hash string) (*APIToken, error)
+}
+
+// AddAPIToken creates a token for the current user, optionally limited to
+// one course and set to expire. The token is in the response, and can't
+// be retrieved again afterwards.
+func (s *Server) AddAPIToken(at addAPIToken) E {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		l := s.getCtxLogger(r)
+
+		var token APIToken
+		err := json.NewDecoder(r.Body).Decode(&token)
+		if err != nil {
+			l.Warnw("failed to decode API token from body", "err", err)
+			return StatusError{
+				http.StatusBadRequest,
+				"I couldn't decode the body. Are you sure it's an object with a name, and optionally a course and expiry time? This error might help: " + err.Error(),
+			}
+		}
+
+		token.Name = strings.TrimSpace(token.Name)
+		if token.Name == "" {
+			l.Warnw("got API token without name")
+			return StatusError{
+				http.StatusBadRequest,
+				"Give the token a name so you can tell it apart from your others.",
+			}
+		}
+
+		if token.ExpiresAt != nil && !token.ExpiresAt.After(time.Now()) {
+			l.Warnw("got API token that has already expired", "expires_at", token.ExpiresAt)
+			return StatusError{
+				http.StatusBadRequest,
+				"The token's expiry time has to be in the future.",
+			}
+		}
+
+		if token.Course != nil {
+			_, err := at.GetCourse(r.Context(), *token.Course)
+			if errors.Is(err, sql.ErrNoRows) {
+				l.Warnw("attempted to limit API token to non-existent course", "course", token.Course)
+				return StatusError{
+					http.StatusBadRequest,
+					"I couldn't find the course you want to limit the token to.",
+				}
+			} else if err != nil {
+				l.Errorw("failed to get course", "err", err)
+				return err
+			}
+		}
+
+		token.Email = r.Context().Value(emailContextKey).(string)
+		token.UserName, _ = r.Context().Value(nameContextKey).(string)
+		token.FirstName, _ = r.Context().Value(firstNameContextKey).(string)
+
+		raw := apiTokenPrefix + uniuri.NewLen(apiTokenLength)
+		newToken, err := at.AddAPIToken(r.Context(), &token, hashAPIToken(raw))
+		if err != nil {
+			l.Errorw("failed to add API token", "err", err)
+			return err
+		}
+		newToken.Token = raw
+
+		l.Infow("created API token",
+			"api_token", newToken.ID,
+			"course", newToken.Course,
+		)
+		return s.sendResponse(http.StatusCreated, newToken, w, r)
+	}
+}
+
+type removeAPIToken interface {
+	RemoveAPIToken(ctx context.Context, email string, token ksuid.KSUID) error
+}
+
+func (s *Server) RemoveAPIToken(rt removeAPIToken) E {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		email := r.Context().Value(emailContextKey).(string)
+		id := chi.URLParam(r, "token_id")
+		l := s.getCtxLogger(r).With("api_token", id)
+
+		token, err := ksuid.Parse(id)
+		if err != nil {
+			l.Warnw("failed to parse API token ID", "err", err)
+			return StatusError{
+				http.StatusNotFound,
+				"I couldn't find that API token.",
+			}
+		}
+
+		err = rt.RemoveAPIToken(r.Context(), email, token)
+		if errors.Is(err, sql.ErrNoRows) {
+			l.Warnw("attempted to remove non-existent API token")
+			return StatusError{
+				http.StatusNotFound,
+				"I couldn't find that API token.",
+			}
+		} else if err != nil {
+			l.Errorw("failed to remove API token", "err", err)
+			return err
+		}
+
+		l.Infow("revoked API token")
+		return s.sendResponse(http.StatusNoContent, nil, w, r)
+	}
+}
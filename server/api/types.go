@@ -6,14 +6,150 @@ import (
 	"time"
 
 	"github.com/jmoiron/sqlx/types"
+	"github.com/lib/pq"
 	"github.com/segmentio/ksuid"
 )
 
 type Course struct {
-	ID        ksuid.KSUID `json:"id" db:"id"`
-	ShortName string      `json:"short_name" db:"short_name"`
-	FullName  string      `json:"full_name" db:"full_name"`
-	Queues    []*Queue    `json:"queues"`
+	ID         ksuid.KSUID `json:"id" db:"id"`
+	ShortName  string      `json:"short_name" db:"short_name"`
+	FullName   string      `json:"full_name" db:"full_name"`
+	TermStart  *time.Time  `json:"term_start,omitempty" db:"term_start"`
+	TermEnd    *time.Time  `json:"term_end,omitempty" db:"term_end"`
+	ArchivedAt *time.Time  `json:"archived_at,omitempty" db:"archived_at"`
+	Queues     []*Queue    `json:"queues"`
+}
+
+// InTerm reports whether t falls within the course's term. Either end
+// of the term may be left open, and a course without a term is always
+// in session.
+func (c *Course) InTerm(t time.Time) bool {
+	if c.TermStart != nil && t.Before(*c.TermStart) {
+		return false
+	}
+	if c.TermEnd != nil && !t.Before(*c.TermEnd) {
+		return false
+	}
+	return true
+}
+
+// CourseOverview is a course along with all of its queues and staff, for
+// site admins looking over every course.
+type CourseOverview struct {
+	Course
+	Admins []*CourseStaff `json:"admins"`
+	Groups []*CourseGroup `json:"groups"`
+}
+
+// CourseCloneOptions describes a new course to create from an existing
+// one, along with which parts of the existing course to carry over.
+// Student data (entries, rosters, groups, and appointments) is never
+// copied.
+type CourseCloneOptions struct {
+	Course Course `json:"course"`
+
+	// Admins covers both course admins and the OIDC groups that make
+	// their members admins.
+	Admins               bool `json:"admins"`
+	Queues               bool `json:"queues"`
+	Configuration        bool `json:"configuration"`
+	Schedules            bool `json:"schedules"`
+	AppointmentSchedules bool `json:"appointment_schedules"`
+	AppointmentTypes     bool `json:"appointment_types"`
+}
+
+// TermFilter selects courses by where their term lies relative to now.
+type TermFilter string
+
+const (
+	TermAll      TermFilter = ""
+	TermCurrent  TermFilter = "current"
+	TermPast     TermFilter = "past"
+	TermUpcoming TermFilter = "upcoming"
+)
+
+// CourseRole is a staff member's role within a course, which decides
+// what they're allowed to do there (see rolePermissions).
+type CourseRole string
+
+const (
+	RoleNone       CourseRole = ""
+	RoleTA         CourseRole = "ta"
+	RoleHeadTA     CourseRole = "head_ta"
+	RoleInstructor CourseRole = "instructor"
+)
+
+// CourseStaff is a member of a course's staff along with their role.
+type CourseStaff struct {
+	Email string     `json:"email" db:"email"`
+	Role  CourseRole `json:"role" db:"role"`
+}
+
+// UnmarshalJSON accepts either a bare email, which is left without a role
+// so that it can keep the one it already has (see keepCourseAdminRoles),
+// or an object with an email and role.
+func (c *CourseStaff) UnmarshalJSON(b []byte) error {
+	var email string
+	if err := json.Unmarshal(b, &email); err == nil {
+		*c = CourseStaff{Email: email}
+		return nil
+	}
+
+	type courseStaff CourseStaff
+	var staff courseStaff
+	if err := json.Unmarshal(b, &staff); err != nil {
+		return err
+	}
+	*c = CourseStaff(staff)
+	return nil
+}
+
+// CourseGroup is an OIDC group whose members are all on a course's staff
+// with the given role.
+type CourseGroup struct {
+	Group string     `json:"group" db:"group_name"`
+	Role  CourseRole `json:"role" db:"role"`
+}
+
+// UnmarshalJSON accepts either a bare group name, which gets the TA role,
+// or an object with a group and role.
+func (c *CourseGroup) UnmarshalJSON(b []byte) error {
+	var group string
+	if err := json.Unmarshal(b, &group); err == nil {
+		*c = CourseGroup{Group: group, Role: RoleTA}
+		return nil
+	}
+
+	type courseGroup CourseGroup
+	var g courseGroup
+	if err := json.Unmarshal(b, &g); err != nil {
+		return err
+	}
+	*c = CourseGroup(g)
+	return nil
+}
+
+// RosterStudent is a student on a course's roster.
+type RosterStudent struct {
+	Email     string `json:"email" db:"email"`
+	Name      string `json:"name" db:"name"`
+	StudentID string `json:"student_id" db:"student_id"`
+	Section   string `json:"section" db:"section"`
+}
+
+// RosterUpdate is a student whose roster details are changing.
+type RosterUpdate struct {
+	Before *RosterStudent `json:"before"`
+	After  *RosterStudent `json:"after"`
+}
+
+// RosterDiff describes what a roster import changes (or, on a dry run,
+// would change).
+type RosterDiff struct {
+	DryRun  bool             `json:"dry_run"`
+	Added   []*RosterStudent `json:"added"`
+	Updated []*RosterUpdate  `json:"updated"`
+	Removed []*RosterStudent `json:"removed"`
 }
 
 type QueueType string
@@ -31,6 +167,14 @@ type Queue struct {
 	Location string      `json:"location" db:"location"`
 	Map      string      `json:"map" db:"map"`
 	Active   bool        `json:"active" db:"active"`
+
+	// Set when either the queue or its course has been archived.
+	ArchivedAt *time.Time `json:"archived_at,omitempty" db:"archived_at"`
+
+	// The roster sections the queue is restricted to, and whether the
+	// logged-in user can join it; only filled in when listing courses.
+	Sections pq.StringArray `json:"sections,omitempty" db:"sections"`
+	Applies  *bool          `json:"applies,omitempty" db:"-"`
 }
 
 type QueueConfiguration struct {
@@ -45,6 +189,24 @@ type QueueConfiguration struct {
 	Scheduled           bool           `json:"scheduled" db:"scheduled"`
 	ManualOpen          bool           `json:"manual_open" db:"manual_open"`
 	Prompts             types.JSONText `json:"prompts" db:"prompts"`
+	LastCall            int            `json:"last_call" db:"last_call"`
+	BookingHorizon      int            `json:"booking_horizon" db:"booking_horizon"`
+	AppointmentReminder int            `json:"appointment_reminder" db:"appointment_reminder"`
+	NoShowLimit         int            `json:"no_show_limit" db:"no_show_limit"`
+	NoShowPenalty       int            `json:"no_show_penalty" db:"no_show_penalty"`
+	StaffSelection      bool           `json:"staff_selection" db:"staff_selection"`
+
+	// Only students on the roster in one of these sections can join the
+	// queue. An empty list leaves the queue open to every section.
+	Sections pq.StringArray `json:"sections" db:"sections"`
+}
+
+// QueueLastCall is what's needed to tell when a scheduled queue enters
+// its last call window on the current day.
+type QueueLastCall struct {
+	Queue    ksuid.KSUID `db:"queue"`
+	LastCall int         `db:"last_call"`
+	Schedule string      `db:"schedule"`
 }
 
 type Announcement struct {
@@ -166,29 +328,91 @@ type Message struct {
 	Receiver string      `json:"receiver" db:"receiver"`
 }
 
+// AppointmentSchedule is either the weekly schedule for a day, or, if
+// Date is set, an override of the weekly schedule on that date.
 type AppointmentSchedule struct {
 	Queue    ksuid.KSUID  `json:"queue" db:"queue"`
 	Day      time.Weekday `json:"day" db:"day"`
+	Date     *time.Time   `json:"date,omitempty" db:"date"`
 	Duration int          `json:"duration" db:"duration"`
 	Padding  int          `json:"padding" db:"padding"`
 	Schedule string       `json:"schedule" db:"schedule"`
 }
 
 type AppointmentSlot struct {
-	ID            ksuid.KSUID `json:"id" db:"id"`
-	Queue         ksuid.KSUID `json:"queue" db:"queue"`
-	StaffEmail    *string     `json:"staff_email,omitempty" db:"staff_email"`
-	StudentEmail  *string     `json:"student_email,omitempty" db:"student_email"`
-	ScheduledTime time.Time   `json:"scheduled_time" db:"scheduled_time"`
-	Timeslot      int         `json:"timeslot" db:"timeslot"`
-	Duration      int         `json:"duration" db:"duration"`
-	Name          *string     `json:"name,omitempty" db:"name"`
-	Location      *string     `json:"location,omitempty" db:"location"`
-	Description   *string     `json:"description,omitempty" db:"description"`
-	MapX          *float32    `json:"map_x,omitempty" db:"map_x"`
-	MapY          *float32    `json:"map_y,omitempty" db:"map_y"`
+	ID            ksuid.KSUID         `json:"id" db:"id"`
+	Queue         ksuid.KSUID         `json:"queue" db:"queue"`
+	StaffEmail    *string             `json:"staff_email,omitempty" db:"staff_email"`
+	StudentEmail  *string             `json:"student_email,omitempty" db:"student_email"`
+	ScheduledTime time.Time           `json:"scheduled_time" db:"scheduled_time"`
+	Timeslot      int                 `json:"timeslot" db:"timeslot"`
+	Duration      int                 `json:"duration" db:"duration"`
+	Name          *string             `json:"name,omitempty" db:"name"`
+	Location      *string             `json:"location,omitempty" db:"location"`
+	Description   *string             `json:"description,omitempty" db:"description"`
+	MapX          *float32            `json:"map_x,omitempty" db:"map_x"`
+	MapY          *float32            `json:"map_y,omitempty" db:"map_y"`
+	RemindedAt    *time.Time          `json:"reminded_at,omitempty" db:"reminded_at"`
+	Outcome       *AppointmentOutcome `json:"outcome,omitempty" db:"outcome"`
+
+	// The staff member the student asked to meet with, if any. Only
+	// they can claim the appointment.
+	RequestedStaff *string `json:"requested_staff,omitempty" db:"requested_staff"`
+
+	// The kind of appointment, if it isn't a plain one lasting a single
+	// timeslot.
+	AppointmentType *ksuid.KSUID `json:"appointment_type,omitempty" db:"appointment_type"`
+}
+
+// StaffNote is what a staff member wrote down after helping a student,
+// attached to the queue entry or appointment it was about. Notes are only
+// ever shown to course staff.
+type StaffNote struct {
+	ID          ksuid.KSUID    `json:"id" db:"id"`
+	Queue       ksuid.KSUID    `json:"queue" db:"queue"`
+	Email       string         `json:"email" db:"email"`
+	Entry       *ksuid.KSUID   `json:"entry,omitempty" db:"entry"`
+	Appointment *ksuid.KSUID   `json:"appointment,omitempty" db:"appointment"`
+	Author      string         `json:"author" db:"author"`
+	Note        string         `json:"note" db:"note"`
+	Category    string         `json:"category" db:"category"`
+	Resolution  NoteResolution `json:"resolution" db:"resolution"`
+}
+
+// NoteResolution is whether the student's problem got sorted out.
+type NoteResolution string
+
+const (
+	ResolutionNone       NoteResolution = ""
+	ResolutionResolved   NoteResolution = "resolved"
+	ResolutionUnresolved NoteResolution = "unresolved"
+	ResolutionFollowUp   NoteResolution = "follow_up"
+)
+
+// AppointmentType is a kind of appointment students can book on a queue,
+// like a quick question or a longer design review. Appointments of a type
+// run into as many timeslots as their duration needs.
+type AppointmentType struct {
+	ID       ksuid.KSUID `json:"id" db:"id"`
+	Queue    ksuid.KSUID `json:"queue" db:"queue"`
+	Name     string      `json:"name" db:"name"`
+	Duration int         `json:"duration" db:"duration"`
+
+	// The most appointments of this type that can run at once, or 0 for
+	// no limit beyond the schedule.
+	Capacity int `json:"capacity" db:"capacity"`
 }
 
+// AppointmentOutcome is how an appointment went, as recorded by the staff
+// member who claimed it.
+type AppointmentOutcome string
+
+const (
+	OutcomeAttended   AppointmentOutcome = "attended"
+	OutcomeNoShow     AppointmentOutcome = "no_show"
+	OutcomeLateCancel AppointmentOutcome = "late_cancel"
+)
+
 func (a *AppointmentSlot) MarshalJSON() ([]byte, error) {
 	type AppointmentSlotWithTimestamp AppointmentSlot
 	a.ScheduledTime = a.ScheduledTime.In(time.Local)
@@ -214,5 +438,100 @@ func (a *AppointmentSlot) Anonymized() *AppointmentSlot {
 func (a *AppointmentSlot) NoStaffEmail() *AppointmentSlot {
 	newAppointment := *a
 	newAppointment.StaffEmail = nil
+	if a.RequestedStaff != nil {
+		staff := StaffID(a.Queue, *a.RequestedStaff)
+		newAppointment.RequestedStaff = &staff
+	}
 	return &newAppointment
 }
+
+// AuditAction is a kind of administrative action recorded in a course's
+// audit log.
+type AuditAction string
+
+const (
+	AuditUpdateConfiguration       AuditAction = "update_configuration"
+	AuditUpdateOpenStatus          AuditAction = "update_open_status"
+	AuditUpdateSchedule            AuditAction = "update_schedule"
+	AuditUpdateAppointmentSchedule AuditAction = "update_appointment_schedule"
+	AuditSetAppointmentOverride    AuditAction = "set_appointment_schedule_override"
+	AuditRemoveAppointmentOverride AuditAction = "remove_appointment_schedule_override"
+	AuditImportSchedules           AuditAction = "import_schedules"
+	AuditAddAdmins                 AuditAction = "add_admins"
+	AuditOverwriteAdmins           AuditAction = "overwrite_admins"
+	AuditRemoveAdmins              AuditAction = "remove_admins"
+	AuditUpdateAdminGroups         AuditAction = "update_admin_groups"
+	AuditAddGuests                 AuditAction = "add_guests"
+	AuditRemoveGuests              AuditAction = "remove_guests"
+	AuditImportRoster              AuditAction = "import_roster"
+	AuditRemoveRosterStudents      AuditAction = "remove_roster_students"
+	AuditClearQueue                AuditAction = "clear_queue"
+	AuditRandomizeQueue            AuditAction = "randomize_queue"
+	AuditPinEntry                  AuditAction = "pin_entry"
+	AuditAddAnnouncement           AuditAction = "add_announcement"
+	AuditRemoveAnnouncement        AuditAction = "remove_announcement"
+	AuditArchiveCourse             AuditAction = "archive_course"
+	AuditRestoreCourse             AuditAction = "restore_course"
+	AuditArchiveQueue              AuditAction = "archive_queue"
+	AuditRestoreQueue              AuditAction = "restore_queue"
+)
+
+// AuditRecord is an administrative action someone took in a course, with
+// whatever it changed as JSON from before and after the action.
+type AuditRecord struct {
+	ID        ksuid.KSUID     `json:"id" db:"id"`
+	Course    ksuid.KSUID     `json:"course" db:"course"`
+	Queue     *ksuid.KSUID    `json:"queue,omitempty" db:"queue"`
+	Actor     string          `json:"actor" db:"actor"`
+	Action    AuditAction     `json:"action" db:"action"`
+	Target    string          `json:"target" db:"target"`
+	Before    json.RawMessage `json:"before" db:"old_value"`
+	After     json.RawMessage `json:"after" db:"new_value"`
+	CreatedAt time.Time       `json:"created_at" db:"created_at"`
+}
+
+// APIToken is a personal access token that scripts can use in place of a
+// login session. Only a hash of the token itself is stored, so Token is
+// only filled in when the token is created. The owner's name is saved
+// from their session at that time.
+type APIToken struct {
+	ID         ksuid.KSUID  `json:"id" db:"id"`
+	Email      string       `json:"-" db:"email"`
+	UserName   string       `json:"-" db:"user_name"`
+	FirstName  string       `json:"-" db:"first_name"`
+	Name       string       `json:"name" db:"name"`
+	Course     *ksuid.KSUID `json:"course,omitempty" db:"course"`
+	CreatedAt  time.Time    `json:"created_at" db:"created_at"`
+	ExpiresAt  *time.Time   `json:"expires_at,omitempty" db:"expires_at"`
+	LastUsedAt *time.Time   `json:"last_used_at,omitempty" db:"last_used_at"`
+	Token      string       `json:"token,omitempty" db:"-"`
+}
+
+// ImpersonatedUser is who a site admin is viewing the queue as. Name and
+// groups are optional, since only the email is needed to look up most of
+// what a user can see.
+type ImpersonatedUser struct {
+	Email     string   `json:"email"`
+	Name      string   `json:"name"`
+	FirstName string   `json:"first_name"`
+	Groups    []string `json:"groups"`
+}
+
+// StoredSession is a session as the session store keeps it, before its
+// values are decoded.
+type StoredSession struct {
+	Key  string `db:"key"`
+	Data string `db:"data"`
+}
+
+// UserSession is a login session that a user can see and revoke. The key
+// identifies the session in the session store, so it's never sent out.
+type UserSession struct {
+	ID        ksuid.KSUID `json:"id" db:"id"`
+	Key       string      `json:"-" db:"session_key"`
+	IP        string      `json:"ip" db:"ip"`
+	UserAgent string      `json:"user_agent" db:"user_agent"`
+	CreatedAt time.Time   `json:"created_at" db:"created_at"`
+	ExpiresAt time.Time   `json:"expires_at" db:"expires_on"`
+	Current   bool        `json:"current" db:"-"`
+}
@@ -0,0 +1,399 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/segmentio/ksuid"
+	"go.uber.org/zap"
+)
+
+type promoteWaitlist interface {
+	getQueueConfiguration
+	getAppointmentScheduleForDate
+	getAppointmentsForUser
+	getAppointmentsInTimeFrame
+	getAppointmentType
+	checkAppointmentEligibility
+	GetWaitlist(ctx context.Context, queue ksuid.KSUID, from, to time.Time) ([]*AppointmentSlot, error)
+	RemoveWaitlistEntry(ctx context.Context, entry ksuid.KSUID) error
+	SignupForAppointment(ctx context.Context, queue ksuid.KSUID, appointment *AppointmentSlot) (*AppointmentSlot, error)
+}
+
+// promoteWaitlist books waitlisted students into any timeslots between
+// from and to that have room, first come first served. Students who got
+// an appointment some other way in the meantime are dropped from the
+// waitlist instead, and students who can't book right now (like those
+// with too many no-shows) are passed over.
+func (s *Server) promoteWaitlist(ctx context.Context, l *zap.SugaredLogger, pw promoteWaitlist, queue ksuid.KSUID, from, to time.Time) error {
+	if now := time.Now(); from.Before(now) {
+		from = now
+	}
+
+	waitlist, err := pw.GetWaitlist(ctx, queue, from, to)
+	if err != nil {
+		l.Errorw("failed to get waitlist", "err", err)
+		return err
+	}
+
+	if len(waitlist) == 0 {
+		return nil
+	}
+
+	config, err := pw.GetQueueConfiguration(ctx, queue)
+	if err != nil {
+		l.Errorw("failed to get queue configuration", "err", err)
+		return err
+	}
+
+	schedules := make(map[time.Time]*AppointmentSchedule)
+	for _, e := range waitlist {
+		date, end := DateBounds(e.ScheduledTime)
+		schedule, ok := schedules[date]
+		if !ok {
+			schedule, err = pw.GetAppointmentScheduleForDate(ctx, queue, date)
+			if err != nil {
+				l.Errorw("failed to get appointment schedule", "date", date, "err", err)
+				return err
+			}
+			schedules[date] = schedule
+		}
+
+		// The timeslot went away with a change in duration.
+		if e.Timeslot >= len(schedule.Schedule) {
+			err = pw.RemoveWaitlistEntry(ctx, e.ID)
+			if err != nil {
+				l.Errorw("failed to remove waitlist entry", "entry_id", e.ID, "err", err)
+				return err
+			}
+			continue
+		}
+
+		el := l.With("entry_id", e.ID)
+
+		// The entry's appointment type was deleted, or no longer fits in
+		// the day.
+		duration, appointmentType, err := s.appointmentDuration(ctx, el, pw, queue, schedule, e.AppointmentType)
+		var se StatusError
+		if errors.As(err, &se) || (err == nil && e.Timeslot+TimeslotSpan(duration, schedule.Duration) > len(schedule.Schedule)) {
+			err = pw.RemoveWaitlistEntry(ctx, e.ID)
+			if err != nil {
+				el.Errorw("failed to remove waitlist entry", "err", err)
+				return err
+			}
+			continue
+		} else if err != nil {
+			return err
+		}
+
+		dayAppointments, err := pw.GetAppointments(ctx, queue, date, end)
+		if err != nil {
+			l.Errorw("failed to get appointments for day", "err", err)
+			return err
+		}
+
+		if !appointmentFits(schedule, dayAppointments, e.Timeslot, duration, appointmentType) {
+			continue
+		}
+
+		err = s.checkAppointmentEligibility(ctx, el, pw, queue, config, schedule, *e.StudentEmail, false)
+		if errors.As(err, &se) {
+			el.Infow("passed over waitlisted student who can't book", "reason", se.message)
+			continue
+		} else if err != nil {
+			return err
+		}
+
+		appointments, err := pw.GetAppointmentsForUser(ctx, queue, time.Now().Add(-time.Duration(schedule.Duration)*time.Minute), BigTime(), *e.StudentEmail)
+		if err != nil {
+			l.Errorw("failed to get future appointments for user", "err", err)
+			return err
+		}
+
+		err = pw.RemoveWaitlistEntry(ctx, e.ID)
+		if err != nil {
+			l.Errorw("failed to remove waitlist entry", "entry_id", e.ID, "err", err)
+			return err
+		}
+
+		if len(appointments) > 0 {
+			continue
+		}
+
+		e.ScheduledTime = DateTimeslotToTime(date, e.Timeslot, schedule.Duration)
+		e.Duration = duration
+		newAppointment, err := pw.SignupForAppointment(ctx, queue, e)
+		if err != nil {
+			l.Errorw("failed to sign up waitlisted student for appointment", "entry_id", e.ID, "err", err)
+			return err
+		}
+
+		l.Infow("booked appointment from waitlist",
+			"entry_id", e.ID,
+			"appointment_id", newAppointment.ID,
+			"scheduled_time", newAppointment.ScheduledTime,
+		)
+
+		s.ps.Pub(WS("APPOINTMENT_CREATE", newAppointment), QueueTopicAdmin(queue))
+		s.ps.Pub(WS("APPOINTMENT_CREATE", newAppointment.Anonymized()), QueueTopicNonPrivileged(queue))
+		s.ps.Pub(WS("APPOINTMENT_UPDATE", newAppointment.NoStaffEmail()), QueueTopicEmail(queue, *e.StudentEmail))
+		s.ps.Pub(WS("WAITLIST_BOOKED", newAppointment.NoStaffEmail()), QueueTopicEmail(queue, *e.StudentEmail))
+	}
+
+	return nil
+}
+
+type getWaitlistForUser interface {
+	GetWaitlistForUser(ctx context.Context, queue ksuid.KSUID, from, to time.Time, email string) ([]*AppointmentSlot, error)
+}
+
+func (s *Server) GetWaitlistForCurrentUser(gw getWaitlistForUser) E {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		q := r.Context().Value(queueContextKey).(*Queue)
+		date := r.Context().Value(appointmentDateContextKey).(time.Time)
+		email := r.Context().Value(emailContextKey).(string)
+
+		from, to := DateBounds(date)
+		waitlist, err := gw.GetWaitlistForUser(r.Context(), q.ID, from, to, email)
+		if err != nil {
+			s.getCtxLogger(r).Errorw("failed to get waitlist for user",
+				"date", date,
+				"err", err,
+			)
+			return err
+		}
+
+		return s.sendResponse(http.StatusOK, waitlist, w, r)
+	}
+}
+
+type joinWaitlist interface {
+	getCourse
+	getQueueConfiguration
+	getAppointmentScheduleForDate
+	getAppointmentsForUser
+	getAppointmentsInTimeFrame
+	getWaitlistForUser
+	getAppointmentType
+	checkAppointmentEligibility
+	AddWaitlistEntry(ctx context.Context, queue ksuid.KSUID, entry *AppointmentSlot) (*AppointmentSlot, error)
+}
+
+func (s *Server) JoinWaitlist(jw joinWaitlist) E {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		q := r.Context().Value(queueContextKey).(*Queue)
+		date := r.Context().Value(appointmentDateContextKey).(time.Time)
+		timeslot := r.Context().Value(appointmentTimeslotContextKey).(int)
+		email := r.Context().Value(emailContextKey).(string)
+		name := r.Context().Value(nameContextKey).(string)
+		admin := r.Context().Value(courseAdminContextKey).(bool)
+		l := s.getCtxLogger(r).With(
+			"date", date,
+			"timeslot", timeslot,
+		)
+
+		course, err := jw.GetCourse(r.Context(), q.Course)
+		if err != nil {
+			l.Errorw("failed to get course", "err", err)
+			return err
+		}
+
+		if !course.InTerm(time.Now()) {
+			l.Warnw("student attempted to join waitlist outside of course term")
+			return StatusError{
+				http.StatusForbidden,
+				"This course isn't in session right now, so appointments are closed.",
+			}
+		}
+
+		config, err := jw.GetQueueConfiguration(r.Context(), q.ID)
+		if err != nil {
+			l.Errorw("failed to get queue configuration", "err", err)
+			return err
+		}
+
+		if !WithinBookingHorizon(date, config.BookingHorizon) {
+			l.Warnw("attempted to join waitlist outside of booking horizon")
+			return StatusError{
+				http.StatusBadRequest,
+				fmt.Sprintf("Appointments can only be made for the next %d days.", config.BookingHorizon),
+			}
+		}
+
+		var entry AppointmentSlot
+		err = json.NewDecoder(r.Body).Decode(&entry)
+		if err != nil {
+			l.Warnw("failed to decode waitlist entry", "err", err)
+			return StatusError{
+				http.StatusBadRequest,
+				"We couldn't read your appointment in the request body.",
+			}
+		}
+		entry.Name = &name
+
+		if entry.Description == nil || entry.Location == nil ||
+			*entry.Description == "" || *entry.Name == "" || *entry.Location == "" {
+			l.Warnw("got incomplete waitlist entry", "entry", entry)
+			return StatusError{
+				http.StatusBadRequest,
+				"It looks like you left out some fields in the appointment.",
+			}
+		}
+
+		schedule, err := jw.GetAppointmentScheduleForDate(r.Context(), q.ID, date)
+		if err != nil {
+			l.Errorw("failed to get appointment schedule", "err", err)
+			return err
+		}
+
+		// Students who couldn't book the appointment if it were open
+		// shouldn't be able to wait for it either.
+		err = s.checkAppointmentEligibility(r.Context(), l, jw, q.ID, config, schedule, email, admin)
+		if err != nil {
+			return err
+		}
+
+		if timeslot >= len(schedule.Schedule) {
+			l.Warnw("attempted to join waitlist for non-existent timeslot", "num_slots", len(schedule.Schedule))
+			return StatusError{
+				http.StatusNotFound,
+				"That timeslot doesn't exist!",
+			}
+		}
+
+		duration, appointmentType, err := s.appointmentDuration(r.Context(), l, jw, q.ID, schedule, entry.AppointmentType)
+		if err != nil {
+			return err
+		}
+
+		if timeslot+TimeslotSpan(duration, schedule.Duration) > len(schedule.Schedule) {
+			l.Warnw("attempted to join waitlist for appointment running past end of day", "duration", duration)
+			return StatusError{
+				http.StatusBadRequest,
+				"That appointment would run past the end of the day.",
+			}
+		}
+
+		scheduledTime := DateTimeslotToTime(date, timeslot, schedule.Duration)
+		if time.Now().After(scheduledTime) {
+			l.Warnw("attempted to join waitlist for timeslot in the past")
+			return StatusError{
+				http.StatusBadRequest,
+				"That timeslot has already started!",
+			}
+		}
+
+		start, end := DateBounds(date)
+		dayAppointments, err := jw.GetAppointments(r.Context(), q.ID, start, end)
+		if err != nil {
+			l.Errorw("failed to get appointments for day", "err", err)
+			return err
+		}
+
+		if appointmentFits(schedule, dayAppointments, timeslot, duration, appointmentType) {
+			l.Warnw("attempted to join waitlist for timeslot with open slots")
+			return StatusError{
+				http.StatusConflict,
+				"There's still room at that time, so go ahead and sign up!",
+			}
+		}
+
+		appointments, err := jw.GetAppointmentsForUser(r.Context(), q.ID, time.Now().Add(-time.Duration(schedule.Duration)*time.Minute), BigTime(), email)
+		if err != nil {
+			l.Errorw("failed to get future appointments for user", "err", err)
+			return err
+		}
+
+		if len(appointments) > 0 {
+			l.Warn("user attempted to join waitlist with appointment in future")
+			return StatusError{
+				http.StatusConflict,
+				"You already have an appointment in the future!",
+			}
+		}
+
+		waitlist, err := jw.GetWaitlistForUser(r.Context(), q.ID, scheduledTime, scheduledTime, email)
+		if err != nil {
+			l.Errorw("failed to get waitlist for user", "err", err)
+			return err
+		}
+
+		if len(waitlist) > 0 {
+			l.Warnw("user attempted to join waitlist twice")
+			return StatusError{
+				http.StatusConflict,
+				"You're already on the waitlist for that time!",
+			}
+		}
+
+		entry.Queue = q.ID
+		entry.Timeslot = timeslot
+		entry.ScheduledTime = scheduledTime
+		entry.Duration = duration
+		entry.StudentEmail = &email
+
+		var zero float32
+		if entry.MapX == nil {
+			entry.MapX = &zero
+		}
+		if entry.MapY == nil {
+			entry.MapY = &zero
+		}
+
+		newEntry, err := jw.AddWaitlistEntry(r.Context(), q.ID, &entry)
+		if err != nil {
+			l.Errorw("failed to add waitlist entry", "err", err)
+			return err
+		}
+
+		l.Infow("joined appointment waitlist", "entry_id", newEntry.ID)
+
+		return s.sendResponse(http.StatusCreated, newEntry, w, r)
+	}
+}
+
+type leaveWaitlist interface {
+	getWaitlistForUser
+	RemoveWaitlistEntry(ctx context.Context, entry ksuid.KSUID) error
+}
+
+func (s *Server) LeaveWaitlist(lw leaveWaitlist) E {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		q := r.Context().Value(queueContextKey).(*Queue)
+		date := r.Context().Value(appointmentDateContextKey).(time.Time)
+		timeslot := r.Context().Value(appointmentTimeslotContextKey).(int)
+		email := r.Context().Value(emailContextKey).(string)
+		l := s.getCtxLogger(r).With(
+			"date", date,
+			"timeslot", timeslot,
+		)
+
+		from, to := DateBounds(date)
+		waitlist, err := lw.GetWaitlistForUser(r.Context(), q.ID, from, to, email)
+		if err != nil {
+			l.Errorw("failed to get waitlist for user", "err", err)
+			return err
+		}
+
+		// Leaving a waitlist you aren't on still has the intended effect.
+		for _, e := range waitlist {
+			if e.Timeslot != timeslot {
+				continue
+			}
+
+			err = lw.RemoveWaitlistEntry(r.Context(), e.ID)
+			if err != nil {
+				l.Errorw("failed to remove waitlist entry", "entry_id", e.ID, "err", err)
+				return err
+			}
+
+			l.Infow("left appointment waitlist", "entry_id", e.ID)
+		}
+
+		return s.sendResponse(http.StatusNoContent, nil, w, r)
+	}
+}
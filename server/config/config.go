@@ -1,6 +1,7 @@
 package config
 
 import (
+	"encoding/json"
 	"fmt"
 	"os"
 	"strings"
@@ -16,14 +17,18 @@ type Config struct {
 	DBUsername string `env:"QUEUE_DB_USERNAME,notEmpty"`
 	DBPassword string
 
-	// OAuth/OIDC configuration
-	OIDCIssuerURL      string `env:"QUEUE_OIDC_ISSUER_URL,notEmpty"`
-	OAuth2ClientID     string `env:"QUEUE_OAUTH2_CLIENT_ID,notEmpty"`
-	OAuth2ClientSecret string
+	// OAuth/OIDC configuration. The issuer and client variables set up a
+	// single provider; for more than one, use a providers file instead.
+	OIDCIssuerURL      string `env:"QUEUE_OIDC_ISSUER_URL"`
+	OAuth2ClientID     string `env:"QUEUE_OAUTH2_CLIENT_ID"`
+	OIDCProvidersFile  string `env:"QUEUE_OIDC_PROVIDERS_FILE"`
+	OIDCProviders      []*OIDCProvider
 	OAuth2RedirectURI  string   `env:"QUEUE_OAUTH2_REDIRECT_URI,notEmpty"`
 	OAuth2UsePKCE      bool     `env:"QUEUE_OAUTH2_USE_PKCE" envDefault:"true"`
-	ValidDomain        string   `env:"QUEUE_VALID_DOMAIN,notEmpty"`
+	ValidDomains       []string `env:"QUEUE_VALID_DOMAIN,notEmpty" envSeparator:","`
+	validDomainsSet    map[string]struct{}
 	SiteAdminGroups    []string `env:"QUEUE_SITE_ADMIN_GROUPS" envSeparator:","`
+	BootstrapSiteAdmin string   `env:"QUEUE_BOOTSTRAP_SITE_ADMIN"`
 	siteAdminGroupsSet map[string]struct{}
 
 	// Server configuration
@@ -32,7 +37,7 @@ type Config struct {
 
 	// Secret file paths - private to avoid exposing sensitive paths
 	DBPasswordFile         string `env:"QUEUE_DB_PASSWORD_FILE,notEmpty"`
-	OAuth2ClientSecretFile string `env:"QUEUE_OAUTH2_CLIENT_SECRET_FILE,notEmpty"`
+	OAuth2ClientSecretFile string `env:"QUEUE_OAUTH2_CLIENT_SECRET_FILE"`
 	SessionsKeyFile        string `env:"QUEUE_SESSIONS_KEY_FILE,notEmpty"`
 	MetricsPasswordFile    string `env:"METRICS_PASSWORD_FILE,notEmpty"`
 
@@ -41,6 +46,45 @@ type Config struct {
 	MetricsPassword string
 }
 
+// OIDCProvider is one identity provider that users can log in with
+type OIDCProvider struct {
+	// Name identifies the provider in login links, so it should stay
+	// the same once users are logging in with it
+	Name             string     `json:"name"`
+	DisplayName      string     `json:"display_name"`
+	IssuerURL        string     `json:"issuer_url"`
+	ClientID         string     `json:"client_id"`
+	ClientSecretFile string     `json:"client_secret_file"`
+	ClientSecret     string     `json:"-"`
+	RedirectURI      string     `json:"redirect_uri"`
+	Scopes           []string   `json:"scopes"`
+	UsePKCE          *bool      `json:"use_pkce"`
+	Claims           OIDCClaims `json:"claims"`
+
+	// AllowedDomains limits logins to emails in these domains. Users are
+	// only identified by email, so no two providers can share a domain.
+	// Providers in the providers file default to the valid domains; the
+	// single provider from the environment is the only one there is, so
+	// it accepts any email.
+	AllowedDomains []string `json:"allowed_domains"`
+
+	// TrustGroups is whether the provider's groups claim can be used to
+	// make users site admins and course staff. It's off by default for
+	// providers in the providers file, so that a provider can't hand out
+	// admin access unless it's been vouched for.
+	TrustGroups *bool `json:"trust_groups"`
+}
+
+// OIDCClaims maps the user info claims that the queue uses to the names
+// that a provider gives them
+type OIDCClaims struct {
+	Email         string `json:"email"`
+	EmailVerified string `json:"email_verified"`
+	Name          string `json:"name"`
+	GivenName     string `json:"given_name"`
+	Groups        string `json:"groups"`
+}
+
 // Global application configuration
 var AppConfig Config
 
@@ -54,6 +98,16 @@ func (c *Config) AnyInSiteAdminGroups(userGroups []string) bool {
 	return false
 }
 
+// ValidEmail checks if the email is in one of the valid domains
+func (c *Config) ValidEmail(email string) bool {
+	at := strings.LastIndex(email, "@")
+	if at < 0 {
+		return false
+	}
+	_, ok := c.validDomainsSet[strings.ToLower(email[at+1:])]
+	return ok
+}
+
 // Load loads configuration from environment variables and secret files
 func Load() error {
 	// Parse environment variables
@@ -61,6 +115,15 @@ func Load() error {
 		return fmt.Errorf("failed to parse environment variables: %w", err)
 	}
 
+	// Build valid domains set
+	AppConfig.validDomainsSet = make(map[string]struct{})
+	for _, domain := range AppConfig.ValidDomains {
+		domain = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(domain), "@"))
+		if domain != "" {
+			AppConfig.validDomainsSet[domain] = struct{}{}
+		}
+	}
+
 	// Build admin groups set
 	AppConfig.siteAdminGroupsSet = make(map[string]struct{})
 	for _, group := range AppConfig.SiteAdminGroups {
@@ -77,11 +140,9 @@ func Load() error {
 	}
 	AppConfig.DBPassword = string(dbPassword)
 
-	oauthClientSecret, err := os.ReadFile(AppConfig.OAuth2ClientSecretFile)
-	if err != nil {
-		return fmt.Errorf("failed to load OAuth2 client secret file: %w", err)
+	if err := loadOIDCProviders(); err != nil {
+		return err
 	}
-	AppConfig.OAuth2ClientSecret = string(oauthClientSecret)
 
 	sessionsKey, err := os.ReadFile(AppConfig.SessionsKeyFile)
 	if err != nil {
@@ -97,3 +158,104 @@ func Load() error {
 
 	return nil
 }
+
+// loadOIDCProviders reads the providers file if there is one, or sets up
+// a single provider from the environment if there isn't, then fills in
+// defaults and client secrets.
+func loadOIDCProviders() error {
+	if AppConfig.OIDCProvidersFile != "" {
+		providers, err := os.ReadFile(AppConfig.OIDCProvidersFile)
+		if err != nil {
+			return fmt.Errorf("failed to load OIDC providers file: %w", err)
+		}
+		if err := json.Unmarshal(providers, &AppConfig.OIDCProviders); err != nil {
+			return fmt.Errorf("failed to parse OIDC providers file: %w", err)
+		}
+		if len(AppConfig.OIDCProviders) == 0 {
+			return fmt.Errorf("OIDC providers file doesn't have any providers")
+		}
+	} else {
+		if AppConfig.OIDCIssuerURL == "" || AppConfig.OAuth2ClientID == "" || AppConfig.OAuth2ClientSecretFile == "" {
+			return fmt.Errorf("either QUEUE_OIDC_PROVIDERS_FILE or all of QUEUE_OIDC_ISSUER_URL, QUEUE_OAUTH2_CLIENT_ID, and QUEUE_OAUTH2_CLIENT_SECRET_FILE must be set")
+		}
+
+		// The single provider has always been trusted for groups
+		trustGroups := true
+		AppConfig.OIDCProviders = []*OIDCProvider{{
+			Name:             "default",
+			IssuerURL:        AppConfig.OIDCIssuerURL,
+			ClientID:         AppConfig.OAuth2ClientID,
+			ClientSecretFile: AppConfig.OAuth2ClientSecretFile,
+			Scopes:           []string{"openid", "email", "profile", "eduperson_entitlement"},
+			TrustGroups:      &trustGroups,
+		}}
+	}
+
+	names := make(map[string]struct{})
+	domains := make(map[string]string)
+	for _, p := range AppConfig.OIDCProviders {
+		p.Name = strings.TrimSpace(p.Name)
+		if p.Name == "" || p.IssuerURL == "" || p.ClientID == "" || p.ClientSecretFile == "" {
+			return fmt.Errorf("OIDC provider %q needs a name, issuer_url, client_id, and client_secret_file", p.Name)
+		}
+		if _, ok := names[p.Name]; ok {
+			return fmt.Errorf("OIDC provider %q is configured more than once", p.Name)
+		}
+		names[p.Name] = struct{}{}
+
+		if p.DisplayName == "" {
+			p.DisplayName = p.Name
+		}
+		if p.RedirectURI == "" {
+			p.RedirectURI = AppConfig.OAuth2RedirectURI
+		}
+		if len(p.Scopes) == 0 {
+			p.Scopes = []string{"openid", "email", "profile"}
+		}
+		if p.UsePKCE == nil {
+			p.UsePKCE = &AppConfig.OAuth2UsePKCE
+		}
+		if p.TrustGroups == nil {
+			p.TrustGroups = new(bool)
+		}
+		if AppConfig.OIDCProvidersFile != "" {
+			if len(p.AllowedDomains) == 0 {
+				p.AllowedDomains = append([]string(nil), AppConfig.ValidDomains...)
+			}
+			for i, domain := range p.AllowedDomains {
+				domain = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(domain), "@"))
+				if domain == "" {
+					return fmt.Errorf("OIDC provider %q has an empty allowed domain", p.Name)
+				}
+				if other, ok := domains[domain]; ok {
+					return fmt.Errorf("OIDC providers %q and %q both allow %s; set allowed_domains so each domain has one provider", other, p.Name, domain)
+				}
+				domains[domain] = p.Name
+				p.AllowedDomains[i] = domain
+			}
+		}
+		if p.Claims.Email == "" {
+			p.Claims.Email = "email"
+		}
+		if p.Claims.EmailVerified == "" {
+			p.Claims.EmailVerified = "email_verified"
+		}
+		if p.Claims.Name == "" {
+			p.Claims.Name = "name"
+		}
+		if p.Claims.GivenName == "" {
+			p.Claims.GivenName = "given_name"
+		}
+		if p.Claims.Groups == "" {
+			p.Claims.Groups = "groups"
+		}
+
+		clientSecret, err := os.ReadFile(p.ClientSecretFile)
+		if err != nil {
+			return fmt.Errorf("failed to load OAuth2 client secret file for OIDC provider %q: %w", p.Name, err)
+		}
+		p.ClientSecret = string(clientSecret)
+	}
+
+	return nil
+}
@@ -2,6 +2,8 @@ package db
 
 import (
 	"context"
+	"database/sql"
+	"errors"
 	"fmt"
 	"time"
 
@@ -9,11 +11,14 @@ import (
 	"github.com/segmentio/ksuid"
 )
 
+// The format in which dates are passed to Postgres.
+const dateFormat = "2006-01-02"
+
 func (s *Server) GetAppointment(ctx context.Context, appointment ksuid.KSUID) (*api.AppointmentSlot, error) {
 	tx := getTransaction(ctx)
 	var a api.AppointmentSlot
 	err := tx.GetContext(ctx, &a,
-		"SELECT id, queue, staff_email, student_email, scheduled_time, timeslot, duration, name, location, description, map_x, map_y FROM appointment_slots WHERE id=$1",
+		"SELECT id, queue, staff_email, student_email, scheduled_time, timeslot, duration, name, location, description, map_x, map_y, outcome, requested_staff, appointment_type FROM appointment_slots WHERE id=$1",
 		appointment,
 	)
 	return &a, err
@@ -23,7 +28,7 @@ func (s *Server) GetAppointments(ctx context.Context, queue ksuid.KSUID, from, t
 	tx := getTransaction(ctx)
 	appointments := make([]*api.AppointmentSlot, 0)
 	err := tx.SelectContext(ctx, &appointments,
-		"SELECT id, queue, staff_email, student_email, scheduled_time, timeslot, duration, name, location, description, map_x, map_y FROM appointment_slots WHERE queue=$1 AND scheduled_time >= $2 AND scheduled_time <= $3 ORDER BY id",
+		"SELECT id, queue, staff_email, student_email, scheduled_time, timeslot, duration, name, location, description, map_x, map_y, outcome, requested_staff, appointment_type FROM appointment_slots WHERE queue=$1 AND scheduled_time >= $2 AND scheduled_time <= $3 ORDER BY id",
 		queue, from, to,
 	)
 	return appointments, err
@@ -43,7 +48,7 @@ func (s *Server) GetAppointmentsForUser(ctx context.Context, queue ksuid.KSUID,
 	tx := getTransaction(ctx)
 	appointments := make([]*api.AppointmentSlot, 0)
 	err := tx.SelectContext(ctx, &appointments,
-		"SELECT id, queue, student_email, scheduled_time, timeslot, duration, name, location, description, map_x, map_y FROM appointment_slots WHERE queue=$1 AND student_email=$2 AND scheduled_time >= $3 AND scheduled_time <= $4 ORDER BY id",
+		"SELECT id, queue, student_email, scheduled_time, timeslot, duration, name, location, description, map_x, map_y, outcome, requested_staff, appointment_type FROM appointment_slots WHERE queue=$1 AND student_email=$2 AND scheduled_time >= $3 AND scheduled_time <= $4 ORDER BY id",
 		queue, email, from, to,
 	)
 	return appointments, err
@@ -73,6 +78,46 @@ func (s *Server) GetAppointmentScheduleForDay(ctx context.Context, queue ksuid.K
 	return &schedule, err
 }
 
+// GetAppointmentScheduleForDate gets the schedule in effect on date: the
+// override for that date if there is one, or the weekly schedule otherwise.
+func (s *Server) GetAppointmentScheduleForDate(ctx context.Context, queue ksuid.KSUID, date time.Time) (*api.AppointmentSchedule, error) {
+	tx := getTransaction(ctx)
+	var schedule api.AppointmentSchedule
+	err := tx.GetContext(ctx, &schedule,
+		"SELECT queue, date, duration, padding, schedule FROM appointment_schedule_overrides WHERE queue=$1 AND date=$2",
+		queue, date.Format(dateFormat),
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		return s.GetAppointmentScheduleForDay(ctx, queue, int(date.Weekday()))
+	} else if err != nil {
+		return nil, err
+	}
+
+	// Dates come back as midnight UTC; we want midnight here.
+	start, _ := api.DateBounds(time.Date(schedule.Date.Year(), schedule.Date.Month(), schedule.Date.Day(), 12, 0, 0, 0, time.Local))
+	schedule.Date = &start
+	schedule.Day = start.Weekday()
+	return &schedule, nil
+}
+
+func (s *Server) SetAppointmentScheduleOverride(ctx context.Context, queue ksuid.KSUID, date time.Time, schedule *api.AppointmentSchedule) error {
+	tx := getTransaction(ctx)
+	_, err := tx.ExecContext(ctx,
+		"INSERT INTO appointment_schedule_overrides (queue, date, duration, padding, schedule) VALUES ($1, $2, $3, $4, $5) ON CONFLICT (queue, date) DO UPDATE SET duration=EXCLUDED.duration, padding=EXCLUDED.padding, schedule=EXCLUDED.schedule",
+		queue, date.Format(dateFormat), schedule.Duration, schedule.Padding, schedule.Schedule,
+	)
+	return err
+}
+
+func (s *Server) RemoveAppointmentScheduleOverride(ctx context.Context, queue ksuid.KSUID, date time.Time) error {
+	tx := getTransaction(ctx)
+	_, err := tx.ExecContext(ctx,
+		"DELETE FROM appointment_schedule_overrides WHERE queue=$1 AND date=$2",
+		queue, date.Format(dateFormat),
+	)
+	return err
+}
+
 func (s *Server) AddAppointmentSchedule(ctx context.Context, queue ksuid.KSUID, day int, schedule *api.AppointmentSchedule) error {
 	tx := getTransaction(ctx)
 	_, err := tx.ExecContext(ctx,
@@ -95,15 +140,15 @@ func (s *Server) GetAppointmentsByTimeslot(ctx context.Context, queue ksuid.KSUI
 	tx := getTransaction(ctx)
 	appointments := make([]*api.AppointmentSlot, 0)
 	err := tx.SelectContext(ctx, &appointments,
-		"SELECT id, queue, staff_email, student_email, scheduled_time, timeslot, duration, name, location, description, map_x, map_y FROM appointment_slots WHERE queue=$1 AND timeslot=$2 AND scheduled_time >= $3 AND scheduled_time <= $4 ORDER BY id",
+		"SELECT id, queue, staff_email, student_email, scheduled_time, timeslot, duration, name, location, description, map_x, map_y, outcome, requested_staff, appointment_type FROM appointment_slots WHERE queue=$1 AND timeslot=$2 AND scheduled_time >= $3 AND scheduled_time <= $4 ORDER BY id",
 		queue, timeslot, from, to,
 	)
 	return appointments, err
 }
 
-func (s *Server) ClaimTimeslot(ctx context.Context, queue ksuid.KSUID, day, timeslot int, email string) (*api.AppointmentSlot, error) {
+func (s *Server) ClaimTimeslot(ctx context.Context, queue ksuid.KSUID, date time.Time, timeslot int, email string) (*api.AppointmentSlot, error) {
 	tx := getTransaction(ctx)
-	schedule, err := s.GetAppointmentScheduleForDay(ctx, queue, day)
+	schedule, err := s.GetAppointmentScheduleForDate(ctx, queue, date)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get appointment schedule: %w", err)
 	}
@@ -112,28 +157,40 @@ func (s *Server) ClaimTimeslot(ctx context.Context, queue ksuid.KSUID, day, time
 		return nil, fmt.Errorf("attempted to claim slot %d out of %d slots", timeslot, len(schedule.Schedule))
 	}
 
-	from, to := api.WeekdayBounds(day)
-	slots, err := s.GetAppointmentsByTimeslot(ctx, queue, from, to, timeslot)
+	from, to := api.DateBounds(date)
+	appointments, err := s.GetAppointments(ctx, queue, from, to)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get appointment slots: %w", err)
+		return nil, fmt.Errorf("failed to get appointments: %w", err)
+	}
+
+	if api.StaffBusy(schedule, appointments, email, ksuid.Nil, timeslot, 1, false) {
+		return nil, fmt.Errorf("%s already has an appointment during timeslot %d", email, timeslot)
 	}
 
 	// Check if there's an existing slot without a staff member; if so,
-	// prefer taking that one first
-	for _, slot := range slots {
-		if slot.StaffEmail == nil {
-			var a api.AppointmentSlot
-			err := tx.GetContext(ctx, &a,
-				"UPDATE appointment_slots SET staff_email=$1 WHERE id=$2 RETURNING *",
-				email, slot.ID,
-			)
-			return &a, err
+	// prefer taking that one first. Students who asked for someone else
+	// are left for them, and longer appointments need the staff member
+	// to be free for all of it.
+	for _, slot := range appointments {
+		if slot.Timeslot != timeslot || slot.StaffEmail != nil || (slot.RequestedStaff != nil && *slot.RequestedStaff != email) {
+			continue
+		}
+
+		if api.StaffBusy(schedule, appointments, email, slot.ID, timeslot, api.TimeslotSpan(slot.Duration, schedule.Duration), false) {
+			continue
 		}
+
+		var a api.AppointmentSlot
+		err := tx.GetContext(ctx, &a,
+			"UPDATE appointment_slots SET staff_email=$1 WHERE id=$2 RETURNING *",
+			email, slot.ID,
+		)
+		return &a, err
 	}
 
 	// If we made it here, there aren't any existing slots without a
 	// staff member. Now check if there are any open spots
-	open := int(schedule.Schedule[timeslot]-'0') - len(slots)
+	open := int(schedule.Schedule[timeslot]-'0') - api.TimeslotUsage(schedule, appointments, false)[timeslot]
 	if open < 1 {
 		return nil, fmt.Errorf("no spots open to claim at timeslot %d", timeslot)
 	}
@@ -141,7 +198,7 @@ func (s *Server) ClaimTimeslot(ctx context.Context, queue ksuid.KSUID, day, time
 	// There's room for another appointment at the current timeslot.
 	// Let's claim it.
 	id := ksuid.New()
-	appointmentTime := api.TimeslotToTime(day, timeslot, schedule.Duration)
+	appointmentTime := api.DateTimeslotToTime(date, timeslot, schedule.Duration)
 	var a api.AppointmentSlot
 	err = tx.GetContext(ctx, &a,
 		"INSERT INTO appointment_slots (id, queue, staff_email, scheduled_time, timeslot, duration) VALUES ($1, $2, $3, $4, $5, $6) RETURNING *",
@@ -177,29 +234,51 @@ func (s *Server) UnclaimAppointment(ctx context.Context, appointment ksuid.KSUID
 
 func (s *Server) SignupForAppointment(ctx context.Context, queue ksuid.KSUID, appointment *api.AppointmentSlot) (*api.AppointmentSlot, error) {
 	tx := getTransaction(ctx)
-	start, end := api.WeekdayBounds(int(appointment.ScheduledTime.Local().Weekday()))
-	var newAppointment api.AppointmentSlot
-	appointments, err := s.GetAppointmentsByTimeslot(ctx, queue, start, end, appointment.Timeslot)
+	start, end := api.DateBounds(appointment.ScheduledTime)
+	schedule, err := s.GetAppointmentScheduleForDate(ctx, queue, start)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get appointment schedule: %w", err)
+	}
+
+	appointments, err := s.GetAppointments(ctx, queue, start, end)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get appointments for timeslot: %w", err)
+		return nil, fmt.Errorf("failed to get appointments: %w", err)
 	}
 
-	// Check if an appointment without a student already exists
+	// Check if an appointment without a student already exists, claimed
+	// by the requested staff member if there is one. A staff member has
+	// to be free for the whole appointment.
+	span := api.TimeslotSpan(appointment.Duration, schedule.Duration)
+	var newAppointment api.AppointmentSlot
 	for _, a := range appointments {
-		if a.StudentEmail == nil {
-			err = tx.GetContext(ctx, &newAppointment,
-				"UPDATE appointment_slots SET student_email=$1, name=$2, location=$3, description=$4, map_x=$5, map_y=$6 WHERE id=$7 RETURNING id, queue, student_email, staff_email, scheduled_time, timeslot, duration, name, location, description, map_x, map_y",
-				*appointment.StudentEmail, *appointment.Name, *appointment.Location, *appointment.Description, *appointment.MapX, *appointment.MapY, a.ID,
-			)
-			return &newAppointment, err
+		if a.Timeslot != appointment.Timeslot || a.StudentEmail != nil {
+			continue
+		}
+
+		if appointment.RequestedStaff != nil && (a.StaffEmail == nil || *a.StaffEmail != *appointment.RequestedStaff) {
+			continue
+		}
+
+		if a.StaffEmail != nil && api.StaffBusy(schedule, appointments, *a.StaffEmail, a.ID, a.Timeslot, span, true) {
+			continue
 		}
+
+		err = tx.GetContext(ctx, &newAppointment,
+			"UPDATE appointment_slots SET student_email=$1, name=$2, location=$3, description=$4, map_x=$5, map_y=$6, requested_staff=$7, duration=$8, appointment_type=$9, reminded_at=NULL, outcome=NULL WHERE id=$10 RETURNING id, queue, student_email, staff_email, scheduled_time, timeslot, duration, name, location, description, map_x, map_y, outcome, requested_staff, appointment_type",
+			*appointment.StudentEmail, *appointment.Name, *appointment.Location, *appointment.Description, *appointment.MapX, *appointment.MapY, appointment.RequestedStaff, appointment.Duration, appointment.AppointmentType, a.ID,
+		)
+		return &newAppointment, err
+	}
+
+	if appointment.RequestedStaff != nil {
+		return nil, fmt.Errorf("%s has no open slot at timeslot %d", *appointment.RequestedStaff, appointment.Timeslot)
 	}
 
 	// If not, insert a new appointment
 	id := ksuid.New()
 	err = tx.GetContext(ctx, &newAppointment,
-		"INSERT INTO appointment_slots (id, queue, student_email, scheduled_time, timeslot, duration, name, location, description, map_x, map_y) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11) RETURNING id, queue, student_email, scheduled_time, timeslot, duration, name, location, description, map_x, map_y",
-		id, appointment.Queue, appointment.StudentEmail, appointment.ScheduledTime, appointment.Timeslot, appointment.Duration, appointment.Name, appointment.Location, appointment.Description, appointment.MapX, appointment.MapY,
+		"INSERT INTO appointment_slots (id, queue, student_email, scheduled_time, timeslot, duration, name, location, description, map_x, map_y, appointment_type) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12) RETURNING id, queue, student_email, scheduled_time, timeslot, duration, name, location, description, map_x, map_y, appointment_type",
+		id, appointment.Queue, appointment.StudentEmail, appointment.ScheduledTime, appointment.Timeslot, appointment.Duration, appointment.Name, appointment.Location, appointment.Description, appointment.MapX, appointment.MapY, appointment.AppointmentType,
 	)
 	return &newAppointment, err
 }
@@ -230,11 +309,191 @@ func (s *Server) RemoveAppointmentSignup(ctx context.Context, appointment ksuid.
 	}
 
 	// If a staff member has a claim on this appointment, don't delete it,
-	// just set the student fields to null
+	// just set the student fields to null and shrink it back to a single
+	// timeslot
+	schedule, err := s.GetAppointmentScheduleForDate(ctx, a.Queue, a.ScheduledTime)
+	if err != nil {
+		return false, nil, fmt.Errorf("failed to get appointment schedule: %w", err)
+	}
+
 	var newAppt api.AppointmentSlot
 	err = tx.GetContext(ctx, &newAppt,
-		"UPDATE appointment_slots SET student_email=NULL, name=NULL, location=NULL, description=NULL, map_x=NULL, map_y=NULL WHERE id=$1 RETURNING *",
-		appointment,
+		"UPDATE appointment_slots SET student_email=NULL, name=NULL, location=NULL, description=NULL, map_x=NULL, map_y=NULL, requested_staff=NULL, duration=$1, appointment_type=NULL, outcome=NULL, reminded_at=NULL WHERE id=$2 RETURNING *",
+		schedule.Duration, appointment,
 	)
 	return false, &newAppt, err
 }
+
+// MarkAppointmentReminders marks every signed up appointment starting
+// within its queue's reminder lead time as reminded, and returns the ones
+// that hadn't been already.
+func (s *Server) MarkAppointmentReminders(ctx context.Context) ([]*api.AppointmentSlot, error) {
+	tx := getTransaction(ctx)
+	appointments := make([]*api.AppointmentSlot, 0)
+	err := tx.SelectContext(ctx, &appointments,
+		`UPDATE appointment_slots a SET reminded_at=NOW() FROM queues q
+		WHERE a.queue=q.id AND q.active AND q.appointment_reminder > 0 AND a.student_email IS NOT NULL AND a.reminded_at IS NULL
+		AND a.scheduled_time > NOW() AND a.scheduled_time <= NOW() + q.appointment_reminder * INTERVAL '1 minute'
+		RETURNING a.id, a.queue, a.staff_email, a.student_email, a.scheduled_time, a.timeslot, a.duration, a.name, a.location, a.description, a.map_x, a.map_y`,
+	)
+	return appointments, err
+}
+
+func (s *Server) SetAppointmentOutcome(ctx context.Context, appointment ksuid.KSUID, outcome *api.AppointmentOutcome) error {
+	tx := getTransaction(ctx)
+	_, err := tx.ExecContext(ctx,
+		"UPDATE appointment_slots SET outcome=$1 WHERE id=$2",
+		outcome, appointment,
+	)
+	return err
+}
+
+// GetNoShows gets the scheduled times of every appointment the user didn't
+// show up to on the queue, most recent first.
+func (s *Server) GetNoShows(ctx context.Context, queue ksuid.KSUID, email string) ([]time.Time, error) {
+	tx := getTransaction(ctx)
+	noShows := make([]time.Time, 0)
+	err := tx.SelectContext(ctx, &noShows,
+		"SELECT scheduled_time FROM appointment_slots WHERE queue=$1 AND student_email=$2 AND outcome=$3 ORDER BY scheduled_time DESC",
+		queue, email, api.OutcomeNoShow,
+	)
+	return noShows, err
+}
+
+// GetWaitlist gets the waitlist entries for timeslots between from and to,
+// in the order they were added.
+func (s *Server) GetWaitlist(ctx context.Context, queue ksuid.KSUID, from, to time.Time) ([]*api.AppointmentSlot, error) {
+	tx := getTransaction(ctx)
+	waitlist := make([]*api.AppointmentSlot, 0)
+	err := tx.SelectContext(ctx, &waitlist,
+		"SELECT id, queue, student_email, scheduled_time, timeslot, duration, name, location, description, map_x, map_y, appointment_type FROM appointment_waitlist WHERE queue=$1 AND scheduled_time >= $2 AND scheduled_time <= $3 ORDER BY id",
+		queue, from, to,
+	)
+	return waitlist, err
+}
+
+func (s *Server) GetWaitlistForUser(ctx context.Context, queue ksuid.KSUID, from, to time.Time, email string) ([]*api.AppointmentSlot, error) {
+	tx := getTransaction(ctx)
+	waitlist := make([]*api.AppointmentSlot, 0)
+	err := tx.SelectContext(ctx, &waitlist,
+		"SELECT id, queue, student_email, scheduled_time, timeslot, duration, name, location, description, map_x, map_y, appointment_type FROM appointment_waitlist WHERE queue=$1 AND student_email=$2 AND scheduled_time >= $3 AND scheduled_time <= $4 ORDER BY id",
+		queue, email, from, to,
+	)
+	return waitlist, err
+}
+
+func (s *Server) AddWaitlistEntry(ctx context.Context, queue ksuid.KSUID, entry *api.AppointmentSlot) (*api.AppointmentSlot, error) {
+	tx := getTransaction(ctx)
+	var newEntry api.AppointmentSlot
+	err := tx.GetContext(ctx, &newEntry,
+		"INSERT INTO appointment_waitlist (id, queue, student_email, scheduled_time, timeslot, duration, name, location, description, map_x, map_y, appointment_type) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12) RETURNING id, queue, student_email, scheduled_time, timeslot, duration, name, location, description, map_x, map_y, appointment_type",
+		ksuid.New(), queue, entry.StudentEmail, entry.ScheduledTime, entry.Timeslot, entry.Duration, entry.Name, entry.Location, entry.Description, entry.MapX, entry.MapY, entry.AppointmentType,
+	)
+	return &newEntry, err
+}
+
+func (s *Server) RemoveWaitlistEntry(ctx context.Context, entry ksuid.KSUID) error {
+	tx := getTransaction(ctx)
+	_, err := tx.ExecContext(ctx,
+		"DELETE FROM appointment_waitlist WHERE id=$1",
+		entry,
+	)
+	return err
+}
+
+func (s *Server) GetAppointmentTypes(ctx context.Context, queue ksuid.KSUID) ([]*api.AppointmentType, error) {
+	tx := getTransaction(ctx)
+	types := make([]*api.AppointmentType, 0)
+	err := tx.SelectContext(ctx, &types,
+		"SELECT id, queue, name, duration, capacity FROM appointment_types WHERE queue=$1 ORDER BY duration, id",
+		queue,
+	)
+	return types, err
+}
+
+func (s *Server) GetAppointmentType(ctx context.Context, appointmentType ksuid.KSUID) (*api.AppointmentType, error) {
+	tx := getTransaction(ctx)
+	var t api.AppointmentType
+	err := tx.GetContext(ctx, &t,
+		"SELECT id, queue, name, duration, capacity FROM appointment_types WHERE id=$1",
+		appointmentType,
+	)
+	return &t, err
+}
+
+func (s *Server) AddAppointmentType(ctx context.Context, queue ksuid.KSUID, appointmentType *api.AppointmentType) (*api.AppointmentType, error) {
+	tx := getTransaction(ctx)
+	var t api.AppointmentType
+	err := tx.GetContext(ctx, &t,
+		"INSERT INTO appointment_types (id, queue, name, duration, capacity) VALUES ($1, $2, $3, $4, $5) RETURNING id, queue, name, duration, capacity",
+		ksuid.New(), queue, appointmentType.Name, appointmentType.Duration, appointmentType.Capacity,
+	)
+	return &t, err
+}
+
+func (s *Server) UpdateAppointmentType(ctx context.Context, appointmentType ksuid.KSUID, values *api.AppointmentType) error {
+	tx := getTransaction(ctx)
+	_, err := tx.ExecContext(ctx,
+		"UPDATE appointment_types SET name=$1, duration=$2, capacity=$3 WHERE id=$4",
+		values.Name, values.Duration, values.Capacity, appointmentType,
+	)
+	return err
+}
+
+func (s *Server) RemoveAppointmentType(ctx context.Context, appointmentType ksuid.KSUID) error {
+	tx := getTransaction(ctx)
+	_, err := tx.ExecContext(ctx,
+		"DELETE FROM appointment_types WHERE id=$1",
+		appointmentType,
+	)
+	return err
+}
+
+// RescheduleAppointment gives up the student's booking of appointment and
+// books them into target in its place.
+func (s *Server) RescheduleAppointment(ctx context.Context, appointment ksuid.KSUID, target *api.AppointmentSlot) (newAppointment *api.AppointmentSlot, deleted bool, oldSlot *api.AppointmentSlot, err error) {
+	deleted, oldSlot, err = s.RemoveAppointmentSignup(ctx, appointment)
+	if err != nil {
+		return nil, false, nil, fmt.Errorf("failed to remove old appointment: %w", err)
+	}
+
+	newAppointment, err = s.SignupForAppointment(ctx, target.Queue, target)
+	if err != nil {
+		return nil, false, nil, fmt.Errorf("failed to sign up for new appointment: %w", err)
+	}
+
+	return newAppointment, deleted, oldSlot, nil
+}
+
+// ClaimTimeslots claims each of timeslots on date in turn. A timeslot that
+// can't be claimed is rolled back on its own and reported in failed, so
+// it doesn't take the rest of the claims down with it.
+func (s *Server) ClaimTimeslots(ctx context.Context, queue ksuid.KSUID, date time.Time, timeslots []int, email string) (claimed []*api.AppointmentSlot, failed map[int]error, err error) {
+	tx := getTransaction(ctx)
+	claimed = make([]*api.AppointmentSlot, 0, len(timeslots))
+	failed = make(map[int]error)
+	for _, timeslot := range timeslots {
+		_, err = tx.ExecContext(ctx, "SAVEPOINT claim_timeslot")
+		if err != nil {
+			return nil, nil, err
+		}
+
+		a, claimErr := s.ClaimTimeslot(ctx, queue, date, timeslot, email)
+		if claimErr != nil {
+			failed[timeslot] = claimErr
+			_, err = tx.ExecContext(ctx, "ROLLBACK TO SAVEPOINT claim_timeslot")
+			if err != nil {
+				return nil, nil, err
+			}
+			continue
+		}
+
+		_, err = tx.ExecContext(ctx, "RELEASE SAVEPOINT claim_timeslot")
+		if err != nil {
+			return nil, nil, err
+		}
+		claimed = append(claimed, a)
+	}
+
+	return claimed, failed, nil
+}
@@ -2,25 +2,44 @@ package db
 
 import (
 	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"strings"
 
 	"github.com/CarsonHoffman/office-hours-queue/server/api"
 	"github.com/lib/pq"
 	"github.com/segmentio/ksuid"
 )
 
-func (s *Server) GetCourses(ctx context.Context) ([]*api.Course, error) {
+// termConditions maps each term filter onto the condition courses
+// must satisfy to be included. A course without a term is always
+// considered current.
+var termConditions = map[api.TermFilter]string{
+	api.TermAll:      "TRUE",
+	api.TermCurrent:  "(term_start IS NULL OR term_start <= NOW()) AND (term_end IS NULL OR term_end > NOW())",
+	api.TermPast:     "term_end <= NOW()",
+	api.TermUpcoming: "term_start > NOW()",
+}
+
+func (s *Server) GetCourses(ctx context.Context, term api.TermFilter) ([]*api.Course, error) {
 	tx := getTransaction(ctx)
+	condition, ok := termConditions[term]
+	if !ok {
+		return nil, fmt.Errorf("unknown term filter %q", term)
+	}
+
 	courses := make([]*api.Course, 0)
 	err := tx.SelectContext(ctx, &courses,
-		"SELECT id, short_name, full_name FROM courses ORDER BY id",
+		"SELECT id, short_name, full_name, term_start, term_end FROM courses WHERE archived_at IS NULL AND "+condition+" ORDER BY id",
 	)
 
 	if err != nil {
 		return nil, fmt.Errorf("failed to get courses: %w", err)
 	}
 
-	qStmt, err := tx.Preparex("SELECT id, course, type, name, location, map, active FROM queues WHERE active AND course=$1 ORDER BY id")
+	qStmt, err := tx.Preparex("SELECT id, course, type, name, location, map, active, sections FROM queues WHERE active AND course=$1 ORDER BY id")
 	if err != nil {
 		return nil, fmt.Errorf("failed to set up queues statement: %w", err)
 	}
@@ -37,19 +56,83 @@ func (s *Server) GetCourses(ctx context.Context) ([]*api.Course, error) {
 	return courses, nil
 }
 
+// GetArchivedCourses gets the archived courses matching the term filter,
+// along with all of their queues.
+func (s *Server) GetArchivedCourses(ctx context.Context, term api.TermFilter) ([]*api.Course, error) {
+	tx := getTransaction(ctx)
+	condition, ok := termConditions[term]
+	if !ok {
+		return nil, fmt.Errorf("unknown term filter %q", term)
+	}
+
+	courses := make([]*api.Course, 0)
+	err := tx.SelectContext(ctx, &courses,
+		"SELECT id, short_name, full_name, term_start, term_end, archived_at FROM courses WHERE archived_at IS NOT NULL AND "+condition+" ORDER BY id",
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get courses: %w", err)
+	}
+
+	for _, course := range courses {
+		course.Queues, err = s.GetQueuesWithArchived(ctx, course.ID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get queues for course %s: %w", course.ID, err)
+		}
+	}
+
+	return courses, nil
+}
+
 func (s *Server) GetCourse(ctx context.Context, id ksuid.KSUID) (*api.Course, error) {
 	tx := getTransaction(ctx)
 	var course api.Course
 	err := tx.GetContext(ctx, &course,
-		"SELECT id, short_name, full_name FROM courses WHERE id=$1",
+		"SELECT id, short_name, full_name, term_start, term_end, archived_at FROM courses WHERE id=$1",
 		id,
 	)
 	return &course, err
 }
 
-func (s *Server) GetAdminCourses(ctx context.Context, email string) ([]string, error) {
+// GetCourseOverviews gets every course, including archived ones, along
+// with their queues and staff.
+func (s *Server) GetCourseOverviews(ctx context.Context) ([]*api.CourseOverview, error) {
 	tx := getTransaction(ctx)
+	courses := make([]*api.CourseOverview, 0)
+	err := tx.SelectContext(ctx, &courses,
+		"SELECT id, short_name, full_name, term_start, term_end, archived_at FROM courses ORDER BY id",
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get courses: %w", err)
+	}
+
+	for _, course := range courses {
+		course.Queues = make([]*api.Queue, 0)
+		err = tx.SelectContext(ctx, &course.Queues,
+			"SELECT id, course, type, name, location, map, active, archived_at FROM queues WHERE course=$1 ORDER BY id",
+			course.ID,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get queues for course %s: %w", course.ID, err)
+		}
+
+		course.Admins, err = s.GetCourseAdmins(ctx, course.ID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get admins for course %s: %w", course.ID, err)
+		}
 
+		course.Groups, err = s.GetCourseGroups(ctx, course.ID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get groups for course %s: %w", course.ID, err)
+		}
+	}
+
+	return courses, nil
+}
+
+// GetAdminCourses gets the courses the user is on the staff of, either
+// by email or through one of the groups in their session.
+func (s *Server) GetAdminCourses(ctx context.Context, email string) ([]string, error) {
+	tx := getTransaction(ctx)
 	isSiteAdmin, err := s.SiteAdmin(ctx, email)
 	if err != nil {
 		return nil, fmt.Errorf("failed to check site admin status: %w", err)
@@ -64,9 +147,10 @@ func (s *Server) GetAdminCourses(ctx context.Context, email string) ([]string, e
 		return courses, err
 	}
 
+	groups, _ := ctx.Value(api.GroupsContextKey).([]string)
 	err = tx.SelectContext(ctx, &courses,
-		"SELECT course FROM course_admins WHERE email=$1",
-		email,
+		"SELECT course FROM course_admins WHERE email=$1 UNION SELECT course FROM course_groups WHERE group_name=ANY($2)",
+		email, pq.Array(groups),
 	)
 	return courses, err
 }
@@ -81,44 +165,175 @@ func (s *Server) GetQueues(ctx context.Context, course ksuid.KSUID) ([]*api.Queu
 	return queues, err
 }
 
+// GetAllQueues gets all of a course's queues, including inactive ones
+// but not archived ones.
+func (s *Server) GetAllQueues(ctx context.Context, course ksuid.KSUID) ([]*api.Queue, error) {
+	tx := getTransaction(ctx)
+	queues := make([]*api.Queue, 0)
+	err := tx.SelectContext(ctx, &queues,
+		"SELECT id, course, type, name, location, map, active FROM queues WHERE course=$1 AND archived_at IS NULL ORDER BY id",
+		course,
+	)
+	return queues, err
+}
+
+// GetQueuesWithArchived gets every one of a course's queues, including
+// inactive and archived ones.
+func (s *Server) GetQueuesWithArchived(ctx context.Context, course ksuid.KSUID) ([]*api.Queue, error) {
+	tx := getTransaction(ctx)
+	queues := make([]*api.Queue, 0)
+	err := tx.SelectContext(ctx, &queues,
+		"SELECT id, course, type, name, location, map, active, archived_at, sections FROM queues WHERE course=$1 ORDER BY id",
+		course,
+	)
+	return queues, err
+}
+
 func (s *Server) CourseAdmin(ctx context.Context, course ksuid.KSUID, email string) (bool, error) {
+	role, err := s.CourseRole(ctx, course, email)
+	return role != api.RoleNone, err
+}
+
+// CourseRole gets the user's role in the course. A role given to the
+// user's email takes precedence; otherwise they get the highest role of
+// any of the course's groups in their session. Site admins are
+// instructors in every course.
+func (s *Server) CourseRole(ctx context.Context, course ksuid.KSUID, email string) (api.CourseRole, error) {
 	tx := getTransaction(ctx)
-	var n int
-	err := tx.GetContext(ctx, &n,
-		"SELECT COUNT(*) FROM course_admins WHERE course=$1 AND email=$2",
+	var role api.CourseRole
+	err := tx.GetContext(ctx, &role,
+		"SELECT role FROM course_admins WHERE course=$1 AND email=$2",
 		course, email,
 	)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return api.RoleNone, err
+	}
+
+	if role != api.RoleNone {
+		return role, nil
+	}
+
+	siteAdmin, err := s.SiteAdmin(ctx, email)
 	if err != nil {
-		return false, err
+		return api.RoleNone, err
 	}
 
-	if n > 0 {
-		return true, nil
+	if siteAdmin {
+		return api.RoleInstructor, nil
+	}
+
+	groups, ok := ctx.Value(api.GroupsContextKey).([]string)
+	if !ok || len(groups) == 0 {
+		return api.RoleNone, nil
+	}
+
+	var groupRoles []api.CourseRole
+	err = tx.SelectContext(ctx, &groupRoles,
+		"SELECT role FROM course_groups WHERE course=$1 AND group_name=ANY($2)",
+		course, pq.Array(groups),
+	)
+	if err != nil {
+		return api.RoleNone, err
 	}
 
-	return s.SiteAdmin(ctx, email)
+	for _, r := range groupRoles {
+		if r.Outranks(role) {
+			role = r
+		}
+	}
+	return role, nil
 }
 
-func (s *Server) AddCourse(ctx context.Context, shortName, fullName string) (*api.Course, error) {
+func (s *Server) AddCourse(ctx context.Context, course *api.Course) (*api.Course, error) {
 	tx := getTransaction(ctx)
 	id := ksuid.New()
-	var course api.Course
-	err := tx.GetContext(ctx, &course,
-		"INSERT INTO courses (id, short_name, full_name) VALUES ($1, $2, $3) RETURNING id, short_name, full_name",
-		id, shortName, fullName,
+	var newCourse api.Course
+	err := tx.GetContext(ctx, &newCourse,
+		"INSERT INTO courses (id, short_name, full_name, term_start, term_end) VALUES ($1, $2, $3, $4, $5) RETURNING id, short_name, full_name, term_start, term_end",
+		id, course.ShortName, course.FullName, course.TermStart, course.TermEnd,
 	)
-	return &course, err
+	return &newCourse, err
 }
 
-func (s *Server) UpdateCourse(ctx context.Context, course ksuid.KSUID, shortName, fullName string) error {
+func (s *Server) UpdateCourse(ctx context.Context, course ksuid.KSUID, values *api.Course) error {
 	tx := getTransaction(ctx)
 	_, err := tx.ExecContext(ctx,
-		"UPDATE courses SET short_name=$1, full_name=$2 WHERE id=$3",
-		shortName, fullName, course,
+		"UPDATE courses SET short_name=$1, full_name=$2, term_start=$3, term_end=$4 WHERE id=$5",
+		values.ShortName, values.FullName, values.TermStart, values.TermEnd, course,
 	)
 	return err
 }
 
+// SyncTermQueues activates the queues of courses whose term has started
+// and deactivates those whose term has ended, returning the queues that
+// changed. Queues are only touched when their course crosses a term
+// boundary, so staff can still open or close them by hand in between.
+// Courses without a term and archived courses and queues are left alone.
+func (s *Server) SyncTermQueues(ctx context.Context) ([]*api.Queue, error) {
+	tx := getTransaction(ctx)
+	queues := make([]*api.Queue, 0)
+	err := tx.SelectContext(ctx, &queues,
+		`WITH crossed AS (
+			UPDATE courses c SET term_synced=t.in_term FROM (
+				SELECT id, (term_start IS NULL OR term_start <= NOW()) AND (term_end IS NULL OR term_end > NOW()) AS in_term
+				FROM courses WHERE (term_start IS NOT NULL OR term_end IS NOT NULL) AND archived_at IS NULL
+			) t WHERE c.id=t.id AND c.term_synced IS DISTINCT FROM t.in_term
+			RETURNING c.id, t.in_term
+		)
+		UPDATE queues q SET active=crossed.in_term FROM crossed
+		WHERE q.course=crossed.id AND q.archived_at IS NULL AND q.active!=crossed.in_term
+		RETURNING q.id, q.course, q.type, q.name, q.location, q.map, q.active`,
+	)
+	return queues, err
+}
+
+// ArchiveCourse hides a course and takes its queues down, keeping all of
+// their history.
+func (s *Server) ArchiveCourse(ctx context.Context, course ksuid.KSUID) error {
+	tx := getTransaction(ctx)
+	_, err := tx.ExecContext(ctx,
+		"UPDATE courses SET archived_at=COALESCE(archived_at, NOW()) WHERE id=$1",
+		course,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to archive course: %w", err)
+	}
+
+	// Queues remember whether they were active so restoring the course
+	// can put them back the way they were.
+	_, err = tx.ExecContext(ctx,
+		"UPDATE queues SET active_before_archive=active, active=FALSE WHERE course=$1 AND archived_at IS NULL",
+		course,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to deactivate queues: %w", err)
+	}
+	return nil
+}
+
+// RestoreCourse brings back an archived course. Its queues that weren't
+// archived on their own go back to being active if they were when the
+// course was archived, as long as inTerm is set.
+func (s *Server) RestoreCourse(ctx context.Context, course ksuid.KSUID, inTerm bool) error {
+	tx := getTransaction(ctx)
+	_, err := tx.ExecContext(ctx,
+		"UPDATE courses SET archived_at=NULL WHERE id=$1",
+		course,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to restore course: %w", err)
+	}
+
+	_, err = tx.ExecContext(ctx,
+		"UPDATE queues SET active=COALESCE(active_before_archive, FALSE) AND $1, active_before_archive=NULL WHERE course=$2 AND archived_at IS NULL",
+		inTerm, course,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to reactivate queues: %w", err)
+	}
+	return nil
+}
+
 func (s *Server) DeleteCourse(ctx context.Context, course ksuid.KSUID) error {
 	tx := getTransaction(ctx)
 	_, err := tx.ExecContext(ctx,
@@ -134,45 +349,66 @@ func (s *Server) AddQueue(ctx context.Context, course ksuid.KSUID, queue *api.Qu
 	var newQueue api.Queue
 	err := tx.GetContext(ctx, &newQueue,
 		"INSERT INTO queues (id, course, type, name, location, map, active) VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING id, course, type, name, location, map, active",
-		id, course, queue.Type, queue.Name, queue.Location, queue.Map, true,
+		id, course, queue.Type, queue.Name, queue.Location, queue.Map, queue.Active,
 	)
 	return &newQueue, err
 }
 
-func (s *Server) GetCourseAdmins(ctx context.Context, course ksuid.KSUID) ([]string, error) {
+func (s *Server) GetCourseAdmins(ctx context.Context, course ksuid.KSUID) ([]*api.CourseStaff, error) {
 	tx := getTransaction(ctx)
-	admins := make([]string, 0)
-	err := tx.SelectContext(ctx, &admins, "SELECT email FROM course_admins WHERE course=$1", course)
+	admins := make([]*api.CourseStaff, 0)
+	err := tx.SelectContext(ctx, &admins, "SELECT email, role FROM course_admins WHERE course=$1 ORDER BY email", course)
 	return admins, err
 }
 
-func (s *Server) AddCourseAdmins(ctx context.Context, course ksuid.KSUID, admins []string, overwrite bool) error {
+func (s *Server) AddCourseAdmins(ctx context.Context, course ksuid.KSUID, admins []*api.CourseStaff, overwrite bool) error {
 	tx := getTransaction(ctx)
 
 	if overwrite {
 		_, err := tx.Exec("DELETE FROM course_admins WHERE course=$1", course)
 		if err != nil {
-			tx.Rollback()
 			return fmt.Errorf("failed to delete existing admins: %w", err)
 		}
 	}
 
-	insert, err := tx.Prepare(pq.CopyIn("course_admins", "course", "email"))
+	for _, admin := range admins {
+		_, err := tx.Exec(
+			"INSERT INTO course_admins (course, email, role) VALUES ($1, $2, $3) ON CONFLICT (course, email) DO UPDATE SET role=EXCLUDED.role",
+			course, admin.Email, admin.Role,
+		)
+		if err != nil {
+			return fmt.Errorf("failed to insert %s into course %s admins: %w", admin.Email, course, err)
+		}
+	}
+
+	return nil
+}
+
+func (s *Server) GetCourseGroups(ctx context.Context, course ksuid.KSUID) ([]*api.CourseGroup, error) {
+	tx := getTransaction(ctx)
+	groups := make([]*api.CourseGroup, 0)
+	err := tx.SelectContext(ctx, &groups, "SELECT group_name, role FROM course_groups WHERE course=$1 ORDER BY group_name", course)
+	return groups, err
+}
+
+func (s *Server) UpdateCourseGroups(ctx context.Context, course ksuid.KSUID, groups []*api.CourseGroup) error {
+	tx := getTransaction(ctx)
+	_, err := tx.ExecContext(ctx, "DELETE FROM course_groups WHERE course=$1", course)
 	if err != nil {
-		return fmt.Errorf("failed to prepare insert statement: %w", err)
+		return fmt.Errorf("failed to delete existing groups: %w", err)
 	}
-	defer insert.Close()
 
-	for _, email := range admins {
-		_, err = insert.Exec(course, email)
+	for _, group := range groups {
+		_, err = tx.ExecContext(ctx,
+			"INSERT INTO course_groups (course, group_name, role) VALUES ($1, $2, $3) ON CONFLICT (course, group_name) DO UPDATE SET role=EXCLUDED.role",
+			course, group.Group, group.Role,
+		)
 		if err != nil {
-			tx.Rollback()
-			return fmt.Errorf("failed to insert %s into course %s admins: %w", email, course, err)
+			return fmt.Errorf("failed to insert group %s into course %s: %w", group.Group, course, err)
 		}
 	}
 
-	_, err = insert.Exec()
-	return err
+	return nil
 }
 
 func (s *Server) RemoveCourseAdmins(ctx context.Context, course ksuid.KSUID, admins []string) error {
@@ -187,3 +423,138 @@ func (s *Server) RemoveCourseAdmins(ctx context.Context, course ksuid.KSUID, adm
 
 	return nil
 }
+
+func (s *Server) CourseGuest(ctx context.Context, course ksuid.KSUID, email string) (bool, error) {
+	tx := getTransaction(ctx)
+	var n int
+	err := tx.GetContext(ctx, &n,
+		"SELECT COUNT(*) FROM course_guests WHERE course=$1 AND email=$2",
+		course, strings.ToLower(email),
+	)
+	return n > 0, err
+}
+
+// GetGuestCourses gets the courses whose guest list the user is on.
+func (s *Server) GetGuestCourses(ctx context.Context, email string) ([]string, error) {
+	tx := getTransaction(ctx)
+	courses := make([]string, 0)
+	err := tx.SelectContext(ctx, &courses,
+		"SELECT course FROM course_guests WHERE email=$1",
+		strings.ToLower(email),
+	)
+	return courses, err
+}
+
+func (s *Server) GetCourseGuests(ctx context.Context, course ksuid.KSUID) ([]string, error) {
+	tx := getTransaction(ctx)
+	guests := make([]string, 0)
+	err := tx.SelectContext(ctx, &guests, "SELECT email FROM course_guests WHERE course=$1 ORDER BY email", course)
+	return guests, err
+}
+
+func (s *Server) AddCourseGuests(ctx context.Context, course ksuid.KSUID, guests []string) error {
+	tx := getTransaction(ctx)
+	for _, email := range guests {
+		_, err := tx.ExecContext(ctx,
+			"INSERT INTO course_guests (course, email) VALUES ($1, $2) ON CONFLICT DO NOTHING",
+			course, email,
+		)
+		if err != nil {
+			return fmt.Errorf("failed to add guest %s to course %s: %w", email, course, err)
+		}
+	}
+	return nil
+}
+
+func (s *Server) RemoveCourseGuests(ctx context.Context, course ksuid.KSUID, guests []string) error {
+	tx := getTransaction(ctx)
+	_, err := tx.ExecContext(ctx,
+		"DELETE FROM course_guests WHERE course=$1 AND email=ANY($2)",
+		course, pq.Array(guests),
+	)
+	return err
+}
+
+// GetRosterSections gets the user's section in each course whose roster
+// they're on. Roster emails are kept lowercase, so every lookup lowercases
+// the email it's given.
+func (s *Server) GetRosterSections(ctx context.Context, email string) (map[ksuid.KSUID]string, error) {
+	tx := getTransaction(ctx)
+	var rows []struct {
+		Course  ksuid.KSUID `db:"course"`
+		Section string      `db:"section"`
+	}
+	err := tx.SelectContext(ctx, &rows, "SELECT course, section FROM roster WHERE email=lower($1)", email)
+	if err != nil {
+		return nil, err
+	}
+
+	sections := make(map[ksuid.KSUID]string, len(rows))
+	for _, row := range rows {
+		sections[row.Course] = row.Section
+	}
+	return sections, nil
+}
+
+func (s *Server) GetCourseRoster(ctx context.Context, course ksuid.KSUID) ([]*api.RosterStudent, error) {
+	tx := getTransaction(ctx)
+	roster := make([]*api.RosterStudent, 0)
+	err := tx.SelectContext(ctx, &roster,
+		"SELECT email, name, student_id, section FROM roster WHERE course=$1 ORDER BY email",
+		course,
+	)
+	return roster, err
+}
+
+func (s *Server) AddCourseRosterStudents(ctx context.Context, course ksuid.KSUID, students []*api.RosterStudent) error {
+	tx := getTransaction(ctx)
+
+	for _, student := range students {
+		_, err := tx.ExecContext(ctx,
+			"INSERT INTO roster (course, email, name, student_id, section) VALUES ($1, lower($2), $3, $4, $5) ON CONFLICT (course, email) DO UPDATE SET name=EXCLUDED.name, student_id=EXCLUDED.student_id, section=EXCLUDED.section",
+			course, student.Email, student.Name, student.StudentID, student.Section,
+		)
+		if err != nil {
+			return fmt.Errorf("failed to insert student %s into course %s roster: %w", student.Email, course, err)
+		}
+	}
+
+	return nil
+}
+
+func (s *Server) RemoveCourseRosterStudents(ctx context.Context, course ksuid.KSUID, students []string) error {
+	tx := getTransaction(ctx)
+	_, err := tx.ExecContext(ctx,
+		"DELETE FROM roster WHERE course=$1 AND email IN (SELECT lower(e) FROM unnest($2::text[]) e)",
+		course, pq.Array(students),
+	)
+	return err
+}
+
+// nullableJSON turns an empty JSON value into NULL.
+func nullableJSON(j json.RawMessage) *string {
+	if len(j) == 0 {
+		return nil
+	}
+	v := string(j)
+	return &v
+}
+
+func (s *Server) AddAuditRecord(ctx context.Context, record *api.AuditRecord) error {
+	tx := getTransaction(ctx)
+	_, err := tx.ExecContext(ctx,
+		"INSERT INTO audit_log (id, course, queue, actor, action, target, old_value, new_value) VALUES ($1, $2, $3, $4, $5, $6, $7, $8)",
+		record.ID, record.Course, record.Queue, record.Actor, record.Action, record.Target, nullableJSON(record.Before), nullableJSON(record.After),
+	)
+	return err
+}
+
+func (s *Server) GetAuditLog(ctx context.Context, course ksuid.KSUID, before ksuid.KSUID, limit int) ([]*api.AuditRecord, error) {
+	tx := getTransaction(ctx)
+	records := make([]*api.AuditRecord, 0)
+	err := tx.SelectContext(ctx, &records,
+		"SELECT id, course, queue, actor, action, target, COALESCE(old_value, 'null') AS old_value, COALESCE(new_value, 'null') AS new_value, created_at FROM audit_log WHERE course=$1 AND id<$2 ORDER BY id DESC LIMIT $3",
+		course, before, limit,
+	)
+	return records, err
+}
@@ -3,7 +3,9 @@ package db
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/CarsonHoffman/office-hours-queue/server/api"
@@ -15,7 +17,9 @@ func (s *Server) GetQueue(ctx context.Context, queue ksuid.KSUID) (*api.Queue, e
 	tx := getTransaction(ctx)
 	var q api.Queue
 	err := tx.GetContext(ctx, &q,
-		"SELECT id, course, type, name, location, map, active FROM queues q WHERE active AND id=$1",
+		`SELECT q.id, q.course, q.type, q.name, q.location, q.map, q.active, COALESCE(q.archived_at, c.archived_at) AS archived_at
+		FROM queues q JOIN courses c ON c.id=q.course
+		WHERE (q.active OR q.archived_at IS NOT NULL OR c.archived_at IS NOT NULL) AND q.id=$1`,
 		queue,
 	)
 	return &q, err
@@ -30,6 +34,25 @@ func (s *Server) UpdateQueue(ctx context.Context, queue ksuid.KSUID, values *api
 	return err
 }
 
+// ArchiveQueue takes a queue down for good while keeping its history.
+func (s *Server) ArchiveQueue(ctx context.Context, queue ksuid.KSUID) error {
+	tx := getTransaction(ctx)
+	_, err := tx.ExecContext(ctx,
+		"UPDATE queues SET archived_at=COALESCE(archived_at, NOW()), active=FALSE WHERE id=$1",
+		queue,
+	)
+	return err
+}
+
+func (s *Server) RestoreQueue(ctx context.Context, queue ksuid.KSUID, active bool) error {
+	tx := getTransaction(ctx)
+	_, err := tx.ExecContext(ctx,
+		"UPDATE queues SET archived_at=NULL, active=$1 WHERE id=$2",
+		active, queue,
+	)
+	return err
+}
+
 func (s *Server) RemoveQueue(ctx context.Context, queue ksuid.KSUID) error {
 	tx := getTransaction(ctx)
 	_, err := tx.ExecContext(ctx,
@@ -86,7 +109,7 @@ func (s *Server) GetQueueConfiguration(ctx context.Context, queue ksuid.KSUID) (
 	tx := getTransaction(ctx)
 	var config api.QueueConfiguration
 	err := tx.GetContext(ctx, &config,
-		"SELECT id, enable_location_field, prevent_unregistered, prevent_groups, prevent_groups_boost, prioritize_new, cooldown, virtual, scheduled, prompts, manual_open FROM queues WHERE id=$1",
+		"SELECT id, enable_location_field, prevent_unregistered, prevent_groups, prevent_groups_boost, prioritize_new, cooldown, virtual, scheduled, prompts, manual_open, last_call, booking_horizon, appointment_reminder, no_show_limit, no_show_penalty, staff_selection, sections FROM queues WHERE id=$1",
 		queue,
 	)
 	return &config, err
@@ -95,12 +118,24 @@ func (s *Server) GetQueueConfiguration(ctx context.Context, queue ksuid.KSUID) (
 func (s *Server) UpdateQueueConfiguration(ctx context.Context, queue ksuid.KSUID, config *api.QueueConfiguration) error {
 	tx := getTransaction(ctx)
 	_, err := tx.ExecContext(ctx,
-		"UPDATE queues SET enable_location_field=$1, prevent_unregistered=$2, prevent_groups=$3, prevent_groups_boost=$4, prioritize_new=$5, cooldown=$6, virtual=$7, scheduled=$8, prompts=$9 WHERE id=$10",
-		config.EnableLocationField, config.PreventUnregistered, config.PreventGroups, config.PreventGroupsBoost, config.PrioritizeNew, config.Cooldown, config.Virtual, config.Scheduled, config.Prompts, queue,
+		"UPDATE queues SET enable_location_field=$1, prevent_unregistered=$2, prevent_groups=$3, prevent_groups_boost=$4, prioritize_new=$5, cooldown=$6, virtual=$7, scheduled=$8, prompts=$9, last_call=$10, booking_horizon=$11, appointment_reminder=$12, no_show_limit=$13, no_show_penalty=$14, staff_selection=$15, sections=$16 WHERE id=$17",
+		config.EnableLocationField, config.PreventUnregistered, config.PreventGroups, config.PreventGroupsBoost, config.PrioritizeNew, config.Cooldown, config.Virtual, config.Scheduled, config.Prompts, config.LastCall, config.BookingHorizon, config.AppointmentReminder, config.NoShowLimit, config.NoShowPenalty, config.StaffSelection, config.Sections, queue,
 	)
 	return err
 }
 
+// GetLastCallQueues gets today's schedule for every active, scheduled
+// ordered queue that has a last call window.
+func (s *Server) GetLastCallQueues(ctx context.Context) ([]*api.QueueLastCall, error) {
+	tx := getTransaction(ctx)
+	queues := make([]*api.QueueLastCall, 0)
+	err := tx.SelectContext(ctx, &queues,
+		"SELECT q.id AS queue, q.last_call, s.schedule FROM queues q JOIN schedules s ON s.queue=q.id AND s.day=$1 WHERE q.active AND q.scheduled AND q.type='ordered' AND q.last_call > 0",
+		time.Now().Weekday(),
+	)
+	return queues, err
+}
+
 func (s *Server) UpdateQueueOpenStatus(ctx context.Context, queue ksuid.KSUID, open bool) error {
 	tx := getTransaction(ctx)
 	_, err := tx.ExecContext(ctx,
@@ -113,7 +148,10 @@ func (s *Server) UpdateQueueOpenStatus(ctx context.Context, queue ksuid.KSUID, o
 func (s *Server) GetQueueRoster(ctx context.Context, queue ksuid.KSUID) ([]string, error) {
 	tx := getTransaction(ctx)
 	roster := make([]string, 0)
-	err := tx.SelectContext(ctx, &roster, "SELECT email FROM roster WHERE queue=$1 ORDER BY email", queue)
+	err := tx.SelectContext(ctx, &roster,
+		"SELECT r.email FROM roster r JOIN queues q ON q.course=r.course WHERE q.id=$1 ORDER BY r.email",
+		queue,
+	)
 	return roster, err
 }
 
@@ -179,36 +217,22 @@ func (s *Server) UserInQueueRoster(ctx context.Context, queue ksuid.KSUID, email
 	tx := getTransaction(ctx)
 	var n int
 	err := tx.GetContext(ctx, &n,
-		"SELECT COUNT(*) FROM roster WHERE queue=$1 AND email=$2",
+		"SELECT COUNT(*) FROM roster r JOIN queues q ON q.course=r.course WHERE q.id=$1 AND r.email=lower($2)",
 		queue, email,
 	)
 	return n > 0, err
 }
 
-func (s *Server) UpdateQueueRoster(ctx context.Context, queue ksuid.KSUID, students []string) error {
+// UserInQueueSections checks whether the user is on the course roster in
+// one of the sections the queue is restricted to.
+func (s *Server) UserInQueueSections(ctx context.Context, queue ksuid.KSUID, email string) (bool, error) {
 	tx := getTransaction(ctx)
-
-	_, err := tx.Exec("DELETE FROM roster WHERE queue=$1", queue)
-	if err != nil {
-		return fmt.Errorf("failed to delete existing roster: %w", err)
-	}
-
-	insert, err := tx.Prepare(pq.CopyIn("roster", "queue", "email"))
-	if err != nil {
-		tx.Rollback()
-		return fmt.Errorf("failed to prepare insert statement: %w", err)
-	}
-	defer insert.Close()
-
-	for _, student := range students {
-		_, err = insert.Exec(queue, student)
-		if err != nil {
-			return fmt.Errorf("failed to insert student %s into roster: %w", student, err)
-		}
-	}
-
-	_, err = insert.Exec()
-	return err
+	var n int
+	err := tx.GetContext(ctx, &n,
+		"SELECT COUNT(*) FROM roster r JOIN queues q ON q.course=r.course WHERE q.id=$1 AND r.email=lower($2) AND r.section=ANY(q.sections)",
+		queue, email,
+	)
+	return n > 0, err
 }
 
 func (s *Server) TeammateInQueue(ctx context.Context, queue ksuid.KSUID, email string) (bool, error) {
@@ -236,6 +260,15 @@ func (s *Server) CanAddEntry(ctx context.Context, queue ksuid.KSUID, email strin
 		return true, nil
 	}
 
+	course, err := s.GetCourse(ctx, q.Course)
+	if err != nil {
+		return false, fmt.Errorf("failed to get course: %w", err)
+	}
+
+	if !course.InTerm(time.Now()) {
+		return false, errors.New("the course isn't in session right now")
+	}
+
 	config, err := s.GetQueueConfiguration(ctx, queue)
 	if err != nil {
 		return false, fmt.Errorf("failed to get queue configuration: %w", err)
@@ -250,6 +283,11 @@ func (s *Server) CanAddEntry(ctx context.Context, queue ksuid.KSUID, email strin
 		if schedule[halfHour] == 'c' {
 			return false, fmt.Errorf("the queue is closed")
 		}
+
+		closesAt, ok := api.ScheduleClosingTime(schedule, time.Now())
+		if ok && api.InLastCall(closesAt, config.LastCall, time.Now()) {
+			return false, fmt.Errorf("the queue closes at %s and is past last call for new sign ups", closesAt.Format("3:04 PM"))
+		}
 	} else if !config.ManualOpen {
 		return false, fmt.Errorf("the queue is closed")
 	}
@@ -265,6 +303,17 @@ func (s *Server) CanAddEntry(ctx context.Context, queue ksuid.KSUID, email strin
 		}
 	}
 
+	if len(config.Sections) > 0 {
+		inSections, err := s.UserInQueueSections(ctx, queue, email)
+		if err != nil {
+			return false, fmt.Errorf("failed to determine section in queue: %w", err)
+		}
+
+		if !inSections {
+			return false, fmt.Errorf("the queue is only for sections %s", strings.Join(config.Sections, ", "))
+		}
+	}
+
 	if config.PreventGroups {
 		teammateInQueue, err := s.TeammateInQueue(ctx, queue, email)
 		if err != nil {
@@ -292,7 +341,7 @@ func (s *Server) CanAddEntry(ctx context.Context, queue ksuid.KSUID, email strin
 		default:
 			e += fmt.Sprintf("%d minutes", int(wait.Minutes()))
 		}
-		return false, fmt.Errorf(e)
+		return false, errors.New(e)
 	}
 
 	return true, nil
@@ -584,3 +633,39 @@ func (s *Server) QueueStats() ([]api.QueueStats, error) {
 
 	return queues, nil
 }
+
+func (s *Server) SetEntryNote(ctx context.Context, note *api.StaffNote) (*api.StaffNote, error) {
+	tx := getTransaction(ctx)
+	var n api.StaffNote
+	err := tx.GetContext(ctx, &n,
+		`INSERT INTO staff_notes (id, queue, email, entry, author, note, category, resolution) VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		ON CONFLICT (entry) DO UPDATE SET author=EXCLUDED.author, note=EXCLUDED.note, category=EXCLUDED.category, resolution=EXCLUDED.resolution
+		RETURNING id, queue, email, entry, appointment, author, note, category, resolution`,
+		ksuid.New(), note.Queue, note.Email, note.Entry, note.Author, note.Note, note.Category, note.Resolution,
+	)
+	return &n, err
+}
+
+func (s *Server) SetAppointmentNote(ctx context.Context, note *api.StaffNote) (*api.StaffNote, error) {
+	tx := getTransaction(ctx)
+	var n api.StaffNote
+	err := tx.GetContext(ctx, &n,
+		`INSERT INTO staff_notes (id, queue, email, appointment, author, note, category, resolution) VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		ON CONFLICT (appointment) DO UPDATE SET author=EXCLUDED.author, note=EXCLUDED.note, category=EXCLUDED.category, resolution=EXCLUDED.resolution
+		RETURNING id, queue, email, entry, appointment, author, note, category, resolution`,
+		ksuid.New(), note.Queue, note.Email, note.Appointment, note.Author, note.Note, note.Category, note.Resolution,
+	)
+	return &n, err
+}
+
+// GetStaffNotes gets the notes staff have left about each of the students
+// on the queue, oldest first.
+func (s *Server) GetStaffNotes(ctx context.Context, queue ksuid.KSUID, emails []string) ([]*api.StaffNote, error) {
+	tx := getTransaction(ctx)
+	notes := make([]*api.StaffNote, 0)
+	err := tx.SelectContext(ctx, &notes,
+		"SELECT id, queue, email, entry, appointment, author, note, category, resolution FROM staff_notes WHERE queue=$1 AND email=ANY($2) ORDER BY id",
+		queue, pq.Array(emails),
+	)
+	return notes, err
+}
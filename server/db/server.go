@@ -7,7 +7,7 @@ import (
 	"github.com/CarsonHoffman/office-hours-queue/server/api"
 	"github.com/CarsonHoffman/office-hours-queue/server/config"
 	"github.com/jmoiron/sqlx"
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/collectors"
 )
@@ -59,3 +59,55 @@ func (s *Server) SiteAdmin(ctx context.Context, email string) (bool, error) {
 	)
 	return n > 0, err
 }
+
+func (s *Server) GetSiteAdmins(ctx context.Context) ([]string, error) {
+	tx := getTransaction(ctx)
+	admins := make([]string, 0)
+	err := tx.SelectContext(ctx, &admins, "SELECT email FROM site_admins ORDER BY email")
+	return admins, err
+}
+
+func (s *Server) AddSiteAdmins(ctx context.Context, admins []string) error {
+	tx := getTransaction(ctx)
+	for _, email := range admins {
+		_, err := tx.ExecContext(ctx,
+			"INSERT INTO site_admins (email) VALUES ($1) ON CONFLICT DO NOTHING",
+			email,
+		)
+		if err != nil {
+			return fmt.Errorf("failed to add site admin %s: %w", email, err)
+		}
+	}
+	return nil
+}
+
+func (s *Server) RemoveSiteAdmins(ctx context.Context, admins []string) error {
+	tx := getTransaction(ctx)
+	_, err := tx.ExecContext(ctx,
+		"DELETE FROM site_admins WHERE email=ANY($1)",
+		pq.Array(admins),
+	)
+	return err
+}
+
+// BootstrapSiteAdmin makes the email a site admin if there aren't any
+// yet, reporting whether it did. Bootstrapping only happens once: the
+// first time it's asked for is recorded, and every later call does
+// nothing, even if the site admins have all been removed since. It runs
+// at startup, outside of any request, so it doesn't use a request's
+// transaction.
+func (s *Server) BootstrapSiteAdmin(ctx context.Context, email string) (bool, error) {
+	result, err := s.DB.ExecContext(ctx,
+		`WITH bootstrap AS (
+			INSERT INTO site_admin_bootstrap (email) SELECT $1 WHERE NOT EXISTS (SELECT 1 FROM site_admin_bootstrap) RETURNING email
+		)
+		INSERT INTO site_admins (email) SELECT email FROM bootstrap WHERE NOT EXISTS (SELECT 1 FROM site_admins)`,
+		email,
+	)
+	if err != nil {
+		return false, err
+	}
+
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
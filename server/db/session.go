@@ -0,0 +1,114 @@
+package db
+
+import (
+	"context"
+
+	"github.com/CarsonHoffman/office-hours-queue/server/api"
+	"github.com/segmentio/ksuid"
+)
+
+// AddUserSession links a session in the session store to the user who
+// logged in with it. Logging in again with the same session replaces the
+// old link.
+func (s *Server) AddUserSession(ctx context.Context, email, key, ip, userAgent string) error {
+	tx := getTransaction(ctx)
+	_, err := tx.ExecContext(ctx,
+		`INSERT INTO user_sessions (id, email, session_key, ip, user_agent) VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (session_key) DO UPDATE SET email=EXCLUDED.email, ip=EXCLUDED.ip, user_agent=EXCLUDED.user_agent, created_at=NOW()`,
+		ksuid.New(), email, []byte(key), ip, userAgent,
+	)
+	return err
+}
+
+// TrackUserSession links a session to its user if it isn't linked
+// already, leaving existing links alone.
+func (s *Server) TrackUserSession(ctx context.Context, email, key, ip, userAgent string) error {
+	tx := getTransaction(ctx)
+	_, err := tx.ExecContext(ctx,
+		`INSERT INTO user_sessions (id, email, session_key, ip, user_agent) VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (session_key) DO NOTHING`,
+		ksuid.New(), email, []byte(key), ip, userAgent,
+	)
+	return err
+}
+
+// GetUntrackedSessions gets the live sessions in the session store that
+// aren't linked to a user, still encoded.
+func (s *Server) GetUntrackedSessions(ctx context.Context) ([]*api.StoredSession, error) {
+	tx := getTransaction(ctx)
+	sessions := make([]*api.StoredSession, 0)
+	err := tx.SelectContext(ctx, &sessions,
+		`SELECT h.key, h.data FROM http_sessions h
+		WHERE h.expires_on > NOW() AND NOT EXISTS (SELECT 1 FROM user_sessions u WHERE u.session_key=h.key)`,
+	)
+	return sessions, err
+}
+
+func (s *Server) GetUserSessions(ctx context.Context, email string) ([]*api.UserSession, error) {
+	tx := getTransaction(ctx)
+	sessions := make([]*api.UserSession, 0)
+	err := tx.SelectContext(ctx, &sessions,
+		`SELECT u.id, u.session_key, u.ip, u.user_agent, u.created_at, h.expires_on
+		FROM user_sessions u JOIN http_sessions h ON h.key=u.session_key
+		WHERE u.email=$1 AND h.expires_on > NOW() ORDER BY u.id`,
+		email,
+	)
+	return sessions, err
+}
+
+// RemoveUserSession logs out one of the user's sessions, returning
+// sql.ErrNoRows if they don't have a session with that ID.
+func (s *Server) RemoveUserSession(ctx context.Context, email string, session ksuid.KSUID) error {
+	tx := getTransaction(ctx)
+	var key []byte
+	err := tx.GetContext(ctx, &key,
+		"DELETE FROM user_sessions WHERE id=$1 AND email=$2 RETURNING session_key",
+		session, email,
+	)
+	if err != nil {
+		return err
+	}
+
+	// The session itself might already be gone if it expired, which is
+	// fine; it's logged out either way.
+	_, err = tx.ExecContext(ctx, "DELETE FROM http_sessions WHERE key=$1", key)
+	return err
+}
+
+// RemoveUserSessions logs out all of the user's sessions other than the
+// one with the given key, which can be empty to log out all of them. It
+// returns how many sessions were logged out. Emails are matched without
+// regard to case, since providers don't agree on it.
+func (s *Server) RemoveUserSessions(ctx context.Context, email, except string) (int64, error) {
+	tx := getTransaction(ctx)
+	result, err := tx.ExecContext(ctx,
+		`WITH removed AS (DELETE FROM user_sessions WHERE lower(email)=lower($1) AND session_key<>$2 RETURNING session_key)
+		DELETE FROM http_sessions WHERE key IN (SELECT session_key FROM removed)`,
+		email, []byte(except),
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// PurgeExpiredSessions deletes expired sessions from the session store,
+// along with the links to sessions that aren't there anymore because
+// they expired or were logged out.
+func (s *Server) PurgeExpiredSessions(ctx context.Context) (int64, error) {
+	tx := getTransaction(ctx)
+	result, err := tx.ExecContext(ctx, "DELETE FROM http_sessions WHERE expires_on < NOW()")
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = tx.ExecContext(ctx,
+		"DELETE FROM user_sessions u WHERE NOT EXISTS (SELECT 1 FROM http_sessions h WHERE h.key=u.session_key)",
+	)
+	return n, err
+}
@@ -0,0 +1,75 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/CarsonHoffman/office-hours-queue/server/api"
+	"github.com/segmentio/ksuid"
+)
+
+func (s *Server) AddAPIToken(ctx context.Context, token *api.APIToken, hash string) (*api.APIToken, error) {
+	tx := getTransaction(ctx)
+	var newToken api.APIToken
+	err := tx.GetContext(ctx, &newToken,
+		`INSERT INTO api_tokens (id, email, user_name, first_name, name, course, token_hash, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		RETURNING id, name, course, created_at, expires_at, last_used_at`,
+		ksuid.New(), token.Email, token.UserName, token.FirstName, token.Name, token.Course, hash, token.ExpiresAt,
+	)
+	return &newToken, err
+}
+
+func (s *Server) GetAPITokens(ctx context.Context, email string) ([]*api.APIToken, error) {
+	tx := getTransaction(ctx)
+	tokens := make([]*api.APIToken, 0)
+	err := tx.SelectContext(ctx, &tokens,
+		"SELECT id, name, course, created_at, expires_at, last_used_at FROM api_tokens WHERE email=$1 ORDER BY id",
+		email,
+	)
+	return tokens, err
+}
+
+// UseAPIToken gets the unexpired token with the given hash.
+func (s *Server) UseAPIToken(ctx context.Context, hash string) (*api.APIToken, error) {
+	tx := getTransaction(ctx)
+	var token api.APIToken
+	err := tx.GetContext(ctx, &token,
+		`SELECT id, email, user_name, first_name, name, course, created_at, expires_at, last_used_at
+		FROM api_tokens WHERE token_hash=$1 AND (expires_at IS NULL OR expires_at > NOW())`,
+		hash,
+	)
+	return &token, err
+}
+
+// MarkAPITokenUsed records that the token was just used. It's outside of
+// the request's transaction, so the token's row is only locked for as
+// long as the update takes, and the update sticks even if the request
+// fails.
+func (s *Server) MarkAPITokenUsed(ctx context.Context, token ksuid.KSUID) error {
+	_, err := s.DB.ExecContext(ctx, "UPDATE api_tokens SET last_used_at=NOW() WHERE id=$1", token)
+	return err
+}
+
+// RemoveAPIToken deletes one of the user's tokens, returning
+// sql.ErrNoRows if they don't have a token with that ID.
+func (s *Server) RemoveAPIToken(ctx context.Context, email string, token ksuid.KSUID) error {
+	tx := getTransaction(ctx)
+	result, err := tx.ExecContext(ctx,
+		"DELETE FROM api_tokens WHERE id=$1 AND email=$2",
+		token, email,
+	)
+	if err != nil {
+		return err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
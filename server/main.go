@@ -23,18 +23,30 @@ func main() {
 		l.Fatalw("failed to load configuration", "err", err)
 	}
 
-	// Initialize OIDC provider
-	provider, err := oidc.NewProvider(context.Background(), config.AppConfig.OIDCIssuerURL)
-	if err != nil {
-		l.Fatalw("failed to create OIDC provider", "err", err)
-	}
+	// Initialize OIDC providers
+	var providers []*api.OIDCProvider
+	for _, p := range config.AppConfig.OIDCProviders {
+		provider, err := oidc.NewProvider(context.Background(), p.IssuerURL)
+		if err != nil {
+			l.Fatalw("failed to create OIDC provider", "provider", p.Name, "err", err)
+		}
 
-	oauthConfig := oauth2.Config{
-		Endpoint:     provider.Endpoint(),
-		ClientID:     config.AppConfig.OAuth2ClientID,
-		ClientSecret: config.AppConfig.OAuth2ClientSecret,
-		RedirectURL:  config.AppConfig.OAuth2RedirectURI,
-		Scopes:       []string{oidc.ScopeOpenID, "email", "profile", "eduperson_entitlement"},
+		providers = append(providers, &api.OIDCProvider{
+			Name:        p.Name,
+			DisplayName: p.DisplayName,
+			Provider:    provider,
+			OAuth2: oauth2.Config{
+				Endpoint:     provider.Endpoint(),
+				ClientID:     p.ClientID,
+				ClientSecret: p.ClientSecret,
+				RedirectURL:  p.RedirectURI,
+				Scopes:       p.Scopes,
+			},
+			UsePKCE:        *p.UsePKCE,
+			Claims:         p.Claims,
+			AllowedDomains: p.AllowedDomains,
+			TrustGroups:    *p.TrustGroups,
+		})
 	}
 
 	// Initialize database
@@ -48,8 +60,22 @@ func main() {
 		l.Fatalw("failed to set up database", "err", err)
 	}
 
+	// Make the bootstrap site admin, if there aren't any site admins yet
+	// and it hasn't been done before
+	if email := config.AppConfig.BootstrapSiteAdmin; email != "" {
+		added, err := db.BootstrapSiteAdmin(context.Background(), email)
+		if err != nil {
+			l.Fatalw("failed to bootstrap site admin", "err", err)
+		}
+		if added {
+			l.Infow("added bootstrap site admin", "email", email)
+		} else {
+			l.Warnw("site admins were already bootstrapped; QUEUE_BOOTSTRAP_SITE_ADMIN is ignored and should be unset", "email", email)
+		}
+	}
+
 	// Initialize API server
-	s := api.New(db, l, db.DB.DB, provider, oauthConfig)
+	s := api.New(db, l, db.DB.DB, providers)
 
 	r := chi.NewRouter()
 	r.Mount("/", s)